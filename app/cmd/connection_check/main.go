@@ -5,39 +5,18 @@ import (
 	"database/sql"
 	"fmt"
 	"os"
-	"strconv"
 	"strings"
 
-	_ "github.com/lib/pq"
+	"postgres-replication-demo/replication"
 )
 
-// getEnv 環境変数を取得、存在しない場合はデフォルト値を返す
-func getEnv(key, defaultValue string) string {
-	if value := os.Getenv(key); value != "" {
-		return value
-	}
-	return defaultValue
-}
-
 // testConnection 指定されたホスト・ポートでの接続をテスト
-func testConnection(host string, port int, description string) bool {
+func testConnection(cfg replication.Config, endpoint replication.Endpoint, description string) bool {
 	fmt.Printf("🔗 %sへの接続をテスト中...\n", description)
-	fmt.Printf("   ホスト: %s:%d\n", host, port)
-
-	// 接続文字列を構築
-	// デモ用のデフォルト値を使用。本番環境では環境変数を使用してください。
-	dbUser := getEnv("POSTGRES_USER", "postgres")
-	dbPassword := getEnv("POSTGRES_PASSWORD", "password")
-	dbName := getEnv("POSTGRES_DB", "testdb")
+	fmt.Printf("   ホスト: %s\n", endpoint)
 
-	connStr := fmt.Sprintf("host=%s port=%d user=%s password=%s dbname=%s sslmode=disable connect_timeout=10",
-		host, port, dbUser, dbPassword, dbName)
-
-	db, err := sql.Open("postgres", connStr)
-	if err != nil {
-		fmt.Printf("   ❌ 接続失敗: %v\n", err)
-		return false
-	}
+	// デモ用のデフォルト値を使用。本番環境ではPOSTGRES_PASSWORD_FILEなどで認証情報を指定してください。
+	db := sql.OpenDB(replication.NewCredentialConnector(cfg, endpoint))
 	defer func() {
 		if closeErr := db.Close(); closeErr != nil {
 			fmt.Printf("   ⚠️ DB接続クローズエラー: %v\n", closeErr)
@@ -45,7 +24,7 @@ func testConnection(host string, port int, description string) bool {
 	}()
 
 	// 接続テスト
-	err = db.Ping()
+	err := db.Ping()
 	if err != nil {
 		fmt.Printf("   ❌ 接続失敗: %v\n", err)
 		return false
@@ -86,30 +65,58 @@ func testConnection(host string, port int, description string) bool {
 	return true
 }
 
+// testTargetSessionAttrs 全ノードを候補として、target_session_attrsに応じた接続先を確認
+func testTargetSessionAttrs(cfg replication.Config, attrs replication.TargetSessionAttrs) {
+	fmt.Printf("   target_session_attrs=%s: ", attrs)
+	db := sql.OpenDB(replication.NewMultiHostConnectorFromConfig(cfg, attrs))
+	defer func() { _ = db.Close() }()
+
+	var addr string
+	var isRecovery bool
+	err := db.QueryRow("SELECT coalesce(host(inet_server_addr()), 'local') || ':' || current_setting('port'), pg_is_in_recovery()").
+		Scan(&addr, &isRecovery)
+	if err != nil {
+		fmt.Printf("❌ %v\n", err)
+		return
+	}
+	serverType := "プライマリ"
+	if isRecovery {
+		serverType = "スタンバイ"
+	}
+	fmt.Printf("✅ %s (%s)\n", serverType, addr)
+}
+
 func main() {
 	fmt.Println("🎯 PostgreSQL接続テスト")
 	fmt.Println(strings.Repeat("=", 50))
 
-	// 環境変数からホスト情報を取得
-	primaryHost := getEnv("POSTGRES_PRIMARY_HOST", "localhost")
-	primaryPort, _ := strconv.Atoi(getEnv("POSTGRES_PRIMARY_PORT", "5432"))
-	standbyHost := getEnv("POSTGRES_STANDBY_HOST", "localhost")
-	standbyPort, _ := strconv.Atoi(getEnv("POSTGRES_STANDBY_PORT", "5433"))
-	
-	// IPv4を強制するためにlocalhostを2127.0.0.1に変換
-	if primaryHost == "localhost" {
-		primaryHost = "127.0.0.1"
-	}
-	if standbyHost == "localhost" {
-		standbyHost = "127.0.0.1"
+	// 環境変数から接続情報を取得
+	cfg, err := replication.Load()
+	if err != nil {
+		fmt.Printf("❌ 設定読み込みエラー: %v\n", err)
+		os.Exit(1)
 	}
 
 	// プライマリサーバーテスト
-	primaryOK := testConnection(primaryHost, primaryPort, "プライマリサーバー")
+	primaryOK := testConnection(cfg, cfg.Primary, "プライマリサーバー")
 	fmt.Println()
 
 	// スタンバイサーバーテスト
-	standbyOK := testConnection(standbyHost, standbyPort, "スタンバイサーバー")
+	standbyResults := make([]bool, len(cfg.Standbys))
+	standbyOK := true
+	for i, sc := range cfg.Standbys {
+		standbyResults[i] = testConnection(cfg, sc.Endpoint, "スタンバイサーバー")
+		standbyOK = standbyOK && standbyResults[i]
+		fmt.Println()
+	}
+
+	// 複数ホスト指定での接続先選択
+	fmt.Println("🔀 複数ホスト指定での接続先選択:")
+	for _, attrs := range []replication.TargetSessionAttrs{
+		replication.SessionReadWrite, replication.SessionStandby, replication.SessionPreferStandby,
+	} {
+		testTargetSessionAttrs(cfg, attrs)
+	}
 	fmt.Println()
 
 	// 結果サマリー
@@ -120,10 +127,12 @@ func main() {
 		fmt.Println("   プライマリ: ❌ NG")
 	}
 
-	if standbyOK {
-		fmt.Println("   スタンバイ: ✅ OK")
-	} else {
-		fmt.Println("   スタンバイ: ❌ NG")
+	for i, sc := range cfg.Standbys {
+		if standbyResults[i] {
+			fmt.Printf("   スタンバイ (%s): ✅ OK\n", sc.Endpoint)
+		} else {
+			fmt.Printf("   スタンバイ (%s): ❌ NG\n", sc.Endpoint)
+		}
 	}
 
 	if primaryOK && standbyOK {
@@ -2,198 +2,193 @@
 package main
 
 import (
-	"database/sql"
+	"context"
+	"errors"
 	"fmt"
 	"os"
-	"os/exec"
-	"strconv"
+	"os/signal"
 	"strings"
 	"time"
 
-	_ "github.com/lib/pq"
+	"postgres-replication-demo/replication"
 )
 
-// getEnv 環境変数を取得、存在しない場合はデフォルト値を返す
-func getEnv(key, defaultValue string) string {
-	if value := os.Getenv(key); value != "" {
-		return value
-	}
-	return defaultValue
-}
-
-// ReplicationData レプリケーションデータ構造体
-type ReplicationData struct {
-	ID        int
-	Data      string
-	CreatedAt time.Time
+// ReplicationDemo レプリケーションデモ実行クラス
+type ReplicationDemo struct {
+	DB *replication.Cluster
 }
 
-// ReplicationDatabase レプリケーション環境でのデータベース操作クラス
-type ReplicationDatabase struct {
-	StandbyDB *sql.DB
-}
+// operationTimeout 1回のDB操作に許す最大時間
+const operationTimeout = 10 * time.Second
 
-// NewReplicationDatabase 新しいReplicationDatabaseインスタンスを作成
-func NewReplicationDatabase() (*ReplicationDatabase, error) {
-	// 環境変数から接続情報を取得（デモ用デフォルト値付き）
-	dbUser := getEnv("POSTGRES_USER", "postgres")
-	dbPassword := getEnv("POSTGRES_PASSWORD", "password")
-	dbName := getEnv("POSTGRES_DB", "testdb")
-
-	// Docker環境では異なるホスト名とポートを使用
-	standbyHost := getEnv("POSTGRES_STANDBY_HOST", "localhost")
-	standbyPort := getEnv("POSTGRES_STANDBY_PORT", "5433")
-	
-	// IPv4を強制するためにlocalhostを2127.0.0.1に変換
-	if standbyHost == "localhost" {
-		standbyHost = "127.0.0.1"
-	}
-	
-	standbyConnStr := fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s sslmode=disable",
-		standbyHost, standbyPort, dbUser, dbPassword, dbName)
-	standbyDB, err := sql.Open("postgres", standbyConnStr)
+// NewReplicationDemo 新しいReplicationDemoインスタンスを作成
+func NewReplicationDemo(ctx context.Context) (*ReplicationDemo, error) {
+	ctx, cancel := context.WithTimeout(ctx, operationTimeout)
+	defer cancel()
+	cfg, err := replication.Load()
 	if err != nil {
-		return nil, fmt.Errorf("スタンバイDB接続エラー: %v", err)
+		return nil, err
 	}
-
-	err = standbyDB.Ping()
+	db, err := replication.OpenContext(ctx, cfg)
 	if err != nil {
-		_ = standbyDB.Close()
-		return nil, fmt.Errorf("スタンバイDB ping エラー: %v", err)
+		return nil, err
 	}
 
 	fmt.Println("✅ レプリケーションデータベース接続を初期化")
-	fmt.Println("   - 読み取り: スタンバイ (localhost:5433)")
-	fmt.Println("   - 書き込み: プライマリ (Docker経由)")
-
-	return &ReplicationDatabase{
-		StandbyDB: standbyDB,
-	}, nil
-}
-
-// Close データベース接続を閉じる
-func (r *ReplicationDatabase) Close() {
-	if r.StandbyDB != nil {
-		_ = r.StandbyDB.Close()
+	for _, n := range db.Standbys {
+		fmt.Printf("   - 読み取り: スタンバイ %s (重み: %d)\n", n.Name, n.Weight)
 	}
-}
-
-// WriteToPrimary プライマリサーバーにデータを書き込み（Docker経由）
-func (r *ReplicationDatabase) WriteToPrimary(dataText string) bool {
-	cmd := exec.Command("docker", "exec", "postgres-primary",
-		"psql", "-U", "postgres", "-d", "testdb",
-		"-c", fmt.Sprintf("INSERT INTO test_replication (data) VALUES ('%s') RETURNING id, created_at;", dataText))
-
-	output, err := cmd.CombinedOutput()
-	if err != nil {
-		fmt.Printf("❌ 書き込み失敗: %v\n", err)
-		return false
-	}
-
-	// 結果をパース（簡易版）
-	lines := strings.Split(strings.TrimSpace(string(output)), "\n")
-	for _, line := range lines {
-		if strings.Contains(line, "|") && !strings.Contains(strings.ToLower(line), "id") {
-			parts := strings.Split(line, "|")
-			if len(parts) >= 2 {
-				rowID := strings.TrimSpace(parts[0])
-				fmt.Printf("📝 プライマリに書き込み成功: ID=%s, データ='%s'\n", rowID, dataText)
-				return true
+	fmt.Printf("   - スタンバイ選択方式: %s\n", db.Config.RoutingPolicy)
+	fmt.Printf("   - 書き込み: プライマリ (%s)\n", db.Config.Primary)
+
+	db.StartHealthCheck(replication.HealthCheckConfig{
+		Interval: 2 * time.Second,
+		OnStateChange: func(e replication.HealthEvent) {
+			if e.Healthy {
+				fmt.Printf("💚 ノード復帰: %s (%s)\n", e.Node, e.Endpoint)
+			} else {
+				fmt.Printf("💔 ノードダウン: %s (%s): %v\n", e.Node, e.Endpoint, e.Err)
 			}
-		}
+		},
+	})
+
+	// 更新のない時間帯でも遅延を計測できるよう、プライマリにハートビートを書き込む
+	db.StartHeartbeat(replication.HeartbeatConfig{
+		Interval: 200 * time.Millisecond,
+		OnError: func(err error) {
+			fmt.Printf("⚠️ ハートビート書き込み失敗: %v\n", err)
+		},
+	})
+
+	// 読み取り回数などのカウンタをプライマリに書き込み、replication_exporterから出力できるようにする
+	db.StartMetricsPublisher(replication.MetricsPublisherConfig{
+		Interval: 5 * time.Second,
+		OnError: func(err error) {
+			fmt.Printf("⚠️ カウンタ書き込み失敗: %v\n", err)
+		},
+	})
+
+	// standby_slotが非アクティブのまま放置されてプライマリのディスクを圧迫しないよう監視する
+	db.StartSlotMonitor(replication.SlotMonitorConfig{
+		Interval:         30 * time.Second,
+		MaxInactive:      5 * time.Minute,
+		MaxRetainedBytes: 1 << 30,
+		MinSafeWALSize:   256 << 20,
+		OnAlert: func(a replication.SlotAlert) {
+			if a.Firing {
+				fmt.Printf("🚨 スロットアラート: %s: %s\n", a.Slot, a.Message)
+			} else {
+				fmt.Printf("✅ スロットアラート解消: %s (%s)\n", a.Slot, a.Kind)
+			}
+		},
+		OnError: func(err error) {
+			fmt.Printf("⚠️ スロット状態取得失敗: %v\n", err)
+		},
+	})
+
+	// トポロジーファイルの変更やSIGHUPでスタンバイの追加・削除を反映する
+	if path := os.Getenv(replication.TopologyFileEnv); path != "" {
+		db.WatchTopology(replication.TopologyWatchConfig{
+			Path: path,
+			OnReload: func(cfg replication.Config, err error) {
+				if err != nil {
+					fmt.Printf("⚠️ トポロジー再読み込み失敗: %v\n", err)
+					return
+				}
+				fmt.Printf("🔄 トポロジーを再読み込み: スタンバイ%d台\n", len(cfg.Standbys))
+			},
+		})
+		fmt.Printf("   - トポロジーファイル: %s（変更またはSIGHUPで再読み込み）\n", path)
 	}
 
-	fmt.Printf("📝 プライマリに書き込み成功: データ='%s'\n", dataText)
-	return true
+	return &ReplicationDemo{DB: db}, nil
 }
 
-// ReadFromStandby スタンバイサーバーからデータを読み取り
-func (r *ReplicationDatabase) ReadFromStandby(limit int) ([]ReplicationData, error) {
-	query := "SELECT id, data, created_at FROM test_replication ORDER BY created_at DESC LIMIT $1"
-	rows, err := r.StandbyDB.Query(query, limit)
+// writeToPrimary プライマリに書き込み、結果を表示。書き込み後のLSNを返す
+func (rd *ReplicationDemo) writeToPrimary(ctx context.Context, dataText string) (replication.LSN, bool) {
+	ctx, cancel := context.WithTimeout(ctx, operationTimeout)
+	defer cancel()
+	row, lsn, err := rd.DB.WriteToPrimaryContext(ctx, dataText)
 	if err != nil {
-		return nil, fmt.Errorf("データ読み取りエラー: %v", err)
+		fmt.Printf("❌ 書き込み失敗: %v\n", err)
+		return 0, false
 	}
-	defer func() { _ = rows.Close() }()
+	fmt.Printf("📝 プライマリに書き込み成功: ID=%d, データ='%s', LSN=%s\n", row.ID, row.Data, lsn)
+	return lsn, true
+}
 
-	var results []ReplicationData
-	for rows.Next() {
-		var data ReplicationData
-		err := rows.Scan(&data.ID, &data.Data, &data.CreatedAt)
-		if err != nil {
-			return nil, fmt.Errorf("データスキャンエラー: %v", err)
-		}
-		results = append(results, data)
+// readFromStandby スタンバイから読み取り、件数を表示
+func (rd *ReplicationDemo) readFromStandby(ctx context.Context, limit int, opts ...replication.ReadOption) ([]replication.ReplicationData, error) {
+	ctx, cancel := context.WithTimeout(ctx, operationTimeout)
+	defer cancel()
+	results, err := rd.DB.ReadFromStandbyContext(ctx, limit, opts...)
+	if err != nil {
+		return nil, err
 	}
-
 	fmt.Printf("📖 スタンバイから読み取り: %d件のデータを取得\n", len(results))
 	return results, nil
 }
 
-// GetReplicationStatus レプリケーション状態を取得（プライマリから）
-func (r *ReplicationDatabase) GetReplicationStatus() *float64 {
-	cmd := exec.Command("docker", "exec", "postgres-primary",
-		"psql", "-U", "postgres", "-d", "testdb",
-		"-c", `SELECT 
-			client_addr, state, sent_lsn, write_lsn, flush_lsn, replay_lsn,
-			CASE WHEN replay_lsn IS NOT NULL THEN 
-				EXTRACT(EPOCH FROM (now() - pg_last_xact_replay_timestamp()))
-			END AS lag_seconds
-			FROM pg_stat_replication;`)
-
-	output, err := cmd.CombinedOutput()
-	if err != nil {
+// printReplicationStatus レプリケーション状態を表示
+func (rd *ReplicationDemo) printReplicationStatus(ctx context.Context) {
+	ctx, cancel := context.WithTimeout(ctx, operationTimeout)
+	defer cancel()
+	statuses, err := rd.DB.StandbyStatusesContext(ctx)
+	switch {
+	case err != nil:
 		fmt.Printf("❌ レプリケーション状態取得失敗: %v\n", err)
-		return nil
-	}
-
-	lines := strings.Split(strings.TrimSpace(string(output)), "\n")
-	for _, line := range lines {
-		if strings.Contains(line, "streaming") {
-			parts := strings.Split(line, "|")
-			if len(parts) >= 7 {
-				clientAddr := strings.TrimSpace(parts[0])
-				state := strings.TrimSpace(parts[1])
-				lagStr := strings.TrimSpace(parts[6])
-
-				var lagValue float64 = 0
-				if lagStr != "" && lagStr != " " {
-					if parsed, err := strconv.ParseFloat(lagStr, 64); err == nil {
-						lagValue = parsed
-					}
-				}
+	case len(statuses) == 0:
+		fmt.Println("⚠️  接続中のスタンバイがありません")
+	}
+	for _, s := range statuses {
+		fmt.Printf("⏱️  %s (%s): %s/%s 再生遅延 %.3f秒 / %d bytes (replay_lsn %s)\n",
+			s.ApplicationName, s.ClientAddr, s.State, s.SyncState, s.ReplayLag.Seconds(), s.LagBytes, s.ReplayLSN)
+	}
 
-				fmt.Printf("⏱️  レプリケーション状態: %s, 遅延: %.3f秒 (クライアント: %s)\n",
-					state, lagValue, clientAddr)
-				return &lagValue
+	slots, err := rd.DB.ReplicationSlotsContext(ctx)
+	if err != nil {
+		fmt.Printf("❌ レプリケーションスロット取得失敗: %v\n", err)
+	}
+	for _, s := range slots {
+		state := "アクティブ"
+		if !s.Active {
+			state = "非アクティブ"
+			if d := s.InactiveFor(time.Now()); d > 0 {
+				state += fmt.Sprintf(" %s", d.Truncate(time.Second))
 			}
 		}
+		fmt.Printf("🎰 スロット %s: %s restart_lsn %s 保持WAL %d bytes wal_status=%s\n",
+			s.Name, state, s.RestartLSN, s.RetainedBytes, s.WALStatus)
 	}
 
-	fmt.Println("✅ レプリケーション接続確認済み")
-	zero := 0.0
-	return &zero
-}
-
-// GetDataCount 現在のデータ件数を取得
-func (r *ReplicationDatabase) GetDataCount() (int, error) {
-	var count int
-	err := r.StandbyDB.QueryRow("SELECT count(*) FROM test_replication").Scan(&count)
-	return count, err
-}
-
-// ReplicationDemo レプリケーションデモ実行クラス
-type ReplicationDemo struct {
-	DB *ReplicationDatabase
+	// スタンバイ側から見た状態（プライマリに接続できなくても取得できる）
+	for _, n := range rd.DB.StandbyNodes() {
+		r, err := n.ReceiverStatusContext(ctx)
+		if err != nil {
+			fmt.Printf("❌ %s: WALレシーバー状態取得失敗: %v\n", n.Name, err)
+			continue
+		}
+		receiver := "停止中"
+		if r.Receiver != nil {
+			receiver = fmt.Sprintf("%s (上流 %s, スロット %s)", r.Receiver.Status, r.Receiver.Upstream(), r.Receiver.SlotName)
+		}
+		paused := ""
+		if r.ReplayPaused {
+			paused = " ⏸️ 再生停止中"
+		}
+		fmt.Printf("📥 %s: WALレシーバー %s 受信 %s / 再生 %s（未再生 %d bytes）%s\n",
+			n.Name, receiver, r.ReceiveLSN, r.ReplayLSN, r.ReplayGapBytes, paused)
+		if lag, _, err := n.HeartbeatLagContext(ctx); err == nil {
+			fmt.Printf("💓 %s: ハートビート遅延 %.3f秒\n", n.Name, lag.Seconds())
+		}
+	}
 }
 
-// NewReplicationDemo 新しいReplicationDemoインスタンスを作成
-func NewReplicationDemo() (*ReplicationDemo, error) {
-	db, err := NewReplicationDatabase()
-	if err != nil {
-		return nil, err
-	}
-	return &ReplicationDemo{DB: db}, nil
+// getDataCount データ件数を取得
+func (rd *ReplicationDemo) getDataCount(ctx context.Context, opts ...replication.ReadOption) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, operationTimeout)
+	defer cancel()
+	return rd.DB.GetDataCountContext(ctx, opts...)
 }
 
 // Close リソースのクリーンアップ
@@ -204,13 +199,13 @@ func (rd *ReplicationDemo) Close() {
 }
 
 // RunBasicDemo 基本的な読み書き分離デモ
-func (rd *ReplicationDemo) RunBasicDemo() bool {
+func (rd *ReplicationDemo) RunBasicDemo(ctx context.Context) bool {
 	fmt.Println("\n" + strings.Repeat("=", 60))
 	fmt.Println("🚀 基本的な読み書き分離デモを開始")
 	fmt.Println(strings.Repeat("=", 60))
 
 	// 1. 現在のデータ確認
-	initialCount, err := rd.DB.GetDataCount()
+	initialCount, err := rd.getDataCount(ctx)
 	if err != nil {
 		fmt.Printf("❌ 初期データ件数取得エラー: %v\n", err)
 		return false
@@ -219,30 +214,35 @@ func (rd *ReplicationDemo) RunBasicDemo() bool {
 
 	// 2. データ書き込み（プライマリ）
 	testData := fmt.Sprintf("Demo data at %s", time.Now().Format("2006-01-02 15:04:05"))
-	writeSuccess := rd.DB.WriteToPrimary(testData)
+	lsn, writeSuccess := rd.writeToPrimary(ctx, testData)
 
 	if !writeSuccess {
 		fmt.Println("❌ 書き込みに失敗したため、デモを中断します")
 		return false
 	}
 
-	// 3. レプリケーション遅延チェック
-	time.Sleep(1 * time.Second)
-	rd.DB.GetReplicationStatus()
-
-	// 4. データ読み取り（スタンバイ）
-	standbyData, err := rd.DB.ReadFromStandby(5)
+	// 3. データ読み取り（スタンバイが書き込みのLSNを再生するまで待機）
+	standbyData, err := rd.readFromStandby(ctx, 5, replication.AfterLSN(lsn))
 	if err != nil {
 		fmt.Printf("❌ スタンバイデータ読み取りエラー: %v\n", err)
 		return false
 	}
 
-	// 5. 同期確認
-	finalCount, err := rd.DB.GetDataCount()
+	// 4. レプリケーション遅延チェック
+	rd.printReplicationStatus(ctx)
+
+	// 5. 同期確認（スタンバイの遅延が5秒を超える場合はプライマリで集計）
+	var served replication.ReadInfo
+	finalCount, err := rd.getDataCount(ctx,
+		replication.WithMaxStaleness(5*time.Second), replication.ServedBy(&served))
 	if err != nil {
 		fmt.Printf("❌ 最終データ件数取得エラー: %v\n", err)
 		return false
 	}
+	fmt.Printf("📍 件数集計ノード: %s (%s)\n", served.Route, served.Node)
+	if served.Reason != "" {
+		fmt.Printf("   理由: %s\n", served.Reason)
+	}
 
 	fmt.Printf("\n📊 データ同期結果:\n")
 	fmt.Printf("   開始時: %d件\n", initialCount)
@@ -263,7 +263,7 @@ func (rd *ReplicationDemo) RunBasicDemo() bool {
 }
 
 // RunPerformanceTest パフォーマンステスト
-func (rd *ReplicationDemo) RunPerformanceTest(iterations int) {
+func (rd *ReplicationDemo) RunPerformanceTest(ctx context.Context, iterations int) {
 	fmt.Printf("\n" + strings.Repeat("=", 60) + "\n")
 	fmt.Printf("⚡ パフォーマンステスト開始 (%d回)\n", iterations)
 	fmt.Println(strings.Repeat("=", 60))
@@ -277,7 +277,7 @@ func (rd *ReplicationDemo) RunPerformanceTest(iterations int) {
 		// 書き込み性能測定
 		startTime := time.Now()
 		testData := fmt.Sprintf("Performance test #%d at %s", i+1, time.Now().Format("2006-01-02T15:04:05"))
-		success := rd.DB.WriteToPrimary(testData)
+		lsn, success := rd.writeToPrimary(ctx, testData)
 		writeTime := time.Since(startTime).Seconds()
 
 		if success {
@@ -288,12 +288,9 @@ func (rd *ReplicationDemo) RunPerformanceTest(iterations int) {
 			continue
 		}
 
-		// 短い待機
-		time.Sleep(500 * time.Millisecond)
-
-		// 読み取り性能測定
+		// 読み取り性能測定（スタンバイが書き込みのLSNを再生するまでの待機を含む）
 		startTime = time.Now()
-		_, err := rd.DB.ReadFromStandby(1)
+		_, err := rd.readFromStandby(ctx, 1, replication.AfterLSN(lsn))
 		readTime := time.Since(startTime).Seconds()
 		if err == nil {
 			readTimes = append(readTimes, readTime)
@@ -315,14 +312,60 @@ func (rd *ReplicationDemo) RunPerformanceTest(iterations int) {
 
 		// 最終的なレプリケーション状態確認
 		fmt.Printf("\n📊 最終レプリケーション状態:\n")
-		rd.DB.GetReplicationStatus()
+		rd.printReplicationStatus(ctx)
+
+		m := rd.DB.Metrics()
+		fmt.Printf("🔁 リカバリ競合: %d回 (リトライ: %d回, 上限到達: %d回)\n",
+			m.RecoveryConflicts, m.Retries, m.RetriesExhausted)
 	} else {
 		fmt.Println("❌ 有効なパフォーマンスデータが取得できませんでした")
 	}
 }
 
+// RunBulkInsertTest COPYによる一括書き込みの性能測定
+func (rd *ReplicationDemo) RunBulkInsertTest(ctx context.Context, total, batchSize int) {
+	fmt.Printf("\n" + strings.Repeat("=", 60) + "\n")
+	fmt.Printf("📦 一括書き込みテスト開始 (%d行, %d行/バッチ)\n", total, batchSize)
+	fmt.Println(strings.Repeat("=", 60))
+
+	baseTime := time.Now().Format("2006-01-02T15:04:05")
+	rows := make([]replication.ReplicationData, total)
+	for i := range rows {
+		rows[i].Data = fmt.Sprintf("Bulk test #%d at %s", i+1, baseTime)
+	}
+
+	initialCount, err := rd.getDataCount(ctx)
+	if err != nil {
+		fmt.Printf("❌ 初期データ件数取得エラー: %v\n", err)
+		return
+	}
+
+	start := time.Now()
+	results, err := rd.DB.BulkInsertContext(ctx, rows, batchSize)
+	elapsed := time.Since(start)
+	for i, r := range results {
+		fmt.Printf("   📝 バッチ %d: %d行 %.3f秒 (%.0f行/秒) LSN=%s\n",
+			i+1, r.Rows, r.Duration.Seconds(), r.RowsPerSecond(), r.LSN)
+	}
+	if err != nil {
+		fmt.Printf("❌ 一括書き込み失敗: %v\n", err)
+		return
+	}
+	fmt.Printf("\n📈 一括書き込み結果: %d行 %.3f秒 (%.0f行/秒)\n",
+		total, elapsed.Seconds(), float64(total)/elapsed.Seconds())
+
+	// 最後のバッチのLSNまでスタンバイが再生してから件数を確認
+	lastLSN := results[len(results)-1].LSN
+	finalCount, err := rd.getDataCount(ctx, replication.AfterLSN(lastLSN))
+	if err != nil {
+		fmt.Printf("❌ 最終データ件数取得エラー: %v\n", err)
+		return
+	}
+	fmt.Printf("📖 スタンバイの件数: %d件 → %d件 (+%d件)\n", initialCount, finalCount, finalCount-initialCount)
+}
+
 // RunDataConsistencyCheck データ整合性チェック
-func (rd *ReplicationDemo) RunDataConsistencyCheck() bool {
+func (rd *ReplicationDemo) RunDataConsistencyCheck(ctx context.Context) bool {
 	fmt.Printf("\n" + strings.Repeat("=", 60) + "\n")
 	fmt.Println("🔍 データ整合性チェック")
 	fmt.Println(strings.Repeat("=", 60))
@@ -332,25 +375,23 @@ func (rd *ReplicationDemo) RunDataConsistencyCheck() bool {
 	baseTime := time.Now().Format("20060102_150405")
 
 	successCount := 0
+	var lastLSN replication.LSN
 	for i := 0; i < 3; i++ {
 		data := fmt.Sprintf("Consistency test %d - %s", i+1, baseTime)
-		success := rd.DB.WriteToPrimary(data)
+		lsn, success := rd.writeToPrimary(ctx, data)
 		if success {
 			fmt.Printf("   ✅ データ%d書き込み完了\n", i+1)
 			successCount++
+			lastLSN = lsn
 		} else {
 			fmt.Printf("   ❌ データ%d書き込み失敗\n", i+1)
 		}
-		time.Sleep(300 * time.Millisecond)
 	}
 
-	// 2. レプリケーション待機
-	fmt.Println("\n⏱️  レプリケーション完了待機...")
-	time.Sleep(2 * time.Second)
-
-	// 3. データ読み取りと確認
+	// 2. 最後の書き込みのLSNまでスタンバイが再生してから読み取り
+	fmt.Printf("\n⏱️  レプリケーション完了待機 (LSN=%s)...\n", lastLSN)
 	fmt.Println("\n📖 整合性確認...")
-	data, err := rd.DB.ReadFromStandby(5)
+	data, err := rd.readFromStandby(ctx, 5, replication.AfterLSN(lastLSN))
 	if err != nil {
 		fmt.Printf("❌ データ読み取りエラー: %v\n", err)
 		return false
@@ -377,6 +418,70 @@ func (rd *ReplicationDemo) RunDataConsistencyCheck() bool {
 	}
 }
 
+// RunRepositoryDemo リポジトリによるCRUDとページングのデモ
+func (rd *ReplicationDemo) RunRepositoryDemo(ctx context.Context) bool {
+	fmt.Printf("\n" + strings.Repeat("=", 60) + "\n")
+	fmt.Println("🗂️  リポジトリ（CRUD・ページング）デモ")
+	fmt.Println(strings.Repeat("=", 60))
+
+	ctx, cancel := context.WithTimeout(ctx, operationTimeout)
+	defer cancel()
+	repo := replication.NewRepository(rd.DB)
+
+	row, lsn, err := repo.Create(ctx, "Repository demo")
+	if err != nil {
+		fmt.Printf("❌ 作成エラー: %v\n", err)
+		return false
+	}
+	fmt.Printf("📝 作成: ID=%d\n", row.ID)
+
+	// 作成直後の行はスタンバイが再生するまでErrNotFoundになるため、作成時のLSNを渡す
+	if _, err := repo.Get(ctx, row.ID, replication.AfterLSN(lsn)); err != nil {
+		fmt.Printf("❌ 作成直後の取得エラー: %v\n", err)
+		return false
+	}
+
+	row, lsn, err = repo.Update(ctx, row.ID, "Repository demo (updated)")
+	if err != nil {
+		fmt.Printf("❌ 更新エラー: %v\n", err)
+		return false
+	}
+	got, err := repo.Get(ctx, row.ID, replication.AfterLSN(lsn))
+	if err != nil {
+		fmt.Printf("❌ 取得エラー: %v\n", err)
+		return false
+	}
+	fmt.Printf("📖 スタンバイから取得: ID=%d, データ='%s'\n", got.ID, got.Data)
+
+	page, err := repo.List(ctx, replication.PageRequest{Limit: 3})
+	if err != nil {
+		fmt.Printf("❌ ページ取得エラー: %v\n", err)
+		return false
+	}
+	fmt.Printf("📄 1ページ目: %d件 (次のカーソル: %q)\n", len(page.Items), page.NextCursor)
+	if page.NextCursor != "" {
+		next, err := repo.List(ctx, replication.PageRequest{Limit: 3, After: page.NextCursor})
+		if err != nil {
+			fmt.Printf("❌ ページ取得エラー: %v\n", err)
+			return false
+		}
+		fmt.Printf("📄 2ページ目: %d件\n", len(next.Items))
+	}
+
+	lsn, err = repo.Delete(ctx, row.ID)
+	if err != nil {
+		fmt.Printf("❌ 削除エラー: %v\n", err)
+		return false
+	}
+	if _, err := repo.Get(ctx, row.ID, replication.AfterLSN(lsn)); errors.Is(err, replication.ErrNotFound) {
+		fmt.Printf("🗑️  削除: ID=%d はスタンバイからも削除済み\n", row.ID)
+	} else {
+		fmt.Printf("⚠️  削除後の確認結果が不正: %v\n", err)
+		return false
+	}
+	return true
+}
+
 // average 平均値を計算
 func average(values []float64) float64 {
 	if len(values) == 0 {
@@ -390,7 +495,11 @@ func average(values []float64) float64 {
 }
 
 func main() {
-	demo, err := NewReplicationDemo()
+	// Ctrl+Cで実行中のクエリやスタンバイ待機を中断する
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	demo, err := NewReplicationDemo(ctx)
 	if err != nil {
 		fmt.Printf("❌ デモ初期化エラー: %v\n", err)
 		return
@@ -401,20 +510,28 @@ func main() {
 	fmt.Println("🔗 Docker環境でのレプリケーション動作確認")
 
 	// 基本デモ実行
-	basicSuccess := demo.RunBasicDemo()
+	basicSuccess := demo.RunBasicDemo(ctx)
 
 	if basicSuccess {
 		// パフォーマンステスト実行
-		demo.RunPerformanceTest(3)
+		demo.RunPerformanceTest(ctx, 3)
+
+		// COPYによる一括書き込み
+		demo.RunBulkInsertTest(ctx, 5000, 1000)
 
 		// データ整合性チェック
-		demo.RunDataConsistencyCheck()
+		demo.RunDataConsistencyCheck(ctx)
+
+		// リポジトリのCRUD
+		demo.RunRepositoryDemo(ctx)
 
 		fmt.Printf("\n🎉 全てのデモが完了しました！\n")
 		fmt.Println("📋 実行内容:")
 		fmt.Println("   ✅ 基本的な読み書き分離")
 		fmt.Println("   ✅ パフォーマンス測定")
+		fmt.Println("   ✅ 一括書き込み（COPY）")
 		fmt.Println("   ✅ データ整合性確認")
+		fmt.Println("   ✅ CRUDリポジトリ")
 		fmt.Println("   ✅ レプリケーション監視")
 	} else {
 		fmt.Println("\n❌ 基本デモに失敗したため、以降のテストをスキップします")
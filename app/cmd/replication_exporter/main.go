@@ -0,0 +1,97 @@
+// Prometheus exporter for PostgreSQL replication state
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"postgres-replication-demo/replication"
+)
+
+// defaultListenAddr -listen 未指定時の待ち受けアドレス
+const defaultListenAddr = ":9188"
+
+func main() {
+	listen := flag.String("listen", replication.GetEnv("REPLICATION_EXPORTER_ADDR", defaultListenAddr), "待ち受けアドレス（環境変数 REPLICATION_EXPORTER_ADDR）")
+	path := flag.String("path", "/metrics", "メトリクスのパス")
+	interval := flag.Duration("health-interval", 5*time.Second, "ヘルスチェックの間隔（pgrepl_node_healthy に反映）")
+	heartbeat := flag.Duration("heartbeat-interval", 0, "プライマリへのハートビートの書き込み間隔（0で書き込まない。pgrepl_standby_heartbeat_lag_seconds に反映）")
+	countersMaxAge := flag.Duration("routing-counters-max-age", 5*time.Minute, "読み取りのカウンタを出力するプロセスの最終書き込みからの経過時間の上限")
+	flag.Parse()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	cluster, err := replication.OpenFromEnv()
+	if err != nil {
+		fmt.Printf("❌ クラスタ接続エラー: %v\n", err)
+		os.Exit(1)
+	}
+	defer cluster.Close()
+
+	cluster.StartHealthCheck(replication.HealthCheckConfig{
+		Interval: *interval,
+		OnStateChange: func(e replication.HealthEvent) {
+			if e.Healthy {
+				fmt.Printf("💚 ノード復帰: %s (%s)\n", e.Node, e.Endpoint)
+			} else {
+				fmt.Printf("💔 ノードダウン: %s (%s): %v\n", e.Node, e.Endpoint, e.Err)
+			}
+		},
+	})
+	if *heartbeat > 0 {
+		cluster.StartHeartbeat(replication.HeartbeatConfig{
+			Interval: *heartbeat,
+			OnError: func(err error) {
+				fmt.Printf("⚠️ ハートビート書き込み失敗: %v\n", err)
+			},
+		})
+	}
+	if topology := os.Getenv(replication.TopologyFileEnv); topology != "" {
+		cluster.WatchTopology(replication.TopologyWatchConfig{
+			Path: topology,
+			OnReload: func(cfg replication.Config, err error) {
+				if err != nil {
+					fmt.Printf("⚠️ トポロジー再読み込み失敗: %v\n", err)
+					return
+				}
+				fmt.Printf("🔄 トポロジーを再読み込み: スタンバイ%d台\n", len(cfg.Standbys))
+			},
+		})
+	}
+
+	mux := http.NewServeMux()
+	// このプロセスは読み取りを処理しないため、読み取り回数などのカウンタは
+	// 各アプリケーションがStartMetricsPublisherでプライマリに書き込んだ値を出力する
+	mux.Handle(*path, cluster.MetricsHandler(replication.WithPublishedRoutingCounters(*countersMaxAge)))
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprintf(w, "PostgreSQL replication exporter\nmetrics: %s\n", *path)
+	})
+	server := &http.Server{Addr: *listen, Handler: mux, ReadHeaderTimeout: 5 * time.Second}
+
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = server.Shutdown(shutdownCtx)
+	}()
+
+	fmt.Printf("📈 メトリクスを公開中: http://%s%s\n", *listen, *path)
+	if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		fmt.Printf("❌ HTTPサーバーエラー: %v\n", err)
+		cluster.Close()
+		os.Exit(1)
+	}
+	fmt.Println("🛑 停止しました")
+}
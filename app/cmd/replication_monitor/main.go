@@ -0,0 +1,177 @@
+// Replication lag recorder and report generator
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"postgres-replication-demo/replication"
+)
+
+// defaultHistoryPath 履歴ファイルのデフォルトのパス（環境変数 REPLICATION_HISTORY_FILE で変更できる）
+const defaultHistoryPath = "lag_history.jsonl"
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+
+	var err error
+	switch os.Args[1] {
+	case "record":
+		err = record(os.Args[2:])
+	case "report":
+		err = report(os.Args[2:])
+	case "-h", "-help", "--help", "help":
+		usage()
+		return
+	default:
+		fmt.Printf("❌ 不明なサブコマンド: %s\n", os.Args[1])
+		usage()
+		os.Exit(2)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "❌ %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// usage 使い方を表示
+func usage() {
+	fmt.Println("使い方:")
+	fmt.Println("  replication_monitor record [-out FILE] [-interval 10s] [-heartbeat-interval 0]")
+	fmt.Println("  replication_monitor report [-in FILE] [-since 24h | -from TIME -to TIME] [-format markdown|html] [-o FILE]")
+}
+
+// historyPath 履歴ファイルのパス
+func historyPath() string {
+	return replication.GetEnv("REPLICATION_HISTORY_FILE", defaultHistoryPath)
+}
+
+// record 一定間隔で計測し、停止するまで履歴ファイルに追記する
+func record(args []string) error {
+	fs := flag.NewFlagSet("record", flag.ExitOnError)
+	out := fs.String("out", historyPath(), "履歴ファイル（JSONL、追記）")
+	interval := fs.Duration("interval", 10*time.Second, "計測間隔")
+	heartbeat := fs.Duration("heartbeat-interval", 0, "プライマリへのハートビートの書き込み間隔（0で書き込まない）")
+	_ = fs.Parse(args)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	cluster, err := replication.OpenFromEnv()
+	if err != nil {
+		return fmt.Errorf("クラスタ接続エラー: %w", err)
+	}
+	defer cluster.Close()
+
+	if *heartbeat > 0 {
+		cluster.StartHeartbeat(replication.HeartbeatConfig{
+			Interval: *heartbeat,
+			OnError: func(err error) {
+				fmt.Printf("⚠️ ハートビート書き込み失敗: %v\n", err)
+			},
+		})
+	}
+	_, err = cluster.StartHistoryRecorder(replication.HistoryRecorderConfig{
+		Path:     *out,
+		Interval: *interval,
+		OnSample: func(s replication.LagSample) {
+			var maxLag int64
+			for _, st := range s.Standbys {
+				maxLag = max(maxLag, st.LagBytes)
+			}
+			fmt.Printf("📝 %s primary_lsn %s スタンバイ%d台 最大遅延 %d bytes",
+				s.Time.Format(time.TimeOnly), s.PrimaryLSN, len(s.Standbys), maxLag)
+			if len(s.Errors) > 0 {
+				fmt.Printf(" ⚠️ エラー%d件: %s", len(s.Errors), s.Errors[0])
+			}
+			fmt.Println()
+		},
+		OnError: func(err error) {
+			fmt.Printf("❌ %v\n", err)
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("📈 %s ごとに %s へ記録中（Ctrl+Cで停止）\n", *interval, *out)
+	<-ctx.Done()
+	fmt.Println("🛑 記録を停止しました")
+	return nil
+}
+
+// report 履歴ファイルを集計してMarkdownまたはHTMLで出力する
+func report(args []string) error {
+	fs := flag.NewFlagSet("report", flag.ExitOnError)
+	in := fs.String("in", historyPath(), "履歴ファイル（JSONL）")
+	since := fs.Duration("since", 0, "現在からさかのぼる集計期間（例: 1h, 24h。0で全期間）")
+	fromFlag := fs.String("from", "", "集計開始時刻（RFC3339。-sinceより優先）")
+	toFlag := fs.String("to", "", "集計終了時刻（RFC3339、この時刻は含まない）")
+	format := fs.String("format", "markdown", "出力形式（markdown / html）")
+	out := fs.String("o", "", "出力先ファイル（省略時は標準出力）")
+	_ = fs.Parse(args)
+
+	var from, to time.Time
+	var err error
+	if *since > 0 {
+		to = time.Now()
+		from = to.Add(-*since)
+	}
+	if *fromFlag != "" {
+		if from, err = time.Parse(time.RFC3339, *fromFlag); err != nil {
+			return fmt.Errorf("-from の形式が不正です: %w", err)
+		}
+	}
+	if *toFlag != "" {
+		if to, err = time.Parse(time.RFC3339, *toFlag); err != nil {
+			return fmt.Errorf("-to の形式が不正です: %w", err)
+		}
+	}
+
+	var write func(replication.LagReport, io.Writer) error
+	switch *format {
+	case "markdown", "md":
+		write = replication.LagReport.WriteMarkdown
+	case "html":
+		write = replication.LagReport.WriteHTML
+	default:
+		return fmt.Errorf("不明な出力形式です: %s", *format)
+	}
+
+	f, err := os.Open(*in)
+	if err != nil {
+		return fmt.Errorf("履歴ファイルを開けません: %w", err)
+	}
+	samples, err := replication.ReadHistory(f, from, to)
+	_ = f.Close()
+	if err != nil {
+		return err
+	}
+	r := replication.BuildLagReport(samples, from, to)
+
+	if *out == "" {
+		return write(r, os.Stdout)
+	}
+	dst, err := os.Create(*out)
+	if err != nil {
+		return fmt.Errorf("出力先を作成できません: %w", err)
+	}
+	if err := write(r, dst); err != nil {
+		_ = dst.Close()
+		return err
+	}
+	if err := dst.Close(); err != nil {
+		return err
+	}
+	fmt.Printf("✅ レポートを出力しました: %s（計測%d件）\n", *out, r.Samples)
+	return nil
+}
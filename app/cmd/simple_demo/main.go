@@ -2,52 +2,27 @@
 package main
 
 import (
-	"database/sql"
 	"fmt"
-	"os"
-	"os/exec"
-	"strings"
 	"time"
 
-	_ "github.com/lib/pq"
+	"postgres-replication-demo/replication"
 )
 
-// getEnv 環境変数を取得、存在しない場合はデフォルト値を返す
-func getEnv(key, defaultValue string) string {
-	if value := os.Getenv(key); value != "" {
-		return value
-	}
-	return defaultValue
-}
-
 // simpleDemo シンプルな読み書き分離テスト
 func simpleDemo() {
 	fmt.Println("🎯 シンプル読み書き分離テスト")
 
 	// スタンバイ接続（読み取り専用）
 	fmt.Println("\n📖 スタンバイサーバーから読み取り...")
-	// 環境変数から接続情報を取得（デモ用デフォルト値付き）
-	dbUser := getEnv("POSTGRES_USER", "postgres")
-	dbPassword := getEnv("POSTGRES_PASSWORD", "password")
-	dbName := getEnv("POSTGRES_DB", "testdb")
-
-	// Docker環境では異なるホスト名とポートを使用
-	standbyHost := getEnv("POSTGRES_STANDBY_HOST", "localhost")
-	standbyPort := getEnv("POSTGRES_STANDBY_PORT", "5433")
-	
-	// IPv4を強制するためにlocalhostを2127.0.0.1に変換
-	if standbyHost == "localhost" {
-		standbyHost = "127.0.0.1"
-	}
-	
-	standbyConnStr := fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s sslmode=disable",
-		standbyHost, standbyPort, dbUser, dbPassword, dbName)
-	standbyDB, err := sql.Open("postgres", standbyConnStr)
+	cluster, err := replication.OpenFromEnv()
 	if err != nil {
 		fmt.Printf("❌ スタンバイ接続エラー: %v\n", err)
 		return
 	}
-	defer func() { _ = standbyDB.Close() }()
+	defer cluster.Close()
+	standby := cluster.PickStandby()
+	standbyDB := standby.DB
+	fmt.Printf("   接続先: %s\n", standby.Name)
 
 	// 読み取り前のデータ件数確認
 	var countBefore int
@@ -79,33 +54,21 @@ func simpleDemo() {
 		fmt.Printf("     ID:%d | %s | %s\n", id, data, createdAt.Format("2006-01-02 15:04:05"))
 	}
 
-	// プライマリ接続テスト（Dockerコンテナ経由）
+	// プライマリへの書き込み
 	fmt.Println("\n📝 プライマリサーバーに書き込み...")
-	fmt.Println("   注意: ローカルホスト接続に問題があるため、dockerコマンドを使用")
-
-	// Dockerコマンドで直接書き込み
 	testData := fmt.Sprintf("Simple test at %s", time.Now().Format("2006-01-02T15:04:05"))
-	cmd := exec.Command("docker", "exec", "postgres-primary",
-		"psql", "-U", "postgres", "-d", "testdb",
-		"-c", fmt.Sprintf("INSERT INTO test_replication (data) VALUES ('%s');", testData))
-
-	output, err := cmd.CombinedOutput()
+	written, lsn, err := cluster.WriteToPrimary(testData)
 	if err != nil {
 		fmt.Printf("   ❌ 書き込み失敗: %v\n", err)
-		fmt.Printf("   出力: %s\n", string(output))
-		return
-	}
-
-	if strings.Contains(string(output), "INSERT 0 1") {
-		fmt.Printf("   ✅ 書き込み成功: '%s'\n", testData)
-	} else {
-		fmt.Printf("   ❌ 書き込み結果が不明: %s\n", string(output))
 		return
 	}
+	fmt.Printf("   ✅ 書き込み成功: ID:%d | '%s' | LSN:%s\n", written.ID, written.Data, lsn)
 
-	// レプリケーション待機
+	// スタンバイが書き込みのLSNを再生するまで待機
 	fmt.Println("\n⏱️  レプリケーション待機中...")
-	time.Sleep(2 * time.Second)
+	if err := standby.WaitForLSN(lsn, 5*time.Second); err != nil {
+		fmt.Printf("   ⚠️  %v\n", err)
+	}
 
 	// スタンバイで再確認
 	fmt.Println("\n📖 スタンバイサーバーで同期確認...")
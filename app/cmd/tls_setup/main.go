@@ -0,0 +1,410 @@
+// TLS setup tool: generates a local CA and certificates and enables TLS in the compose stack
+package main
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"flag"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// containerCertDir コンテナ内で証明書を配置するディレクトリ（scripts/install-certs.sh がコピーする）
+const containerCertDir = "/var/lib/postgresql/certs"
+
+// 設定ファイルの中でこのツールが管理する範囲の目印
+const (
+	blockBegin = "# BEGIN tls_setup（cmd/tls_setup が生成。再実行すると置き換えられる）"
+	blockEnd   = "# END tls_setup"
+)
+
+// allowedNetworks pg_hba.conf でTLS接続を許可するネットワーク（既存の host ルールと同じ範囲）
+var allowedNetworks = []string{"127.0.0.1/32", "::1/128", "172.16.0.0/12", "192.168.0.0/16", "10.0.0.0/8"}
+
+// options コマンドライン引数
+type options struct {
+	root            string
+	days            int
+	force           bool
+	clientUser      string
+	replicationUser string
+	extraHosts      []string
+}
+
+// authority 証明書に署名するCA
+type authority struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pem  []byte
+}
+
+func main() {
+	var opts options
+	var hosts string
+	flag.StringVar(&opts.root, "root", "..", "リポジトリのルートディレクトリ")
+	flag.IntVar(&opts.days, "days", 825, "サーバー・クライアント証明書の有効日数")
+	flag.BoolVar(&opts.force, "force", false, "既存のCAを使わずに作り直す")
+	flag.StringVar(&opts.clientUser, "client-user", "postgres", "アプリケーションが接続するユーザー名（クライアント証明書のCN）")
+	flag.StringVar(&opts.replicationUser, "replication-user", "replicator", "レプリケーションユーザー名（クライアント証明書のCN）")
+	flag.StringVar(&hosts, "hosts", "", "サーバー証明書に追加するホスト名・IPアドレス（カンマ区切り）")
+	flag.Parse()
+	for _, h := range strings.Split(hosts, ",") {
+		if h = strings.TrimSpace(h); h != "" {
+			opts.extraHosts = append(opts.extraHosts, h)
+		}
+	}
+
+	fmt.Println("🔐 TLS証明書の生成と設定")
+	fmt.Println(strings.Repeat("=", 50))
+	if err := run(opts); err != nil {
+		fmt.Printf("❌ %v\n", err)
+		os.Exit(1)
+	}
+
+	certDir := filepath.Join(opts.root, "certs")
+	fmt.Println("\n🎉 TLSの設定が完了しました")
+	fmt.Println("   docker compose down -v && docker compose up -d で作り直してください（スタンバイは再初期化が必要です）")
+	fmt.Println("   Goクライアントは次の環境変数で接続します:")
+	fmt.Printf("   POSTGRES_SSLMODE=verify-full\n")
+	fmt.Printf("   POSTGRES_SSLROOTCERT=%s\n", filepath.Join(certDir, "client", "root.crt"))
+	fmt.Printf("   POSTGRES_SSLCERT=%s\n", filepath.Join(certDir, "client", opts.clientUser+".crt"))
+	fmt.Printf("   POSTGRES_SSLKEY=%s\n", filepath.Join(certDir, "client", opts.clientUser+".key"))
+}
+
+// run 証明書を生成して設定ファイルに反映する
+func run(opts options) error {
+	certDir := filepath.Join(opts.root, "certs")
+	ca, err := loadOrCreateCA(certDir, opts.force)
+	if err != nil {
+		return err
+	}
+
+	validFor := time.Duration(opts.days) * 24 * time.Hour
+	localHosts := append([]string{"localhost", "127.0.0.1", "::1"}, opts.extraHosts...)
+	bundles := []struct {
+		dir     string
+		cn      string
+		server  bool
+		hosts   []string
+		name    string
+		comment string
+	}{
+		{"primary", "postgres-primary", true, append([]string{"postgres-primary"}, localHosts...), "server", "プライマリのサーバー証明書"},
+		{"standby", "postgres-standby", true, append([]string{"postgres-standby"}, localHosts...), "server", "スタンバイのサーバー証明書"},
+		{"standby", opts.replicationUser, false, nil, opts.replicationUser, "レプリケーション接続のクライアント証明書"},
+		{"client", opts.clientUser, false, nil, opts.clientUser, "Goクライアントのクライアント証明書"},
+	}
+	for _, b := range bundles {
+		dir := filepath.Join(certDir, b.dir)
+		certPEM, keyPEM, err := ca.issue(b.cn, b.hosts, b.server, validFor)
+		if err != nil {
+			return fmt.Errorf("%s の発行エラー: %w", b.comment, err)
+		}
+		if err := writeFile(filepath.Join(dir, "root.crt"), ca.pem, 0o644); err != nil {
+			return err
+		}
+		if err := writeFile(filepath.Join(dir, b.name+".crt"), certPEM, 0o644); err != nil {
+			return err
+		}
+		if err := writeFile(filepath.Join(dir, b.name+".key"), keyPEM, 0o600); err != nil {
+			return err
+		}
+		fmt.Printf("✅ %s: %s\n", b.comment, filepath.Join(dir, b.name+".crt"))
+	}
+
+	if err := configurePrimary(opts); err != nil {
+		return err
+	}
+	return configureStandby(opts)
+}
+
+// loadOrCreateCA 既存のCAを読み込む。ない場合（またはforceの場合）は作成する
+func loadOrCreateCA(dir string, force bool) (*authority, error) {
+	certPath, keyPath := filepath.Join(dir, "ca.crt"), filepath.Join(dir, "ca.key")
+	if !force {
+		ca, err := loadCA(certPath, keyPath)
+		if err == nil {
+			fmt.Printf("♻️  既存のCAを使用: %s\n", certPath)
+			return ca, nil
+		}
+		if !errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("CA読み込みエラー（-force で作り直せます）: %w", err)
+		}
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          serialNumber(),
+		Subject:               pkix.Name{CommonName: "postgresql-replication local CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().AddDate(10, 0, 0),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		MaxPathLenZero:        true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		return nil, err
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	ca := &authority{cert: cert, key: key, pem: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})}
+	if err := writeFile(certPath, ca.pem, 0o644); err != nil {
+		return nil, err
+	}
+	if err := writeFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+		return nil, err
+	}
+	fmt.Printf("✅ CAを作成: %s\n", certPath)
+	return ca, nil
+}
+
+// loadCA PEM形式のCA証明書と秘密鍵を読み込む
+func loadCA(certPath, keyPath string) (*authority, error) {
+	certPEM, err := os.ReadFile(certPath) // #nosec G304 -- 生成先のディレクトリは利用者が指定する
+	if err != nil {
+		return nil, err
+	}
+	keyPEM, err := os.ReadFile(keyPath) // #nosec G304 -- 生成先のディレクトリは利用者が指定する
+	if err != nil {
+		return nil, err
+	}
+	certBlock, _ := pem.Decode(certPEM)
+	keyBlock, _ := pem.Decode(keyPEM)
+	if certBlock == nil || keyBlock == nil {
+		return nil, errors.New("PEM形式ではありません")
+	}
+	cert, err := x509.ParseCertificate(certBlock.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	key, err := x509.ParseECPrivateKey(keyBlock.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	return &authority{cert: cert, key: key, pem: certPEM}, nil
+}
+
+// issue CAで署名した証明書と秘密鍵をPEM形式で返す。serverの場合はhostsをSANに入れる
+func (ca *authority) issue(cn string, hosts []string, server bool, validFor time.Duration) (certPEM, keyPEM []byte, err error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: serialNumber(),
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(validFor),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	if server {
+		tmpl.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}
+		for _, h := range hosts {
+			if ip := net.ParseIP(h); ip != nil {
+				tmpl.IPAddresses = append(tmpl.IPAddresses, ip)
+			} else {
+				tmpl.DNSNames = append(tmpl.DNSNames, h)
+			}
+		}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		return nil, nil, err
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), nil
+}
+
+// serialNumber 128ビットのランダムなシリアル番号
+func serialNumber() *big.Int {
+	n, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		panic(err)
+	}
+	return n
+}
+
+// writeFile ディレクトリを作成してファイルを書き込む（既存ファイルのパーミッションも変更する）
+func writeFile(path string, data []byte, perm os.FileMode) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	if err := os.WriteFile(path, data, perm); err != nil {
+		return err
+	}
+	return os.Chmod(path, perm)
+}
+
+// serverSSLSettings postgresql.conf に追加するサーバー側のTLS設定
+func serverSSLSettings() []string {
+	return []string{
+		"ssl = on",
+		fmt.Sprintf("ssl_cert_file = '%s/server.crt'", containerCertDir),
+		fmt.Sprintf("ssl_key_file = '%s/server.key'", containerCertDir),
+		fmt.Sprintf("ssl_ca_file = '%s/root.crt'              # クライアント証明書の検証に使う", containerCertDir),
+		"ssl_min_protocol_version = 'TLSv1.2'",
+	}
+}
+
+// hbaRules TCP接続にTLSとクライアント証明書（CNがユーザー名と一致すること）を要求するルール。
+// 既存の host ルールより前に置き、TLSを使わないTCP接続は拒否する
+func hbaRules(opts options) []string {
+	var rules []string
+	for _, network := range allowedNetworks {
+		rules = append(rules, fmt.Sprintf("hostssl   all             %-15s %-23s md5 clientcert=verify-full", opts.clientUser, network))
+	}
+	for _, network := range allowedNetworks {
+		rules = append(rules, fmt.Sprintf("hostssl   replication     %-15s %-23s md5 clientcert=verify-full", opts.replicationUser, network))
+	}
+	for _, db := range []string{"all", "replication"} {
+		for _, network := range []string{"0.0.0.0/0", "::/0"} {
+			rules = append(rules, fmt.Sprintf("hostnossl %-15s all             %-23s reject", db, network))
+		}
+	}
+	return rules
+}
+
+// configurePrimary プライマリの postgresql.conf と pg_hba.conf にTLS設定を反映する
+func configurePrimary(opts options) error {
+	if err := updateConf(filepath.Join(opts.root, "primary", "postgresql.conf"), serverSSLSettings()); err != nil {
+		return err
+	}
+	return updateHBA(filepath.Join(opts.root, "primary", "pg_hba.conf"), hbaRules(opts))
+}
+
+// configureStandby スタンバイの postgresql.conf（サーバー側のTLSと、プライマリへの接続のverify-full）と pg_hba.conf に反映する
+func configureStandby(opts options) error {
+	path := filepath.Join(opts.root, "standby", "postgresql.conf")
+	data, err := os.ReadFile(path) // #nosec G304 -- リポジトリ内の設定ファイル
+	if err != nil {
+		return err
+	}
+	conninfo, ok := primaryConninfo(removeBlock(string(data)))
+	if !ok {
+		return fmt.Errorf("%s に primary_conninfo がありません", path)
+	}
+	conninfo += fmt.Sprintf(" sslmode=verify-full sslrootcert=%[1]s/root.crt sslcert=%[1]s/%[2]s.crt sslkey=%[1]s/%[2]s.key",
+		containerCertDir, opts.replicationUser)
+	settings := append(serverSSLSettings(),
+		"# プライマリへの接続をTLSで行い、サーバー証明書とホスト名を検証する（上の primary_conninfo より優先される）",
+		fmt.Sprintf("primary_conninfo = '%s'", conninfo))
+	if err := updateConf(path, settings); err != nil {
+		return err
+	}
+	return updateHBA(filepath.Join(opts.root, "standby", "pg_hba.conf"), hbaRules(opts))
+}
+
+// primaryConninfoPattern postgresql.conf の primary_conninfo 行
+var primaryConninfoPattern = regexp.MustCompile(`(?m)^\s*primary_conninfo\s*=\s*'([^']*)'`)
+
+// primaryConninfo 設定ファイルの primary_conninfo の値
+func primaryConninfo(conf string) (string, bool) {
+	m := primaryConninfoPattern.FindStringSubmatch(conf)
+	if m == nil {
+		return "", false
+	}
+	return m[1], true
+}
+
+// updateConf 管理範囲を置き換えて、ファイルの末尾（後の設定が優先される）に書き込む
+func updateConf(path string, lines []string) error {
+	data, err := os.ReadFile(path) // #nosec G304 -- リポジトリ内の設定ファイル
+	if err != nil {
+		return err
+	}
+	conf := strings.TrimRight(removeBlock(string(data)), "\n")
+	conf += "\n\n" + block(lines) + "\n"
+	return writeConf(path, conf)
+}
+
+// updateHBA 管理範囲を置き換えて、最初のルールの前（先頭のコメントの後）に書き込む
+func updateHBA(path string, rules []string) error {
+	data, err := os.ReadFile(path) // #nosec G304 -- リポジトリ内の設定ファイル
+	if err != nil {
+		return err
+	}
+	lines := strings.Split(removeBlock(string(data)), "\n")
+	at := len(lines)
+	for i, line := range lines {
+		if trimmed := strings.TrimSpace(line); trimmed != "" && !strings.HasPrefix(trimmed, "#") {
+			at = i
+			break
+		}
+	}
+	// 最初のルールに付いている説明コメントの前に入れる
+	for at > 0 && strings.HasPrefix(strings.TrimSpace(lines[at-1]), "#") {
+		at--
+	}
+	inserted := append([]string{}, lines[:at]...)
+	inserted = append(inserted, strings.Split(block(rules), "\n")...)
+	inserted = append(inserted, "")
+	inserted = append(inserted, lines[at:]...)
+	return writeConf(path, strings.Join(inserted, "\n"))
+}
+
+// block 管理範囲の目印で囲んだ内容
+func block(lines []string) string {
+	return strings.Join(append(append([]string{blockBegin}, lines...), blockEnd), "\n")
+}
+
+// removeBlock 以前に書き込んだ管理範囲（と直後の空行）を取り除く
+func removeBlock(conf string) string {
+	begin := strings.Index(conf, blockBegin)
+	if begin < 0 {
+		return conf
+	}
+	end := strings.Index(conf[begin:], blockEnd)
+	if end < 0 {
+		return conf
+	}
+	end += begin + len(blockEnd)
+	for end < len(conf) && conf[end] == '\n' {
+		end++
+	}
+	return conf[:begin] + conf[end:]
+}
+
+// writeConf 内容が変わった場合のみ書き込む
+func writeConf(path, conf string) error {
+	old, err := os.ReadFile(path) // #nosec G304 -- リポジトリ内の設定ファイル
+	if err == nil && bytes.Equal(old, []byte(conf)) {
+		return nil
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(path, []byte(conf), info.Mode().Perm()); err != nil {
+		return err
+	}
+	fmt.Printf("📝 設定を更新: %s\n", path)
+	return nil
+}
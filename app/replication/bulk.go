@@ -0,0 +1,165 @@
+package replication
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// defaultBulkBatchSize バッチサイズを指定しなかった場合の1バッチの行数
+const defaultBulkBatchSize = 1000
+
+// BatchResult COPYによる一括書き込み1バッチ分の結果
+type BatchResult struct {
+	// Rows 書き込んだ行数
+	Rows int
+	// Duration COPYの開始からコミット完了までの時間（コミット後のWAL位置の取得は含まない）
+	Duration time.Duration
+	// LSN コミット後のWAL位置。AfterLSNに渡すとこのバッチまでの行を読み取れる。
+	// コミット後の取得に失敗した場合は0
+	LSN LSN
+}
+
+// RowsPerSecond 1秒あたりの書き込み行数
+func (b BatchResult) RowsPerSecond() float64 {
+	if b.Duration <= 0 {
+		return 0
+	}
+	return float64(b.Rows) / b.Duration.Seconds()
+}
+
+// BulkInsert COPYでプライマリにデータを一括書き込みする
+func (c *Cluster) BulkInsert(rows []ReplicationData, batchSize int) ([]BatchResult, error) {
+	return c.BulkInsertContext(context.Background(), rows, batchSize)
+}
+
+// BulkInsertContext COPYでプライマリにデータをbatchSize行ずつ（0以下の場合は1000行）一括書き込みする。
+// バッチごとにトランザクションをコミットし、所要時間とコミット後のLSNを返す。
+// 途中で失敗した場合は、それまでにコミットしたバッチの結果とエラーを返す。
+// コミット後にWAL位置の取得だけが失敗したバッチも、コミット済みとして結果に含める。
+// CreatedAtはバッチ内に指定された行がある場合のみ書き込む。created_atはタイムゾーンなしの列のため、
+// 指定された時刻はサーバーのTimeZoneの時刻に変換し、ゼロ値の行はサーバーの現在時刻（列のデフォルトと同じ）とする
+func (c *Cluster) BulkInsertContext(ctx context.Context, rows []ReplicationData, batchSize int) ([]BatchResult, error) {
+	if batchSize <= 0 {
+		batchSize = defaultBulkBatchSize
+	}
+
+	var results []BatchResult
+	for start := 0; start < len(rows); start += batchSize {
+		batch := rows[start:min(start+batchSize, len(rows))]
+		result, err := c.copyBatch(ctx, batch)
+		if result.Rows > 0 {
+			results = append(results, result)
+		}
+		if err != nil {
+			return results, err
+		}
+	}
+	return results, nil
+}
+
+// serverClockQuery サーバーのTimeZone設定、UTCからのオフセット（秒）、トランザクション開始時のローカル時刻
+const serverClockQuery = `SELECT current_setting('TimeZone'), EXTRACT(TIMEZONE FROM CURRENT_TIMESTAMP)::int, LOCALTIMESTAMP`
+
+// serverClock タイムゾーンなしの列に書き込む時刻を、サーバーの時刻に揃えるための情報
+type serverClock struct {
+	// loc サーバーのTimeZone
+	loc *time.Location
+	// now サーバーのトランザクション開始時のローカル時刻（CURRENT_TIMESTAMPのデフォルト値と同じ）
+	now time.Time
+}
+
+// loadServerClock トランザクション内でサーバーのタイムゾーンと現在時刻を取得する。
+// TimeZoneの名前をGoで読み込めない場合は現在のオフセットの固定タイムゾーンを使う
+func loadServerClock(ctx context.Context, tx interface {
+	QueryRowContext(context.Context, string, ...any) *sql.Row
+}) (serverClock, error) {
+	var name string
+	var offset int
+	var now time.Time
+	if err := tx.QueryRowContext(ctx, serverClockQuery).Scan(&name, &offset, &now); err != nil {
+		return serverClock{}, err
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		loc = time.FixedZone(name, offset)
+	}
+	return serverClock{loc: loc, now: now}, nil
+}
+
+// timestamp created_atに書き込む値。tをサーバーのタイムゾーンの時刻に変換し、ゼロ値はサーバーの現在時刻とする。
+// タイムゾーンなしの列ではオフセットが無視されるため、壁時計の時刻をサーバーに合わせる
+func (s serverClock) timestamp(t time.Time) time.Time {
+	if t.IsZero() {
+		return s.now
+	}
+	return t.In(s.loc)
+}
+
+// copyBatch 1バッチ分をCOPYで書き込んでコミットする。
+// コミット後の処理だけが失敗した場合は、コミット済みの結果とエラーの両方を返す
+func (c *Cluster) copyBatch(ctx context.Context, batch []ReplicationData) (BatchResult, error) {
+	start := time.Now()
+	withCreatedAt := false
+	for _, row := range batch {
+		if !row.CreatedAt.IsZero() {
+			withCreatedAt = true
+			break
+		}
+	}
+
+	tx, err := c.Primary.BeginTx(ctx, nil)
+	if err != nil {
+		return BatchResult{}, c.primaryError("一括書き込み", err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	columns := []string{"data"}
+	var clock serverClock
+	if withCreatedAt {
+		columns = append(columns, "created_at")
+		// COPY中は他のクエリを実行できないため、先にサーバーのタイムゾーンと現在時刻を取得する
+		if clock, err = loadServerClock(ctx, tx); err != nil {
+			return BatchResult{}, c.primaryError("一括書き込み", err)
+		}
+	}
+	stmt, err := tx.PrepareContext(ctx, pq.CopyIn("test_replication", columns...))
+	if err != nil {
+		return BatchResult{}, c.primaryError("一括書き込み", err)
+	}
+
+	for _, row := range batch {
+		if withCreatedAt {
+			_, err = stmt.ExecContext(ctx, row.Data, clock.timestamp(row.CreatedAt))
+		} else {
+			_, err = stmt.ExecContext(ctx, row.Data)
+		}
+		if err != nil {
+			_ = stmt.Close()
+			return BatchResult{}, c.primaryError("一括書き込み", err)
+		}
+	}
+	// 引数なしのExecでバッファに残ったデータを送信してCOPYを完了する
+	if _, err := stmt.ExecContext(ctx); err != nil {
+		_ = stmt.Close()
+		return BatchResult{}, c.primaryError("一括書き込み", err)
+	}
+	if err := stmt.Close(); err != nil {
+		return BatchResult{}, c.primaryError("一括書き込み", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return BatchResult{}, c.primaryError("一括書き込みコミット", err)
+	}
+
+	result := BatchResult{Rows: len(batch), Duration: time.Since(start)}
+
+	lsn, err := c.committed(ctx)
+	if err != nil {
+		return result, fmt.Errorf("一括書き込みはコミット済みですが、コミット後のWAL位置を取得できません: %w", err)
+	}
+	result.LSN = lsn
+	return result, nil
+}
@@ -0,0 +1,56 @@
+package replication
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"testing"
+	"time"
+)
+
+// TestBatchResultRowsPerSecond バッチの書き込み速度計算のテスト
+func TestBatchResultRowsPerSecond(t *testing.T) {
+	r := BatchResult{Rows: 500, Duration: 250 * time.Millisecond}
+	if got := r.RowsPerSecond(); got != 2000 {
+		t.Fatalf("RowsPerSecond = %v, want 2000", got)
+	}
+	if got := (BatchResult{Rows: 1}).RowsPerSecond(); got != 0 {
+		t.Fatalf("所要時間が0の場合は0を返すはず: %v", got)
+	}
+}
+
+// TestServerClock created_atに書き込む時刻がサーバーのタイムゾーンに揃えられるテスト
+func TestServerClock(t *testing.T) {
+	serverNow := time.Date(2024, 5, 1, 9, 0, 0, 0, time.UTC)
+	db := sql.OpenDB(&tableConnector{rows: [][]driver.Value{{"Etc/UTC", int64(0), serverNow}}})
+	defer func() { _ = db.Close() }()
+
+	clock, err := loadServerClock(context.Background(), db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// +09:00の時刻はサーバー（UTC）の壁時計の時刻で書き込む
+	jst := time.FixedZone("JST", 9*60*60)
+	got := clock.timestamp(time.Date(2024, 5, 1, 18, 30, 0, 0, jst))
+	if want := "2024-05-01 09:30:00"; got.Format(time.DateTime) != want {
+		t.Errorf("変換後の時刻 = %s, want %s", got.Format(time.DateTime), want)
+	}
+
+	// ゼロ値はクライアントではなくサーバーの現在時刻
+	if got := clock.timestamp(time.Time{}); !got.Equal(serverNow) {
+		t.Errorf("ゼロ値の時刻 = %s, want %s", got, serverNow)
+	}
+
+	// Goで読み込めないTimeZoneはサーバーが返したオフセットで変換する
+	db2 := sql.OpenDB(&tableConnector{rows: [][]driver.Value{{"<+0530>-05:30", int64(5*60*60 + 30*60), serverNow}}})
+	defer func() { _ = db2.Close() }()
+	clock, err = loadServerClock(context.Background(), db2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got = clock.timestamp(time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC))
+	if want := "2024-05-01 05:30:00"; got.Format(time.DateTime) != want {
+		t.Errorf("固定オフセットでの変換後の時刻 = %s, want %s", got.Format(time.DateTime), want)
+	}
+}
@@ -0,0 +1,407 @@
+package replication
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	// PostgreSQLドライバー
+	_ "github.com/lib/pq"
+)
+
+// ReplicationData レプリケーションデータ構造体
+type ReplicationData struct {
+	ID        int
+	Data      string
+	CreatedAt time.Time
+}
+
+// Cluster プライマリとスタンバイの接続をまとめて管理する
+type Cluster struct {
+	// Config Open時の設定。Reload後の設定はCurrentConfigで取得する
+	Config  Config
+	Primary *sql.DB
+	// Standbys スタンバイ一覧。Reloadで置き換えられるため、並行して参照する場合はStandbyNodesを使う
+	Standbys []*Node
+
+	policy atomic.Pointer[policyHolder]
+	// live Reloadで反映した設定（未反映の場合はnil）
+	live atomic.Pointer[Config]
+
+	metrics clusterMetrics
+	sticky  stickySessions
+
+	// topoMu Standbysの置き換えを保護する
+	topoMu sync.RWMutex
+	// reloadMu Reloadを直列化する
+	reloadMu sync.Mutex
+
+	mu           sync.Mutex
+	checkers     []*HealthChecker
+	watchers     []*TopologyWatcher
+	slotMonitors []*SlotMonitor
+	heartbeats   []*HeartbeatWriter
+	recorders    []*HistoryRecorder
+	publishers   []*MetricsPublisher
+	retired      []*Node
+}
+
+// policyHolder atomic.Pointerでインターフェースを保持するための入れ物
+type policyHolder struct {
+	RoutingPolicy
+}
+
+// OpenFromEnv 環境変数（POSTGRES_TOPOLOGY_FILEが設定されていればトポロジーファイル）の設定でクラスタに接続
+func OpenFromEnv() (*Cluster, error) {
+	cfg, err := Load()
+	if err != nil {
+		return nil, err
+	}
+	return Open(cfg)
+}
+
+// Open 指定された設定でクラスタに接続
+func Open(cfg Config) (*Cluster, error) {
+	return OpenContext(context.Background(), cfg)
+}
+
+// OpenContext 指定された設定でクラスタに接続（接続確認はctxに従って中断される）
+func OpenContext(ctx context.Context, cfg Config) (*Cluster, error) {
+	if len(cfg.Standbys) == 0 {
+		return nil, errors.New("スタンバイが設定されていません")
+	}
+	policy, err := NewRoutingPolicy(cfg.RoutingPolicy)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &Cluster{Config: cfg}
+	c.SetRoutingPolicy(policy)
+
+	for _, sc := range cfg.Standbys {
+		node := newNode(cfg, sc)
+		c.Standbys = append(c.Standbys, node)
+
+		err = node.DB.PingContext(ctx)
+		if err != nil {
+			c.Close()
+			return nil, node.nodeError("スタンバイDB ping", err)
+		}
+	}
+
+	primaryDB := openPool(cfg, cfg.Primary)
+	c.Primary = primaryDB
+
+	err = primaryDB.PingContext(ctx)
+	if err != nil {
+		c.Close()
+		return nil, c.primaryError("プライマリDB ping", err)
+	}
+
+	return c, nil
+}
+
+// SetRoutingPolicy スタンバイの選択方式を差し替える
+func (c *Cluster) SetRoutingPolicy(p RoutingPolicy) {
+	c.policy.Store(&policyHolder{p})
+}
+
+// RoutingPolicy 現在のスタンバイ選択方式を返す
+func (c *Cluster) RoutingPolicy() RoutingPolicy {
+	return c.policy.Load().RoutingPolicy
+}
+
+// CurrentConfig 現在の設定（Reload後は反映した設定）
+func (c *Cluster) CurrentConfig() Config {
+	return c.config()
+}
+
+// config 現在の設定
+func (c *Cluster) config() Config {
+	if cfg := c.live.Load(); cfg != nil {
+		return *cfg
+	}
+	return c.Config
+}
+
+// StandbyNodes 現在のスタンバイ一覧
+func (c *Cluster) StandbyNodes() []*Node {
+	c.topoMu.RLock()
+	defer c.topoMu.RUnlock()
+	return c.Standbys
+}
+
+// PickStandby ルーティングポリシーに従って正常なスタンバイを1台選ぶ（正常なスタンバイがない場合はnil）
+func (c *Cluster) PickStandby() *Node {
+	return c.PickStandbyContext(context.Background())
+}
+
+// PickStandbyContext ctxに従って中断できるPickStandby
+func (c *Cluster) PickStandbyContext(ctx context.Context) *Node {
+	return c.pickStandby(ctx, c.HealthyStandbys())
+}
+
+// HealthyStandbys ヘルスチェックでダウンと判定されていないスタンバイ
+func (c *Cluster) HealthyStandbys() []*Node {
+	standbys := c.StandbyNodes()
+	healthy := make([]*Node, 0, len(standbys))
+	for _, n := range standbys {
+		if n.Healthy() {
+			healthy = append(healthy, n)
+		}
+	}
+	return healthy
+}
+
+// pickStandby 候補からルーティングポリシーに従って1台選ぶ。ポリシーが候補外を返した場合は先頭を使う
+func (c *Cluster) pickStandby(ctx context.Context, candidates []*Node) *Node {
+	if len(candidates) == 0 {
+		return nil
+	}
+	picked := c.RoutingPolicy().Pick(ctx, candidates)
+	for _, n := range candidates {
+		if n == picked {
+			return n
+		}
+	}
+	return candidates[0]
+}
+
+// openPool 指定ノードへのコネクションプールを作成。接続のたびにConfig.Credentialsから認証情報を取得する
+func openPool(cfg Config, e Endpoint) *sql.DB {
+	db := sql.OpenDB(NewCredentialConnector(cfg, e))
+	configurePool(db, cfg.pool())
+	return db
+}
+
+// poolSettings コネクションプールの設定（0の項目は変更しない）
+type poolSettings struct {
+	maxOpenConns    int
+	maxIdleConns    int
+	connMaxLifetime time.Duration
+}
+
+// pool プライマリ（およびスタンバイのデフォルト）のプール設定
+func (c Config) pool() poolSettings {
+	return poolSettings{maxOpenConns: c.MaxOpenConns, maxIdleConns: c.MaxIdleConns, connMaxLifetime: c.ConnMaxLifetime}
+}
+
+// standbyPool ノードごとの指定を反映したスタンバイのプール設定
+func (c Config) standbyPool(sc StandbyConfig) poolSettings {
+	p := c.pool()
+	if sc.MaxOpenConns > 0 {
+		p.maxOpenConns = sc.MaxOpenConns
+	}
+	if sc.MaxIdleConns > 0 {
+		p.maxIdleConns = sc.MaxIdleConns
+	}
+	return p
+}
+
+// configurePool プール設定を適用する。使用中の*sql.DBにも適用できる
+func configurePool(db *sql.DB, p poolSettings) {
+	if p.maxOpenConns > 0 {
+		db.SetMaxOpenConns(p.maxOpenConns)
+	}
+	if p.maxIdleConns > 0 {
+		db.SetMaxIdleConns(p.maxIdleConns)
+	}
+	if p.connMaxLifetime > 0 {
+		db.SetConnMaxLifetime(p.connMaxLifetime)
+	}
+}
+
+// Close ヘルスチェック、スロットの監視、ハートビート、履歴の記録、カウンタの書き込みとトポロジーファイルの監視を停止し、データベース接続を閉じる
+func (c *Cluster) Close() {
+	c.mu.Lock()
+	checkers, watchers, slotMonitors, heartbeats, recorders, publishers, retired := c.checkers, c.watchers, c.slotMonitors, c.heartbeats, c.recorders, c.publishers, c.retired
+	c.checkers, c.watchers, c.slotMonitors, c.heartbeats, c.recorders, c.publishers, c.retired = nil, nil, nil, nil, nil, nil, nil
+	c.mu.Unlock()
+	for _, w := range watchers {
+		w.Stop()
+	}
+	for _, r := range recorders {
+		r.Stop()
+	}
+	for _, m := range slotMonitors {
+		m.Stop()
+	}
+	for _, h := range heartbeats {
+		h.Stop()
+	}
+	// 停止時に最後のカウンタを書き込むため、データベース接続を閉じる前に止める
+	for _, p := range publishers {
+		p.Stop()
+	}
+	for _, h := range checkers {
+		h.Stop()
+	}
+
+	if c.Primary != nil {
+		_ = c.Primary.Close()
+	}
+	for _, n := range append(c.StandbyNodes(), retired...) {
+		_ = n.DB.Close()
+	}
+}
+
+// WriteToPrimary プライマリサーバーにデータを書き込み、採番されたIDと作成日時、
+// およびコミット後のWAL位置を返す。返されたLSNをAfterLSNに渡すと書き込みを読み取れることが保証される
+func (c *Cluster) WriteToPrimary(dataText string) (ReplicationData, LSN, error) {
+	return c.WriteToPrimaryContext(context.Background(), dataText)
+}
+
+// WriteToPrimaryContext ctxに従って中断できるWriteToPrimary。
+// ctxにWithSessionでセッションキーが設定されている場合、そのセッションの読み取りを一定期間プライマリへ固定する
+func (c *Cluster) WriteToPrimaryContext(ctx context.Context, dataText string) (ReplicationData, LSN, error) {
+	row := ReplicationData{Data: dataText}
+	err := c.Primary.QueryRowContext(ctx,
+		"INSERT INTO test_replication (data) VALUES ($1) RETURNING id, created_at", dataText).Scan(
+		&row.ID, &row.CreatedAt)
+	if err != nil {
+		return ReplicationData{}, 0, c.primaryError("書き込み", err)
+	}
+
+	lsn, err := c.committed(ctx)
+	if err != nil {
+		return row, 0, err
+	}
+	return row, lsn, nil
+}
+
+// committed 自動コミットの書き込みが完了した後に呼び、セッションをプライマリへ固定してコミット後のWAL位置を返す。
+// ctxにWithLSN（Middleware）のトークンがあれば、そのLSNも記録する
+func (c *Cluster) committed(ctx context.Context) (LSN, error) {
+	c.sticky.mark(ctx, c.config().StickyPrimaryWindow)
+
+	// コミット完了後に取得するため、このLSNはコミットレコード以降を指す
+	lsn, err := c.CurrentLSNContext(ctx)
+	if err != nil {
+		return 0, err
+	}
+	recordWrite(ctx, lsn)
+	return lsn, nil
+}
+
+// WaitForLSN 正常な全てのスタンバイが指定LSNまでWALを再生するまで待機
+func (c *Cluster) WaitForLSN(lsn LSN, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	return c.WaitForLSNContext(ctx, lsn)
+}
+
+// WaitForLSNContext 正常な全てのスタンバイが指定LSNまでWALを再生するまで、ctxが終了するまで待機
+func (c *Cluster) WaitForLSNContext(ctx context.Context, lsn LSN) error {
+	for _, n := range c.HealthyStandbys() {
+		if err := n.WaitForLSNContext(ctx, lsn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ReadFromStandby スタンバイサーバーからデータを読み取り
+func (c *Cluster) ReadFromStandby(limit int, opts ...ReadOption) ([]ReplicationData, error) {
+	return c.ReadFromStandbyContext(context.Background(), limit, opts...)
+}
+
+// ReadFromStandbyContext ctxに従って中断できるReadFromStandby。LSN待機もctxで打ち切られる
+func (c *Cluster) ReadFromStandbyContext(ctx context.Context, limit int, opts ...ReadOption) ([]ReplicationData, error) {
+	var results []ReplicationData
+	err := c.readWithRetry(ctx, opts, func(db *sql.DB, info ReadInfo) error {
+		query := "SELECT id, data, created_at FROM test_replication ORDER BY created_at DESC LIMIT $1"
+		var err error
+		results, err = queryRows(ctx, db, info, query, limit)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return results, nil
+}
+
+// GetDataCount 現在のデータ件数を取得
+func (c *Cluster) GetDataCount(opts ...ReadOption) (int, error) {
+	return c.GetDataCountContext(context.Background(), opts...)
+}
+
+// GetDataCountContext ctxに従って中断できるGetDataCount
+func (c *Cluster) GetDataCountContext(ctx context.Context, opts ...ReadOption) (int, error) {
+	var count int
+	err := c.readWithRetry(ctx, opts, func(db *sql.DB, info ReadInfo) error {
+		err := db.QueryRowContext(ctx, "SELECT count(*) FROM test_replication").Scan(&count)
+		return readError("件数取得", info, err)
+	})
+	return count, err
+}
+
+// GetReplicationStatus レプリケーション遅延（秒）を取得。
+// StartHeartbeatで書き込み中は、正常なスタンバイのハートビートの遅延の最大値を返す
+// （読めないスタンバイは除き、1台も読めなければエラー）。
+// それ以外はプライマリのpg_stat_replicationから、全スタンバイのreplay_lagの最大値を返す（スタンバイがない場合は0）
+func (c *Cluster) GetReplicationStatus() (float64, error) {
+	return c.GetReplicationStatusContext(context.Background())
+}
+
+// GetReplicationStatusContext ctxに従って中断できるGetReplicationStatus
+func (c *Cluster) GetReplicationStatusContext(ctx context.Context) (float64, error) {
+	if c.heartbeatRunning() {
+		lag, ok, err := c.maxHeartbeatLag(ctx)
+		if err != nil {
+			return -1, err
+		}
+		if ok {
+			return lag.Seconds(), nil
+		}
+		// 正常なスタンバイがなければpg_stat_replicationの値を使う
+	}
+
+	statuses, err := c.StandbyStatusesContext(ctx)
+	if err != nil {
+		return -1, err
+	}
+	var lag time.Duration
+	for _, s := range statuses {
+		lag = max(lag, s.ReplayLag)
+	}
+	return lag.Seconds(), nil
+}
+
+// TestConnection プライマリと全てのスタンバイへの接続をテスト
+func (c *Cluster) TestConnection() bool {
+	return c.TestConnectionContext(context.Background())
+}
+
+// TestConnectionContext ctxに従って中断できるTestConnection
+func (c *Cluster) TestConnectionContext(ctx context.Context) bool {
+	return c.CheckConnectionContext(ctx) == nil
+}
+
+// CheckConnection プライマリと全てのスタンバイへの接続を確認し、失敗したノードのNodeErrorを返す
+func (c *Cluster) CheckConnection() error {
+	return c.CheckConnectionContext(context.Background())
+}
+
+// CheckConnectionContext ctxに従って中断できるCheckConnection
+func (c *Cluster) CheckConnectionContext(ctx context.Context) error {
+	// スタンバイ接続テスト
+	for _, n := range c.StandbyNodes() {
+		var standbyVersion string
+		err := n.DB.QueryRowContext(ctx, "SELECT version()").Scan(&standbyVersion)
+		if err != nil {
+			return n.nodeError("接続確認", err)
+		}
+	}
+
+	// プライマリ接続テスト
+	if c.config().PrimaryDirect {
+		var primaryVersion string
+		err := c.Primary.QueryRowContext(ctx, "SELECT version()").Scan(&primaryVersion)
+		return c.primaryError("接続確認", err)
+	}
+	return c.testPrimaryConnectionDocker(ctx)
+}
@@ -0,0 +1,311 @@
+// Package replication PostgreSQLストリーミングレプリケーション環境向けの読み書き分離ライブラリ
+package replication
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Endpoint 接続先ノードのホストとポート
+type Endpoint struct {
+	Host string
+	Port int
+}
+
+// String host:port 形式で返す
+func (e Endpoint) String() string {
+	return fmt.Sprintf("%s:%d", e.Host, e.Port)
+}
+
+// StandbyConfig スタンバイノードの設定
+type StandbyConfig struct {
+	// Name ノード名（省略時は host:port）
+	Name string
+	Endpoint
+	// Weight 重み付きルーティングでの重み（0以下は1として扱う）
+	Weight int
+	// Zone 配置されているゾーン（情報表示用）
+	Zone string
+
+	// MaxOpenConns このノードのコネクションプールの最大接続数（0の場合はConfig.MaxOpenConns）
+	MaxOpenConns int
+	// MaxIdleConns このノードで保持するアイドル接続数（0の場合はConfig.MaxIdleConns）
+	MaxIdleConns int
+
+	// MaxStaleness 読み取りで許容する再生遅延（0の場合は判定しない）。WithMaxStalenessが優先される
+	MaxStaleness time.Duration
+	// MaxLagBytes 読み取りで許容するWAL位置の差（0の場合は判定しない）。WithMaxLagBytesが優先される
+	MaxLagBytes int64
+
+	// TLS このノードへの接続のTLS設定（空の項目はConfig.TLSを使う）
+	TLS TLSConfig
+}
+
+// Config クラスタへの接続設定
+type Config struct {
+	User     string
+	Password string
+	DBName   string
+	// Credentials 接続ごとに認証情報を取得する方法（nilの場合はUserとPasswordを使う）
+	Credentials CredentialProvider
+
+	Primary  Endpoint
+	Standbys []StandbyConfig
+
+	// PrimaryZone プライマリが配置されているゾーン（情報表示用）
+	PrimaryZone string
+
+	// TLS 全ノードへの接続のTLS設定
+	TLS TLSConfig
+	// PrimaryTLS プライマリへの接続のTLS設定（空の項目はTLSを使う）
+	PrimaryTLS TLSConfig
+
+	// RoutingPolicy スタンバイの選択方式（round-robin / weighted / least-connections / least-lag）
+	RoutingPolicy string
+
+	// ConnectTimeout 接続タイムアウト（秒）。0の場合は指定しない
+	ConnectTimeout int
+
+	// MaxOpenConns 各ノードのコネクションプールの最大接続数
+	MaxOpenConns int
+	// MaxIdleConns 各ノードのコネクションプールで保持するアイドル接続数
+	MaxIdleConns int
+	// ConnMaxLifetime 接続を再利用できる最大時間
+	ConnMaxLifetime time.Duration
+
+	// ReplayWaitTimeout AfterLSN指定の読み取りでスタンバイの再生を待つ最大時間
+	ReplayWaitTimeout time.Duration
+
+	// Retry スタンバイでのリカバリ競合時のリトライ設定
+	Retry RetryPolicy
+
+	// StickyPrimaryWindow WithSessionで指定したセッションが書き込んだ後、そのセッションの読み取りをプライマリへ固定する期間（0で無効）
+	StickyPrimaryWindow time.Duration
+
+	// StripRouteHints trueの場合、読み書き分離ドライバーはルーティングヒントのコメントを取り除いてから送信する
+	StripRouteHints bool
+
+	// PrimaryDirect trueの場合はプライマリの接続確認も直接接続で行い、falseの場合はdocker exec経由で行う
+	PrimaryDirect bool
+	// PrimaryContainer docker exec経由で操作する際のコンテナ名
+	PrimaryContainer string
+}
+
+// LoadConfig 環境変数から接続設定を読み込む（デモ用デフォルト値付き）
+func LoadConfig() Config {
+	cfg := defaultConfig()
+	applyEnv(&cfg)
+	return cfg
+}
+
+// defaultConfig デモ環境（docker-compose）向けのデフォルト設定
+func defaultConfig() Config {
+	return Config{
+		User:     "postgres",
+		Password: "password",
+		DBName:   "testdb",
+		Primary:  Endpoint{Host: normalizeHost("localhost"), Port: 5432},
+		Standbys: []StandbyConfig{{
+			Endpoint: Endpoint{Host: normalizeHost("localhost"), Port: 5433},
+			Weight:   1,
+		}},
+		RoutingPolicy:     PolicyRoundRobin,
+		ConnectTimeout:    10,
+		MaxOpenConns:      10,
+		MaxIdleConns:      5,
+		ConnMaxLifetime:   30 * time.Minute,
+		ReplayWaitTimeout: defaultReplayWaitTimeout,
+		Retry: RetryPolicy{
+			MaxRetries:     defaultMaxRetries,
+			InitialBackoff: defaultInitialBackoff,
+			MaxBackoff:     defaultMaxBackoff,
+			Budget:         defaultRetryBudget,
+		},
+		StickyPrimaryWindow: defaultStickyPrimaryWindow,
+		PrimaryContainer:    "postgres-primary",
+	}
+}
+
+// applyEnv 設定されている環境変数でcfgを上書きする
+func applyEnv(cfg *Config) {
+	cfg.User = GetEnv("POSTGRES_USER", cfg.User)
+	cfg.Password = GetEnv("POSTGRES_PASSWORD", cfg.Password)
+	cfg.DBName = GetEnv("POSTGRES_DB", cfg.DBName)
+	cfg.TLS.SSLMode = GetEnv("POSTGRES_SSLMODE", cfg.TLS.SSLMode)
+	cfg.TLS.RootCert = GetEnv("POSTGRES_SSLROOTCERT", cfg.TLS.RootCert)
+	cfg.TLS.Cert = GetEnv("POSTGRES_SSLCERT", cfg.TLS.Cert)
+	cfg.TLS.Key = GetEnv("POSTGRES_SSLKEY", cfg.TLS.Key)
+
+	// パスワードの取得方法（POSTGRES_PASSWORD_COMMAND > POSTGRES_PASSWORD_FILE > PGPASSFILE > POSTGRES_PASSWORD）
+	switch {
+	case os.Getenv("POSTGRES_PASSWORD_COMMAND") != "":
+		cfg.Credentials = &ExecCredentials{
+			Command: strings.Fields(os.Getenv("POSTGRES_PASSWORD_COMMAND")),
+			TTL:     getEnvDuration("POSTGRES_PASSWORD_COMMAND_TTL", defaultCredentialTTL),
+		}
+	case os.Getenv("POSTGRES_PASSWORD_FILE") != "":
+		cfg.Credentials = PasswordFile{Path: os.Getenv("POSTGRES_PASSWORD_FILE"), UserPath: os.Getenv("POSTGRES_USER_FILE")}
+	case os.Getenv("PGPASSFILE") != "":
+		cfg.Credentials = Pgpass{Path: os.Getenv("PGPASSFILE")}
+	case os.Getenv("POSTGRES_PASSWORD") != "":
+		cfg.Credentials = nil
+	}
+
+	// Docker環境では直接DB接続、ローカル環境ではdocker exec
+	if host := os.Getenv("POSTGRES_PRIMARY_HOST"); host != "" {
+		cfg.Primary.Host = normalizeHost(host)
+		cfg.PrimaryDirect = true
+	}
+	cfg.Primary.Port = getEnvInt("POSTGRES_PRIMARY_PORT", cfg.Primary.Port)
+	if os.Getenv("POSTGRES_STANDBY_HOSTS") != "" || os.Getenv("POSTGRES_STANDBY_HOST") != "" || os.Getenv("POSTGRES_STANDBY_PORT") != "" {
+		cfg.Standbys = loadStandbys()
+	}
+
+	cfg.RoutingPolicy = GetEnv("POSTGRES_ROUTING_POLICY", cfg.RoutingPolicy)
+	cfg.MaxOpenConns = getEnvInt("POSTGRES_MAX_OPEN_CONNS", cfg.MaxOpenConns)
+	cfg.MaxIdleConns = getEnvInt("POSTGRES_MAX_IDLE_CONNS", cfg.MaxIdleConns)
+	cfg.ReplayWaitTimeout = getEnvDuration("POSTGRES_REPLAY_WAIT_TIMEOUT", cfg.ReplayWaitTimeout)
+	cfg.Retry.MaxRetries = getEnvInt("POSTGRES_RETRY_MAX", cfg.Retry.MaxRetries)
+	cfg.Retry.InitialBackoff = getEnvDuration("POSTGRES_RETRY_BACKOFF", cfg.Retry.InitialBackoff)
+	cfg.Retry.Budget = getEnvDuration("POSTGRES_RETRY_BUDGET", cfg.Retry.Budget)
+	cfg.StickyPrimaryWindow = getEnvDuration("POSTGRES_STICKY_PRIMARY_WINDOW", cfg.StickyPrimaryWindow)
+	cfg.StripRouteHints = getEnvBool("POSTGRES_STRIP_ROUTE_HINTS", cfg.StripRouteHints)
+}
+
+// loadStandbys スタンバイ一覧を環境変数から読み込む。
+// POSTGRES_STANDBY_HOSTS（例: "replica1:5432,replica2:5432"）と POSTGRES_STANDBY_WEIGHTS（例: "3,1"）で複数指定でき、
+// 未設定の場合は POSTGRES_STANDBY_HOST / POSTGRES_STANDBY_PORT の1台を使う
+func loadStandbys() []StandbyConfig {
+	hosts := os.Getenv("POSTGRES_STANDBY_HOSTS")
+	if hosts == "" {
+		return []StandbyConfig{{
+			Endpoint: Endpoint{
+				Host: normalizeHost(GetEnv("POSTGRES_STANDBY_HOST", "localhost")),
+				Port: getEnvInt("POSTGRES_STANDBY_PORT", 5433),
+			},
+			Weight: 1,
+		}}
+	}
+
+	weights := strings.Split(os.Getenv("POSTGRES_STANDBY_WEIGHTS"), ",")
+	var standbys []StandbyConfig
+	for i, hostPort := range strings.Split(hosts, ",") {
+		hostPort = strings.TrimSpace(hostPort)
+		if hostPort == "" {
+			continue
+		}
+		weight := 1
+		if i < len(weights) {
+			if w, err := strconv.Atoi(strings.TrimSpace(weights[i])); err == nil {
+				weight = w
+			}
+		}
+		standbys = append(standbys, StandbyConfig{Endpoint: parseEndpoint(hostPort, 5432), Weight: weight})
+	}
+	return standbys
+}
+
+// parseEndpoint "host:port" 形式を解析。ポート省略時はdefaultPortを使う
+func parseEndpoint(hostPort string, defaultPort int) Endpoint {
+	host, portStr, ok := strings.Cut(hostPort, ":")
+	port, err := strconv.Atoi(portStr)
+	if !ok || err != nil {
+		port = defaultPort
+	}
+	return Endpoint{Host: normalizeHost(host), Port: port}
+}
+
+// DSN 指定ノードへのlib/pq接続文字列を構築（UserとPasswordを使う）
+func (c Config) DSN(e Endpoint) string {
+	return c.dsn(e, Credentials{User: c.User, Password: c.Password})
+}
+
+// dsn 指定した認証情報で接続文字列を構築
+func (c Config) dsn(e Endpoint, creds Credentials) string {
+	dsn := fmt.Sprintf("host=%s port=%d user=%s password=%s dbname=%s %s",
+		e.Host, e.Port, quoteDSNValue(creds.User), quoteDSNValue(creds.Password), quoteDSNValue(c.DBName), c.TLSFor(e).params())
+	if c.ConnectTimeout > 0 {
+		dsn += fmt.Sprintf(" connect_timeout=%d", c.ConnectTimeout)
+	}
+	return dsn
+}
+
+// credentials 認証情報の取得方法
+func (c Config) credentials() CredentialProvider {
+	if c.Credentials != nil {
+		return c.Credentials
+	}
+	return StaticCredentials{User: c.User, Password: c.Password}
+}
+
+// endpoints プライマリ、スタンバイの順に全ノードの接続先を返す
+func (c Config) endpoints() []Endpoint {
+	endpoints := []Endpoint{c.Primary}
+	for _, sc := range c.Standbys {
+		endpoints = append(endpoints, sc.Endpoint)
+	}
+	return endpoints
+}
+
+// MultiHostDSN プライマリと全スタンバイを列挙したMultiHostDriver用の接続文字列を構築。
+// 認証情報はUserとPassword、TLSは全ノード共通のConfig.TLSを使う（PrimaryTLSとスタンバイごとのTLSは反映されない）。
+// Config.CredentialsやノードごとのTLS設定を使う場合はNewMultiHostConnectorFromConfigを使う
+func (c Config) MultiHostDSN(attrs TargetSessionAttrs) string {
+	endpoints := c.endpoints()
+	hosts := make([]string, len(endpoints))
+	ports := make([]string, len(endpoints))
+	for i, e := range endpoints {
+		hosts[i] = e.Host
+		ports[i] = strconv.Itoa(e.Port)
+	}
+	dsn := fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s %s target_session_attrs=%s",
+		quoteDSNValue(strings.Join(hosts, ",")), quoteDSNValue(strings.Join(ports, ",")),
+		quoteDSNValue(c.User), quoteDSNValue(c.Password), quoteDSNValue(c.DBName), c.TLS.params(), quoteDSNValue(string(attrs)))
+	if c.ConnectTimeout > 0 {
+		dsn += fmt.Sprintf(" connect_timeout=%d", c.ConnectTimeout)
+	}
+	return dsn
+}
+
+// GetEnv 環境変数を取得、存在しない場合はデフォルト値を返す
+func GetEnv(key, defaultValue string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return defaultValue
+}
+
+// getEnvInt 環境変数を整数として取得、存在しないか不正な場合はデフォルト値を返す
+func getEnvInt(key string, defaultValue int) int {
+	if value, err := strconv.Atoi(os.Getenv(key)); err == nil {
+		return value
+	}
+	return defaultValue
+}
+
+// getEnvBool 環境変数を真偽値（"true", "1" など）として取得、存在しないか不正な場合はデフォルト値を返す
+func getEnvBool(key string, defaultValue bool) bool {
+	if value, err := strconv.ParseBool(os.Getenv(key)); err == nil {
+		return value
+	}
+	return defaultValue
+}
+
+// getEnvDuration 環境変数を時間（例: "500ms", "5s"）として取得、存在しないか不正な場合はデフォルト値を返す
+func getEnvDuration(key string, defaultValue time.Duration) time.Duration {
+	if value, err := time.ParseDuration(os.Getenv(key)); err == nil {
+		return value
+	}
+	return defaultValue
+}
+
+// normalizeHost IPv4を強制するためにlocalhostを127.0.0.1に変換
+func normalizeHost(host string) string {
+	if host == "localhost" {
+		return "127.0.0.1"
+	}
+	return host
+}
@@ -0,0 +1,120 @@
+package replication
+
+import (
+	"strings"
+	"testing"
+)
+
+// TestLoadConfig 環境変数からの設定読み込みテスト
+func TestLoadConfig(t *testing.T) {
+	t.Setenv("POSTGRES_USER", "app")
+	t.Setenv("POSTGRES_PASSWORD", "secret")
+	t.Setenv("POSTGRES_DB", "appdb")
+	t.Setenv("POSTGRES_PRIMARY_HOST", "")
+	t.Setenv("POSTGRES_STANDBY_HOST", "localhost")
+	t.Setenv("POSTGRES_STANDBY_PORT", "6543")
+
+	cfg := LoadConfig()
+
+	if cfg.User != "app" || cfg.Password != "secret" || cfg.DBName != "appdb" {
+		t.Fatalf("認証情報が不正: %+v", cfg)
+	}
+	if len(cfg.Standbys) != 1 || cfg.Standbys[0].Host != "127.0.0.1" || cfg.Standbys[0].Port != 6543 {
+		t.Fatalf("スタンバイ接続先が不正: %+v", cfg.Standbys)
+	}
+	if cfg.Primary.Port != 5432 {
+		t.Fatalf("プライマリのデフォルトポートが不正: %d", cfg.Primary.Port)
+	}
+	if cfg.PrimaryDirect {
+		t.Fatal("POSTGRES_PRIMARY_HOST未設定時はdocker exec経由であるべき")
+	}
+}
+
+// TestLoadConfigMultipleStandbys 複数スタンバイの設定読み込みテスト
+func TestLoadConfigMultipleStandbys(t *testing.T) {
+	t.Setenv("POSTGRES_STANDBY_HOSTS", "replica1:5433, replica2, localhost:5435")
+	t.Setenv("POSTGRES_STANDBY_WEIGHTS", "3,1")
+
+	standbys := LoadConfig().Standbys
+	want := []StandbyConfig{
+		{Endpoint: Endpoint{Host: "replica1", Port: 5433}, Weight: 3},
+		{Endpoint: Endpoint{Host: "replica2", Port: 5432}, Weight: 1},
+		{Endpoint: Endpoint{Host: "127.0.0.1", Port: 5435}, Weight: 1},
+	}
+	if len(standbys) != len(want) {
+		t.Fatalf("スタンバイ数が不正: %+v", standbys)
+	}
+	for i := range want {
+		if standbys[i] != want[i] {
+			t.Errorf("スタンバイ%dが不正: %+v, want %+v", i, standbys[i], want[i])
+		}
+	}
+}
+
+// TestConfigDSN 接続文字列の構築テスト
+func TestConfigDSN(t *testing.T) {
+	cfg := Config{User: "u", Password: "p", DBName: "d", ConnectTimeout: 5}
+	dsn := cfg.DSN(Endpoint{Host: "db1", Port: 5433})
+
+	for _, want := range []string{"host=db1", "port=5433", "user=u", "password=p", "dbname=d", "connect_timeout=5"} {
+		if !strings.Contains(dsn, want) {
+			t.Errorf("DSNに%qが含まれていない: %s", want, dsn)
+		}
+	}
+}
+
+// TestConfigMultiHostDSN 空白や引用符を含む値も複数ホスト接続文字列で正しく受け渡されるテスト
+func TestConfigMultiHostDSN(t *testing.T) {
+	cfg := Config{
+		User: "app user", Password: `p a'ss\word`, DBName: "d",
+		Primary:  Endpoint{Host: "db1", Port: 5432},
+		Standbys: []StandbyConfig{{Endpoint: Endpoint{Host: "db2", Port: 5433}}},
+	}
+	opts, err := parseMultiHostDSN(cfg.MultiHostDSN(SessionStandby))
+	if err != nil {
+		t.Fatalf("パースエラー: %v", err)
+	}
+	want := map[string]string{
+		"host": "db1,db2", "port": "5432,5433", "user": "app user", "password": `p a'ss\word`,
+		"dbname": "d", "target_session_attrs": "standby",
+	}
+	for k, v := range want {
+		if opts[k] != v {
+			t.Errorf("%s = %q, want %q", k, opts[k], v)
+		}
+	}
+}
+
+// TestConfigDSNTLS ノードごとのTLS設定が接続文字列に反映されるテスト
+func TestConfigDSNTLS(t *testing.T) {
+	standby := Endpoint{Host: "db2", Port: 5432}
+	cfg := Config{
+		User: "u", Password: "p", DBName: "d",
+		Primary:    Endpoint{Host: "db1", Port: 5432},
+		TLS:        TLSConfig{SSLMode: SSLVerifyFull, RootCert: "/certs/root.crt"},
+		PrimaryTLS: TLSConfig{Cert: "/certs/app client.crt", Key: "/certs/app.key"},
+		Standbys:   []StandbyConfig{{Endpoint: standby, TLS: TLSConfig{SSLMode: SSLRequire}}},
+	}
+
+	cases := map[Endpoint][]string{
+		cfg.Primary: {"sslmode=verify-full", "sslrootcert=/certs/root.crt", `sslcert='/certs/app client.crt'`, "sslkey=/certs/app.key"},
+		standby:     {"sslmode=require", "sslrootcert=/certs/root.crt"},
+	}
+	for e, wants := range cases {
+		dsn := cfg.DSN(e)
+		for _, want := range wants {
+			if !strings.Contains(dsn, want) {
+				t.Errorf("%s: DSNに%qが含まれていない: %s", e, want, dsn)
+			}
+		}
+	}
+	if dsn := cfg.DSN(standby); strings.Contains(dsn, "sslcert") {
+		t.Errorf("プライマリのクライアント証明書がスタンバイに使われている: %s", dsn)
+	}
+
+	// sslmode=disable の場合は証明書を指定しない
+	plain := Config{TLS: TLSConfig{RootCert: "/certs/root.crt"}}
+	if dsn := plain.DSN(Endpoint{Host: "db1", Port: 5432}); !strings.Contains(dsn, "sslmode=disable") || strings.Contains(dsn, "sslrootcert") {
+		t.Errorf("TLS無効時のDSNが不正: %s", dsn)
+	}
+}
@@ -0,0 +1,343 @@
+package replication
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// 認証情報の取得に関する設定値
+const (
+	// defaultCredentialTTL 環境変数・トポロジーファイルで指定したコマンドの結果をキャッシュする時間
+	defaultCredentialTTL = 5 * time.Minute
+	// credentialCommandTimeout 認証情報を取得するコマンドの最大実行時間
+	credentialCommandTimeout = 10 * time.Second
+)
+
+// Credentials 接続に使う認証情報
+type Credentials struct {
+	// User ユーザー名（空の場合はConfig.User）
+	User     string
+	Password string
+}
+
+// CredentialRequest 認証情報を要求している接続先
+type CredentialRequest struct {
+	Endpoint Endpoint
+	DBName   string
+	// User 設定されているユーザー名
+	User string
+}
+
+// CredentialProvider 接続先の認証情報を返す。新しい物理接続を開くたびに呼ばれるため、
+// ローテーション後の値を返せば、既存のコネクションプールも次の接続から新しい認証情報を使う。
+// 複数のgoroutineから同時に呼ばれる
+type CredentialProvider interface {
+	Credentials(ctx context.Context, req CredentialRequest) (Credentials, error)
+}
+
+// CredentialInvalidator 認証に失敗した場合にキャッシュを破棄できるCredentialProvider。
+// 破棄した後、1回だけ接続を再試行する
+type CredentialInvalidator interface {
+	Invalidate()
+}
+
+// StaticCredentials 固定の認証情報
+type StaticCredentials Credentials
+
+// Credentials 固定の認証情報を返す
+func (s StaticCredentials) Credentials(context.Context, CredentialRequest) (Credentials, error) {
+	return Credentials(s), nil
+}
+
+// PasswordFile ファイルからパスワードを読み込む（Docker secretsなどの *_FILE 形式）。
+// 接続のたびに読み込むため、ファイルを書き換えればローテーションできる
+type PasswordFile struct {
+	// Path パスワードのファイル
+	Path string
+	// UserPath ユーザー名のファイル（空の場合はConfig.User）
+	UserPath string
+}
+
+// Credentials ファイルの内容（末尾の改行を除く）を認証情報として返す
+func (f PasswordFile) Credentials(context.Context, CredentialRequest) (Credentials, error) {
+	var creds Credentials
+	var err error
+	if creds.Password, err = readSecretFile(f.Path); err != nil {
+		return Credentials{}, err
+	}
+	if f.UserPath != "" {
+		if creds.User, err = readSecretFile(f.UserPath); err != nil {
+			return Credentials{}, err
+		}
+	}
+	return creds, nil
+}
+
+// readSecretFile 秘密情報のファイルを読み込み、末尾の改行を除いて返す
+func readSecretFile(path string) (string, error) {
+	data, err := os.ReadFile(path) // #nosec G304 -- 秘密情報のファイルのパスは利用者が指定する
+	if err != nil {
+		return "", fmt.Errorf("秘密情報ファイル読み込みエラー: %w", err)
+	}
+	return strings.TrimRight(string(data), "\r\n"), nil
+}
+
+// Pgpass libpqと同じ形式のパスワードファイル（hostname:port:database:username:password）から
+// 接続先に一致する最初のエントリのパスワードを返す。接続のたびに読み込む
+type Pgpass struct {
+	// Path ファイルのパス（空の場合はPGPASSFILE、なければ ~/.pgpass）
+	Path string
+}
+
+// Credentials 接続先に一致するエントリのパスワードを返す
+func (p Pgpass) Credentials(_ context.Context, req CredentialRequest) (Credentials, error) {
+	path := p.Path
+	if path == "" {
+		path = DefaultPgpassPath()
+	}
+	f, err := os.Open(path) // #nosec G304 -- パスワードファイルのパスは利用者が指定する
+	if err != nil {
+		return Credentials{}, fmt.Errorf("パスワードファイル読み込みエラー: %w", err)
+	}
+	defer func() { _ = f.Close() }()
+
+	// libpqと同様に、他のユーザーが読み取れるファイルは使わない
+	if info, err := f.Stat(); err == nil && runtime.GOOS != "windows" && info.Mode().Perm()&0o077 != 0 {
+		return Credentials{}, fmt.Errorf("パスワードファイル %s のパーミッションは0600以下にしてください", path)
+	}
+
+	password, ok, err := lookupPgpass(f, req)
+	if err != nil {
+		return Credentials{}, fmt.Errorf("パスワードファイル読み込みエラー: %w", err)
+	}
+	if !ok {
+		return Credentials{}, fmt.Errorf("パスワードファイル %s に %s/%s (%s) のエントリがありません",
+			path, req.Endpoint, req.DBName, req.User)
+	}
+	return Credentials{Password: password}, nil
+}
+
+// DefaultPgpassPath libpqと同じ規則でパスワードファイルのパスを返す
+func DefaultPgpassPath() string {
+	if path := os.Getenv("PGPASSFILE"); path != "" {
+		return path
+	}
+	if runtime.GOOS == "windows" {
+		return filepath.Join(os.Getenv("APPDATA"), "postgresql", "pgpass.conf")
+	}
+	home, _ := os.UserHomeDir()
+	return filepath.Join(home, ".pgpass")
+}
+
+// lookupPgpass 接続先に一致する最初のエントリを探す。* は任意の値に一致する。
+// LoadConfigでlocalhostを127.0.0.1に変換しているため、127.0.0.1 への接続には localhost のエントリも一致する
+func lookupPgpass(r *os.File, req CredentialRequest) (string, bool, error) {
+	want := []string{req.Endpoint.Host, strconv.Itoa(req.Endpoint.Port), req.DBName, req.User}
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := splitPgpassLine(line)
+		if len(fields) != 5 {
+			continue
+		}
+		matched := true
+		for i, w := range want {
+			f := fields[i]
+			if f == "*" || f == w || (i == 0 && f == "localhost" && w == "127.0.0.1") {
+				continue
+			}
+			matched = false
+			break
+		}
+		if matched {
+			return fields[4], true, nil
+		}
+	}
+	return "", false, scanner.Err()
+}
+
+// splitPgpassLine : 区切りでフィールドに分ける。\: と \\ はエスケープとして扱う
+func splitPgpassLine(line string) []string {
+	var fields []string
+	var b strings.Builder
+	for i := 0; i < len(line); i++ {
+		switch c := line[i]; {
+		case c == '\\' && i+1 < len(line):
+			i++
+			b.WriteByte(line[i])
+		case c == ':' && len(fields) < 4:
+			fields = append(fields, b.String())
+			b.Reset()
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return append(fields, b.String())
+}
+
+// ExecCredentials 外部コマンド（シークレットマネージャーのCLIなど）の出力を認証情報とする。
+// 出力は1行目をパスワードとするか、{"user": "...", "password": "..."} 形式のJSONとする。
+// コマンドには接続先を PGHOST / PGPORT / PGDATABASE / PGUSER 環境変数で渡す
+type ExecCredentials struct {
+	// Command 実行するコマンドと引数（シェルは経由しない）
+	Command []string
+	// TTL 結果をキャッシュする時間（0の場合は接続のたびに実行する）
+	TTL time.Duration
+
+	mu    sync.Mutex
+	cache map[CredentialRequest]cachedCredentials
+}
+
+// cachedCredentials キャッシュした認証情報と有効期限
+type cachedCredentials struct {
+	creds   Credentials
+	expires time.Time
+}
+
+// Credentials コマンドを実行して認証情報を返す（TTL内はキャッシュを返す）
+func (e *ExecCredentials) Credentials(ctx context.Context, req CredentialRequest) (Credentials, error) {
+	if len(e.Command) == 0 {
+		return Credentials{}, errors.New("認証情報を取得するコマンドが指定されていません")
+	}
+	e.mu.Lock()
+	cached, ok := e.cache[req]
+	e.mu.Unlock()
+	if ok && time.Now().Before(cached.expires) {
+		return cached.creds, nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, credentialCommandTimeout)
+	defer cancel()
+	// #nosec G204 -- コマンドは利用者が設定したもののみを実行する
+	cmd := exec.CommandContext(ctx, e.Command[0], e.Command[1:]...)
+	cmd.Env = append(os.Environ(),
+		"PGHOST="+req.Endpoint.Host,
+		"PGPORT="+strconv.Itoa(req.Endpoint.Port),
+		"PGDATABASE="+req.DBName,
+		"PGUSER="+req.User,
+	)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return Credentials{}, fmt.Errorf("認証情報取得コマンドエラー: %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	creds, err := parseCredentialOutput(out)
+	if err != nil {
+		return Credentials{}, err
+	}
+
+	if e.TTL > 0 {
+		e.mu.Lock()
+		if e.cache == nil {
+			e.cache = make(map[CredentialRequest]cachedCredentials)
+		}
+		e.cache[req] = cachedCredentials{creds: creds, expires: time.Now().Add(e.TTL)}
+		e.mu.Unlock()
+	}
+	return creds, nil
+}
+
+// Invalidate キャッシュを破棄し、次の接続でコマンドを再実行する
+func (e *ExecCredentials) Invalidate() {
+	e.mu.Lock()
+	e.cache = nil
+	e.mu.Unlock()
+}
+
+// parseCredentialOutput コマンドの出力を認証情報として解釈する
+func parseCredentialOutput(out []byte) (Credentials, error) {
+	trimmed := bytes.TrimSpace(out)
+	if bytes.HasPrefix(trimmed, []byte("{")) {
+		var v struct {
+			User     string `json:"user"`
+			Password string `json:"password"`
+		}
+		if err := json.Unmarshal(trimmed, &v); err != nil {
+			return Credentials{}, fmt.Errorf("認証情報取得コマンドの出力が不正です: %v", err)
+		}
+		return Credentials{User: v.User, Password: v.Password}, nil
+	}
+	line, _, _ := strings.Cut(strings.TrimRight(string(out), "\r\n"), "\n")
+	return Credentials{Password: strings.TrimRight(line, "\r")}, nil
+}
+
+// NewCredentialConnector 接続のたびにConfig.Credentialsから認証情報を取得してノードへ接続するdriver.Connector。
+// 認証に失敗した場合は、CredentialInvalidatorであればキャッシュを破棄して1回だけ再接続する
+func NewCredentialConnector(cfg Config, e Endpoint) driver.Connector {
+	return &credentialConnector{cfg: cfg, endpoint: e, provider: cfg.credentials(), dial: dialPostgres}
+}
+
+// credentialConnector 接続のたびに認証情報を取得するdriver.Connector
+type credentialConnector struct {
+	cfg      Config
+	endpoint Endpoint
+	provider CredentialProvider
+	dial     func(ctx context.Context, dsn string) (driver.Conn, error)
+}
+
+// dialPostgres lib/pqで接続する
+func dialPostgres(ctx context.Context, dsn string) (driver.Conn, error) {
+	c, err := pq.NewConnector(dsn)
+	if err != nil {
+		return nil, err
+	}
+	return c.Connect(ctx)
+}
+
+// Connect 認証情報を取得して接続する
+func (c *credentialConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	conn, err := c.connect(ctx)
+	if err != nil && isAuthFailure(err) {
+		if inv, ok := c.provider.(CredentialInvalidator); ok {
+			inv.Invalidate()
+			return c.connect(ctx)
+		}
+	}
+	return conn, err
+}
+
+// connect 1回分の接続
+func (c *credentialConnector) connect(ctx context.Context) (driver.Conn, error) {
+	req := CredentialRequest{Endpoint: c.endpoint, DBName: c.cfg.DBName, User: c.cfg.User}
+	creds, err := c.provider.Credentials(ctx, req)
+	if err != nil {
+		return nil, fmt.Errorf("認証情報取得エラー (%s): %w", c.endpoint, err)
+	}
+	if creds.User == "" {
+		creds.User = c.cfg.User
+	}
+	return c.dial(ctx, c.cfg.dsn(c.endpoint, creds))
+}
+
+// Driver lib/pqのドライバーを返す
+func (c *credentialConnector) Driver() driver.Driver {
+	return &pq.Driver{}
+}
+
+// isAuthFailure 認証の失敗か（SQLSTATE 28P01 invalid_password / 28000 invalid_authorization_specification）
+func isAuthFailure(err error) bool {
+	switch SQLState(err) {
+	case "28P01", "28000":
+		return true
+	}
+	return false
+}
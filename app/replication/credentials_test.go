@@ -0,0 +1,155 @@
+package replication
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// TestPgpass パスワードファイルの検索のテスト
+func TestPgpass(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "pgpass")
+	data := "# comment\n" +
+		"db1:5432:testdb:postgres:first\n" +
+		"db1:*:*:postgres:wildcard\n" +
+		"localhost:5433:testdb:postgres:local\n" +
+		`db2:5432:testdb:app:pa\:ss\\word` + "\n"
+	if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	p := Pgpass{Path: path}
+
+	cases := []struct {
+		req  CredentialRequest
+		want string
+	}{
+		{CredentialRequest{Endpoint: Endpoint{Host: "db1", Port: 5432}, DBName: "testdb", User: "postgres"}, "first"},
+		{CredentialRequest{Endpoint: Endpoint{Host: "db1", Port: 6432}, DBName: "other", User: "postgres"}, "wildcard"},
+		{CredentialRequest{Endpoint: Endpoint{Host: "127.0.0.1", Port: 5433}, DBName: "testdb", User: "postgres"}, "local"},
+		{CredentialRequest{Endpoint: Endpoint{Host: "db2", Port: 5432}, DBName: "testdb", User: "app"}, `pa:ss\word`},
+	}
+	for _, tc := range cases {
+		creds, err := p.Credentials(context.Background(), tc.req)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.req.Endpoint, err)
+		}
+		if creds.Password != tc.want {
+			t.Errorf("%s: password = %q, want %q", tc.req.Endpoint, creds.Password, tc.want)
+		}
+	}
+
+	if _, err := p.Credentials(context.Background(), CredentialRequest{Endpoint: Endpoint{Host: "db3", Port: 5432}}); err == nil {
+		t.Error("一致するエントリがないのにエラーにならない")
+	}
+	if err := os.Chmod(path, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.Credentials(context.Background(), cases[0].req); err == nil || !strings.Contains(err.Error(), "パーミッション") {
+		t.Errorf("他のユーザーが読めるファイルはエラーになるはず: %v", err)
+	}
+}
+
+// TestPasswordFile 秘密情報ファイルの読み込みと書き換えの反映のテスト
+func TestPasswordFile(t *testing.T) {
+	dir := t.TempDir()
+	passPath, userPath := filepath.Join(dir, "password"), filepath.Join(dir, "user")
+	write := func(path, s string) {
+		if err := os.WriteFile(path, []byte(s), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(passPath, "secret\r\n")
+	write(userPath, "app\n")
+
+	f := PasswordFile{Path: passPath, UserPath: userPath}
+	creds, err := f.Credentials(context.Background(), CredentialRequest{})
+	if err != nil || creds != (Credentials{User: "app", Password: "secret"}) {
+		t.Fatalf("認証情報が不正: %+v %v", creds, err)
+	}
+	write(passPath, "rotated\n")
+	if creds, _ := f.Credentials(context.Background(), CredentialRequest{}); creds.Password != "rotated" {
+		t.Errorf("書き換えたパスワードが反映されない: %q", creds.Password)
+	}
+}
+
+// TestExecCredentials コマンドの出力の解釈とキャッシュのテスト
+func TestExecCredentials(t *testing.T) {
+	req := CredentialRequest{Endpoint: Endpoint{Host: "db1", Port: 5432}, DBName: "testdb", User: "postgres"}
+
+	e := &ExecCredentials{Command: []string{"sh", "-c", `echo "$PGHOST-$(date +%s%N)"`}, TTL: time.Hour}
+	first, err := e.Credentials(context.Background(), req)
+	if err != nil {
+		t.Skipf("shが使えない: %v", err)
+	}
+	if !strings.HasPrefix(first.Password, "db1-") {
+		t.Errorf("接続先が環境変数で渡されていない: %q", first.Password)
+	}
+	if again, _ := e.Credentials(context.Background(), req); again != first {
+		t.Errorf("TTL内はキャッシュを返すはず: %q != %q", again.Password, first.Password)
+	}
+	e.Invalidate()
+	if again, _ := e.Credentials(context.Background(), req); again == first {
+		t.Error("Invalidateの後はコマンドを再実行するはず")
+	}
+
+	jsonOut := &ExecCredentials{Command: []string{"echo", `{"user": "app", "password": "secret"}`}}
+	if creds, err := jsonOut.Credentials(context.Background(), req); err != nil || creds != (Credentials{User: "app", Password: "secret"}) {
+		t.Errorf("JSON出力の解釈が不正: %+v %v", creds, err)
+	}
+	failing := &ExecCredentials{Command: []string{"false"}}
+	if _, err := failing.Credentials(context.Background(), req); err == nil {
+		t.Error("コマンドの失敗がエラーにならない")
+	}
+}
+
+// TestCredentialConnectorRotation 認証失敗時にキャッシュを破棄して再接続するテスト
+func TestCredentialConnectorRotation(t *testing.T) {
+	provider := &rotatingProvider{passwords: []string{"old", "new"}}
+	cfg := Config{User: "postgres", DBName: "testdb", Credentials: provider}
+	var dialed []string
+	c := NewCredentialConnector(cfg, Endpoint{Host: "db1", Port: 5432}).(*credentialConnector)
+	c.dial = func(_ context.Context, dsn string) (driver.Conn, error) {
+		dialed = append(dialed, dsn)
+		if !strings.Contains(dsn, "password=new ") {
+			return nil, &pq.Error{Code: "28P01", Message: "password authentication failed"}
+		}
+		return &sessionConn{name: "db1"}, nil
+	}
+
+	if _, err := c.Connect(context.Background()); err != nil {
+		t.Fatalf("再接続できない: %v", err)
+	}
+	if len(dialed) != 2 || provider.invalidated != 1 {
+		t.Errorf("1回だけ再接続するはず: dial=%d invalidate=%d", len(dialed), provider.invalidated)
+	}
+	if !strings.Contains(dialed[0], "user=postgres ") {
+		t.Errorf("ユーザー名はConfig.Userを使うはず: %s", dialed[0])
+	}
+
+	// 再試行しても認証に失敗する場合はエラーを返す
+	provider.passwords = []string{"old", "older"}
+	provider.invalidated = 0
+	var pqErr *pq.Error
+	if _, err := c.Connect(context.Background()); !errors.As(err, &pqErr) || provider.invalidated != 1 {
+		t.Errorf("認証エラーを返すはず: %v (invalidate=%d)", err, provider.invalidated)
+	}
+}
+
+// rotatingProvider Invalidateのたびに次のパスワードを返すテスト用CredentialProvider
+type rotatingProvider struct {
+	passwords   []string
+	invalidated int
+}
+
+func (p *rotatingProvider) Credentials(context.Context, CredentialRequest) (Credentials, error) {
+	return Credentials{Password: p.passwords[min(p.invalidated, len(p.passwords)-1)]}, nil
+}
+
+func (p *rotatingProvider) Invalidate() { p.invalidated++ }
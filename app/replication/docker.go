@@ -0,0 +1,35 @@
+package replication
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// dockerExecTimeout docker exec 1回あたりの上限時間（ctxの期限の方が短ければそちらが優先される）
+const dockerExecTimeout = 10 * time.Second
+
+// psqlOnPrimary docker exec経由でプライマリのpsqlを実行（タプルのみ出力）
+func (c *Cluster) psqlOnPrimary(ctx context.Context, query string) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, dockerExecTimeout)
+	defer cancel()
+
+	cfg := c.config()
+	// #nosec G204 -- コンテナ名とDB名は設定値のみを使用
+	cmd := exec.CommandContext(ctx, "docker", "exec", cfg.PrimaryContainer,
+		"psql", "-U", cfg.User, "-d", cfg.DBName, "-t", "-c", query)
+	return cmd.CombinedOutput()
+}
+
+// testPrimaryConnectionDocker docker exec経由でプライマリをテスト
+func (c *Cluster) testPrimaryConnectionDocker(ctx context.Context) error {
+	output, err := c.psqlOnPrimary(ctx, "SELECT version();")
+	if err != nil {
+		ne := c.primaryError("接続確認", fmt.Errorf("%v: %s", err, strings.TrimSpace(string(output)))).(*NodeError)
+		ne.Kind = ErrPrimaryUnavailable
+		return ne
+	}
+	return nil
+}
@@ -0,0 +1,449 @@
+package replication
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// DriverName 読み書き分離ドライバーの登録名
+const DriverName = "postgres-rw"
+
+func init() {
+	sql.Register(DriverName, &Driver{})
+}
+
+// Driver 読み書き分離を行うdatabase/sqlドライバー。
+// 接続文字列はプライマリ向けのlibpq形式に standby_host / standby_port を加えたもの。
+// 複数のスタンバイはカンマ区切りで指定する（standby_portが1つの場合は全スタンバイ共通）。
+// route_hints=strip を指定するとルーティングヒントのコメントを取り除いてから送信する（デフォルトは keep）。
+//
+//	sql.Open("postgres-rw", "host=primary port=5432 user=postgres dbname=testdb standby_host=standby1,standby2 standby_port=5432")
+type Driver struct{}
+
+// Open 接続文字列から新しい接続を開く
+func (d *Driver) Open(name string) (driver.Conn, error) {
+	c, err := d.OpenConnector(name)
+	if err != nil {
+		return nil, err
+	}
+	return c.Connect(context.Background())
+}
+
+// OpenConnector 接続文字列からConnectorを作成
+func (d *Driver) OpenConnector(name string) (driver.Connector, error) {
+	opts, err := parseDSN(name)
+	if err != nil {
+		return nil, err
+	}
+
+	standbyHosts, hasHost := opts["standby_host"]
+	standbyPorts, hasPort := opts["standby_port"]
+	routeHints := opts["route_hints"]
+	delete(opts, "standby_host")
+	delete(opts, "standby_port")
+	delete(opts, "route_hints")
+	if routeHints != "" && routeHints != "strip" && routeHints != "keep" {
+		return nil, fmt.Errorf("%s: route_hints には strip または keep を指定してください: %s", DriverName, routeHints)
+	}
+	if !hasHost && !hasPort {
+		return nil, fmt.Errorf("%s: standby_host または standby_port の指定が必要です", DriverName)
+	}
+	if !hasHost {
+		standbyHosts = opts["host"]
+	}
+	hosts := strings.Split(standbyHosts, ",")
+	ports := strings.Split(standbyPorts, ",")
+	if hasPort && len(ports) != 1 && len(ports) != len(hosts) {
+		return nil, fmt.Errorf("%s: standby_host と standby_port の数が一致しません", DriverName)
+	}
+
+	primary, err := pq.NewConnector(formatDSN(opts))
+	if err != nil {
+		return nil, err
+	}
+
+	// standby_* 以外はプライマリと共通の設定を使う
+	var standbys []driver.Connector
+	for i, host := range hosts {
+		standbyOpts := make(map[string]string, len(opts))
+		for k, v := range opts {
+			standbyOpts[k] = v
+		}
+		standbyOpts["host"] = host
+		if hasPort {
+			standbyOpts["port"] = ports[min(i, len(ports)-1)]
+		}
+		standby, err := pq.NewConnector(formatDSN(standbyOpts))
+		if err != nil {
+			return nil, err
+		}
+		standbys = append(standbys, standby)
+	}
+	c := NewConnector(primary, standbys...)
+	c.StripHints = routeHints == "strip"
+	return c, nil
+}
+
+// Connector プライマリとスタンバイの接続を束ね、文の種類に応じて振り分けるdriver.Connector。
+// 文の先頭に /* route=primary */ や /* route=standby max_lag=2s */ のヒントがあればそれに従う
+type Connector struct {
+	// StripHints trueの場合はルーティングヒントのコメントを取り除いてから送信する
+	StripHints bool
+	// OnRoute 文ごとのルーティング結果を受け取る（接続を使用しているgoroutineから呼ばれる）
+	OnRoute func(RouteDecision)
+	// StickyWindow WithSessionで指定したセッションがプライマリで文を実行した後、
+	// そのセッションの読み取りもプライマリへ送る期間（0で無効）
+	StickyWindow time.Duration
+	// StandbyRetryInterval 全てのスタンバイに接続できなかった後、再接続を試みずに
+	// プライマリで読み取る期間（0の場合は5秒）。停止したスタンバイへの接続待ちを読み取りごとに繰り返さない
+	StandbyRetryInterval time.Duration
+
+	primary  driver.Connector
+	standbys []driver.Connector
+	next     atomic.Uint64
+	sticky   stickySessions
+	// standbyDownUntil スタンバイへの再接続を再開する時刻（UnixNano）
+	standbyDownUntil atomic.Int64
+}
+
+// defaultStandbyRetryInterval StandbyRetryIntervalを指定しなかった場合の期間
+const defaultStandbyRetryInterval = 5 * time.Second
+
+// NewConnector プライマリとスタンバイのConnectorから読み書き分離Connectorを作成。
+// スタンバイが複数ある場合、物理接続ごとに順番に割り当てる
+func NewConnector(primary driver.Connector, standbys ...driver.Connector) *Connector {
+	return &Connector{primary: primary, standbys: standbys}
+}
+
+// connectStandby 次のスタンバイへ接続する。接続できない場合は他のスタンバイを試す。
+// 全て失敗した場合はStandbyRetryIntervalの間、接続を試みずにエラーを返す
+func (c *Connector) connectStandby(ctx context.Context) (driver.Conn, error) {
+	if len(c.standbys) == 0 {
+		return nil, errors.New("スタンバイが設定されていません")
+	}
+	if until := c.standbyDownUntil.Load(); until != 0 && time.Now().UnixNano() < until {
+		return nil, fmt.Errorf("%w: 接続に失敗したため %s まで再接続を停止中",
+			ErrStandbyUnavailable, time.Unix(0, until).Format(time.TimeOnly))
+	}
+	start := c.next.Add(1) - 1
+	var errs []error
+	for i := range c.standbys {
+		conn, err := c.standbys[(start+uint64(i))%uint64(len(c.standbys))].Connect(ctx)
+		if err == nil {
+			return conn, nil
+		}
+		errs = append(errs, err)
+	}
+	if ctx.Err() == nil {
+		// 呼び出し元のキャンセルではスタンバイの停止と判断しない
+		interval := c.StandbyRetryInterval
+		if interval <= 0 {
+			interval = defaultStandbyRetryInterval
+		}
+		c.standbyDownUntil.Store(time.Now().Add(interval).UnixNano())
+	}
+	return nil, errors.Join(errs...)
+}
+
+// NewConnectorFromConfig 接続設定から読み書き分離Connectorを作成。
+// 各ノードへの接続はConfig.Credentialsから認証情報を取得して行う
+func NewConnectorFromConfig(cfg Config) (*Connector, error) {
+	var standbys []driver.Connector
+	for _, sc := range cfg.Standbys {
+		standbys = append(standbys, NewCredentialConnector(cfg, sc.Endpoint))
+	}
+	c := NewConnector(NewCredentialConnector(cfg, cfg.Primary), standbys...)
+	c.StripHints = cfg.StripRouteHints
+	c.StickyWindow = cfg.StickyPrimaryWindow
+	return c, nil
+}
+
+// OpenDB 読み書き分離を行う*sql.DBを作成
+func OpenDB(cfg Config) (*sql.DB, error) {
+	c, err := NewConnectorFromConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+	db := sql.OpenDB(c)
+	if cfg.MaxOpenConns > 0 {
+		db.SetMaxOpenConns(cfg.MaxOpenConns)
+	}
+	if cfg.MaxIdleConns > 0 {
+		db.SetMaxIdleConns(cfg.MaxIdleConns)
+	}
+	if cfg.ConnMaxLifetime > 0 {
+		db.SetConnMaxLifetime(cfg.ConnMaxLifetime)
+	}
+	return db, nil
+}
+
+// Connect プライマリへ接続した分離接続を返す。スタンバイへは最初の読み取り時に接続する
+func (c *Connector) Connect(ctx context.Context) (driver.Conn, error) {
+	primary, err := c.primary.Connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &splitConn{connector: c, primary: primary}, nil
+}
+
+// Driver 登録済みの読み書き分離ドライバーを返す
+func (c *Connector) Driver() driver.Driver {
+	return &Driver{}
+}
+
+// splitConn プライマリとスタンバイの物理接続の組
+type splitConn struct {
+	connector *Connector
+	primary   driver.Conn
+	standby   driver.Conn
+	// tx 実行中のトランザクションが使用している物理接続
+	tx driver.Conn
+}
+
+var (
+	_ driver.Conn               = (*splitConn)(nil)
+	_ driver.ConnPrepareContext = (*splitConn)(nil)
+	_ driver.ConnBeginTx        = (*splitConn)(nil)
+	_ driver.QueryerContext     = (*splitConn)(nil)
+	_ driver.ExecerContext      = (*splitConn)(nil)
+	_ driver.Pinger             = (*splitConn)(nil)
+	_ driver.SessionResetter    = (*splitConn)(nil)
+	_ driver.Validator          = (*splitConn)(nil)
+)
+
+// standbyConn スタンバイの物理接続を返す。接続できない場合はプライマリを使う
+func (sc *splitConn) standbyConn(ctx context.Context) driver.Conn {
+	if sc.standby == nil {
+		conn, err := sc.connector.connectStandby(ctx)
+		if err != nil {
+			return sc.primary
+		}
+		sc.standby = conn
+	}
+	return sc.standby
+}
+
+// route クエリの送信先の物理接続と、送信するクエリを選ぶ。トランザクション中はその接続に固定する
+func (sc *splitConn) route(ctx context.Context, query string) (driver.Conn, string) {
+	d := RouteQuery(query, sc.connector.StripHints)
+	conn := sc.primary
+
+	switch {
+	case sc.tx != nil:
+		conn, d.Source = sc.tx, DecisionTransaction
+		d.Route = RoutePrimary
+		if conn != sc.primary {
+			d.Route = RouteStandby
+		}
+	case d.Route == RouteStandby && d.Source != DecisionHint && sc.stickyActive(ctx, &d):
+		// 書き込み直後のセッションはプライマリで読み取る
+	case d.Route == RouteStandby:
+		conn = sc.standbyConn(ctx)
+		if conn == sc.primary {
+			d.Route, d.Reason = RoutePrimary, ErrStandbyUnavailable.Error()
+		} else if d.Hint != nil && d.Hint.MaxLag > 0 {
+			lag, err := replayLagOn(ctx, conn)
+			switch {
+			case err != nil:
+				conn, d.Route, d.Reason = sc.primary, RoutePrimary, fmt.Sprintf("再生遅延取得エラー: %v", err)
+			case lag > d.Hint.MaxLag:
+				conn, d.Route = sc.primary, RoutePrimary
+				d.Reason = fmt.Sprintf("再生遅延 %s が許容値 %s を超過", lag, d.Hint.MaxLag)
+			}
+		}
+	}
+
+	// 書き込み（プライマリ上のトランザクションを含む）を実行したセッションを固定する
+	if conn == sc.primary && d.Reason == "" && (d.Source == DecisionClassified || d.Source == DecisionTransaction) {
+		sc.connector.sticky.mark(ctx, sc.connector.StickyWindow)
+	}
+	if sc.connector.OnRoute != nil {
+		sc.connector.OnRoute(d)
+	}
+	return conn, d.Query
+}
+
+// stickyActive セッションがプライマリへ固定されていればdを更新してtrueを返す
+func (sc *splitConn) stickyActive(ctx context.Context, d *RouteDecision) bool {
+	key, ok := sc.connector.sticky.active(ctx)
+	if !ok {
+		return false
+	}
+	d.Route, d.Source = RoutePrimary, DecisionSticky
+	d.Reason = fmt.Sprintf("セッション %s は書き込み直後のためプライマリに固定", key)
+	return true
+}
+
+// queryRowOn 物理接続でクエリを実行し、1行目の値を返す
+func queryRowOn(ctx context.Context, conn driver.Conn, query string) ([]driver.Value, error) {
+	q, ok := conn.(driver.QueryerContext)
+	if !ok {
+		return nil, errors.New("ドライバーがクエリの直接実行に対応していません")
+	}
+	rows, err := q.QueryContext(ctx, query, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rows.Close() }()
+
+	dest := make([]driver.Value, len(rows.Columns()))
+	if err := rows.Next(dest); err != nil {
+		return nil, err
+	}
+	// []byteはCloseの後に再利用される可能性があるためコピーする
+	for i, v := range dest {
+		if b, ok := v.([]byte); ok {
+			dest[i] = append([]byte(nil), b...)
+		}
+	}
+	return dest, nil
+}
+
+// replayLagOn スタンバイの物理接続で再生遅延を計測する
+func replayLagOn(ctx context.Context, conn driver.Conn) (time.Duration, error) {
+	dest, err := queryRowOn(ctx, conn, standbyReplayLagQuery)
+	if err != nil {
+		return 0, err
+	}
+	var seconds float64
+	switch v := dest[0].(type) {
+	case float64:
+		seconds = v
+	case int64:
+		seconds = float64(v)
+	case []byte:
+		if seconds, err = strconv.ParseFloat(string(v), 64); err != nil {
+			return 0, err
+		}
+	default:
+		return 0, fmt.Errorf("再生遅延の型が不正です: %T", v)
+	}
+	return time.Duration(seconds * float64(time.Second)), nil
+}
+
+// Prepare 文を準備する
+func (sc *splitConn) Prepare(query string) (driver.Stmt, error) {
+	return sc.PrepareContext(context.Background(), query)
+}
+
+// PrepareContext 文の種類に応じたノードで文を準備する
+func (sc *splitConn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	conn, query := sc.route(ctx, query)
+	if p, ok := conn.(driver.ConnPrepareContext); ok {
+		return p.PrepareContext(ctx, query)
+	}
+	return conn.Prepare(query)
+}
+
+// QueryContext 文の種類に応じたノードでクエリを実行
+func (sc *splitConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	conn, query := sc.route(ctx, query)
+	if q, ok := conn.(driver.QueryerContext); ok {
+		return q.QueryContext(ctx, query, args)
+	}
+	return nil, driver.ErrSkip
+}
+
+// ExecContext 文の種類に応じたノードで文を実行
+func (sc *splitConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	conn, query := sc.route(ctx, query)
+	if e, ok := conn.(driver.ExecerContext); ok {
+		return e.ExecContext(ctx, query, args)
+	}
+	return nil, driver.ErrSkip
+}
+
+// Begin トランザクションを開始
+func (sc *splitConn) Begin() (driver.Tx, error) {
+	return sc.BeginTx(context.Background(), driver.TxOptions{})
+}
+
+// BeginTx トランザクションを開始。読み取り専用トランザクションはスタンバイ、それ以外はプライマリで実行する
+func (sc *splitConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	conn := sc.primary
+	if opts.ReadOnly {
+		conn = sc.standbyConn(ctx)
+	}
+
+	var tx driver.Tx
+	var err error
+	if b, ok := conn.(driver.ConnBeginTx); ok {
+		tx, err = b.BeginTx(ctx, opts)
+	} else {
+		if opts.ReadOnly || opts.Isolation != driver.IsolationLevel(sql.LevelDefault) {
+			return nil, errors.New("ドライバーがトランザクションオプションに対応していません")
+		}
+		tx, err = conn.Begin() //nolint:staticcheck // ConnBeginTx非対応ドライバー向けのフォールバック
+	}
+	if err != nil {
+		return nil, err
+	}
+	sc.tx = conn
+	return &splitTx{conn: sc, tx: tx}, nil
+}
+
+// Ping プライマリへの接続を確認
+func (sc *splitConn) Ping(ctx context.Context) error {
+	if p, ok := sc.primary.(driver.Pinger); ok {
+		return p.Ping(ctx)
+	}
+	return nil
+}
+
+// ResetSession 接続をプールへ戻す前に各物理接続の状態を確認
+func (sc *splitConn) ResetSession(ctx context.Context) error {
+	for _, conn := range []driver.Conn{sc.primary, sc.standby} {
+		if r, ok := conn.(driver.SessionResetter); ok {
+			if err := r.ResetSession(ctx); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// IsValid 物理接続が再利用可能か
+func (sc *splitConn) IsValid() bool {
+	for _, conn := range []driver.Conn{sc.primary, sc.standby} {
+		if v, ok := conn.(driver.Validator); ok && !v.IsValid() {
+			return false
+		}
+	}
+	return true
+}
+
+// Close 両方の物理接続を閉じる
+func (sc *splitConn) Close() error {
+	err := sc.primary.Close()
+	if sc.standby != nil {
+		err = errors.Join(err, sc.standby.Close())
+	}
+	return err
+}
+
+// splitTx 終了時に接続の固定を解除するトランザクション
+type splitTx struct {
+	conn *splitConn
+	tx   driver.Tx
+}
+
+// Commit コミットして接続の固定を解除
+func (t *splitTx) Commit() error {
+	t.conn.tx = nil
+	return t.tx.Commit()
+}
+
+// Rollback ロールバックして接続の固定を解除
+func (t *splitTx) Rollback() error {
+	t.conn.tx = nil
+	return t.tx.Rollback()
+}
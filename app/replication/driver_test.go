@@ -0,0 +1,240 @@
+package replication
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// TestClassifyQuery SQL文の振り分け判定テスト
+func TestClassifyQuery(t *testing.T) {
+	cases := []struct {
+		query string
+		want  Route
+	}{
+		{"SELECT id, data FROM test_replication", RouteStandby},
+		{"  select count(*) from test_replication;", RouteStandby},
+		{"/* コメント */ SELECT 1", RouteStandby},
+		{"WITH t AS (SELECT 1) SELECT * FROM t", RouteStandby},
+		{"SHOW transaction_read_only", RouteStandby},
+		{"SELECT 'INSERT INTO x' AS text", RouteStandby},
+		{"SELECT $$DELETE$$", RouteStandby},
+		{"INSERT INTO test_replication (data) VALUES ($1)", RoutePrimary},
+		{"UPDATE test_replication SET data = 'x'", RoutePrimary},
+		{"WITH d AS (DELETE FROM t RETURNING *) SELECT * FROM d", RoutePrimary},
+		{"SELECT * FROM test_replication FOR UPDATE", RoutePrimary},
+		{"SELECT * INTO copy_table FROM test_replication", RoutePrimary},
+		{"SELECT nextval('test_replication_id_seq')", RoutePrimary},
+		{"SELECT pg_current_wal_lsn()", RoutePrimary},
+		{"EXPLAIN ANALYZE SELECT 1", RoutePrimary},
+		{"SELECT 1; DELETE FROM t", RoutePrimary},
+		{"-- SELECT\nDELETE FROM t", RoutePrimary},
+		{"", RoutePrimary},
+	}
+
+	for _, tc := range cases {
+		if got := ClassifyQuery(tc.query); got != tc.want {
+			t.Errorf("ClassifyQuery(%q) = %s, want %s", tc.query, got, tc.want)
+		}
+	}
+}
+
+// TestParseDSN 接続文字列のパーステスト
+func TestParseDSN(t *testing.T) {
+	opts, err := parseDSN(`host=db1 port=5432 password='p a\'ss' standby_host=db2`)
+	if err != nil {
+		t.Fatalf("パースエラー: %v", err)
+	}
+	if opts["host"] != "db1" || opts["password"] != "p a'ss" || opts["standby_host"] != "db2" {
+		t.Fatalf("パース結果が不正: %v", opts)
+	}
+
+	again, err := parseDSN(formatDSN(opts))
+	if err != nil || again["password"] != "p a'ss" {
+		t.Fatalf("再パース結果が不正: %v (%v)", again, err)
+	}
+
+	if _, err := parseDSN("host"); err == nil {
+		t.Fatal("不正な接続文字列でエラーにならない")
+	}
+}
+
+// TestConnectorRouting 読み書き分離接続の振り分けテスト
+func TestConnectorRouting(t *testing.T) {
+	var calls []string
+	primary := &fakeConnector{name: "primary", calls: &calls}
+	standby := &fakeConnector{name: "standby", calls: &calls}
+	db := sql.OpenDB(NewConnector(primary, standby))
+	defer func() { _ = db.Close() }()
+	db.SetMaxOpenConns(1)
+
+	expect := func(want string) {
+		t.Helper()
+		if len(calls) == 0 || calls[len(calls)-1] != want {
+			t.Fatalf("送信先が不正: %v, want %s", calls, want)
+		}
+	}
+
+	rows, err := db.Query("SELECT 1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = rows.Close()
+	expect("standby:query")
+
+	if _, err := db.Exec("INSERT INTO t VALUES (1)"); err != nil {
+		t.Fatal(err)
+	}
+	expect("primary:exec")
+
+	stmt, err := db.Prepare("SELECT 2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect("standby:prepare")
+	_ = stmt.Close()
+
+	tx, err := db.BeginTx(context.Background(), &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect("standby:begin")
+	rows, err = tx.Query("SELECT 3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = rows.Close()
+	expect("standby:query")
+	_ = tx.Commit()
+
+	tx, err = db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect("primary:begin")
+	rows, err = tx.Query("SELECT 4")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = rows.Close()
+	expect("primary:query")
+	_ = tx.Rollback()
+
+	// トランザクション終了後は再び振り分けられる
+	rows, err = db.Query("SELECT 5")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = rows.Close()
+	expect("standby:query")
+}
+
+// TestConnectorStandbyRetryInterval 接続できなかったスタンバイへの再接続を一定期間行わないテスト
+func TestConnectorStandbyRetryInterval(t *testing.T) {
+	var calls []string
+	standby := &downConnector{}
+	c := NewConnector(&fakeConnector{name: "primary", calls: &calls}, standby)
+	c.StandbyRetryInterval = 50 * time.Millisecond
+	db := sql.OpenDB(c)
+	defer func() { _ = db.Close() }()
+	db.SetMaxOpenConns(1)
+
+	query := func() {
+		t.Helper()
+		rows, err := db.Query("SELECT 1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		_ = rows.Close()
+	}
+	for i := 0; i < 3; i++ {
+		query()
+	}
+	if n := standby.dials.Load(); n != 1 {
+		t.Fatalf("停止中のスタンバイへの接続は1回のはず: %d回", n)
+	}
+	if len(calls) != 3 || calls[2] != "primary:query" {
+		t.Fatalf("プライマリで読み取るはず: %v", calls)
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	query()
+	if n := standby.dials.Load(); n != 2 {
+		t.Fatalf("期間経過後は再接続を試みるはず: %d回", n)
+	}
+}
+
+// downConnector 常に接続に失敗するテスト用Connector
+type downConnector struct{ dials atomic.Int32 }
+
+func (d *downConnector) Connect(context.Context) (driver.Conn, error) {
+	d.dials.Add(1)
+	return nil, errors.New("connection refused")
+}
+
+func (d *downConnector) Driver() driver.Driver { return nil }
+
+// fakeConnector 呼び出し先を記録するテスト用Connector
+type fakeConnector struct {
+	name  string
+	calls *[]string
+}
+
+func (f *fakeConnector) Connect(context.Context) (driver.Conn, error) {
+	return &fakeConn{f}, nil
+}
+
+func (f *fakeConnector) Driver() driver.Driver { return nil }
+
+type fakeConn struct{ c *fakeConnector }
+
+func (f *fakeConn) record(op string) { *f.c.calls = append(*f.c.calls, f.c.name+":"+op) }
+
+func (f *fakeConn) Prepare(string) (driver.Stmt, error) {
+	f.record("prepare")
+	return fakeStmt{}, nil
+}
+
+func (f *fakeConn) Close() error { return nil }
+
+func (f *fakeConn) Begin() (driver.Tx, error) {
+	return f.BeginTx(context.Background(), driver.TxOptions{})
+}
+
+func (f *fakeConn) BeginTx(context.Context, driver.TxOptions) (driver.Tx, error) {
+	f.record("begin")
+	return fakeTx{}, nil
+}
+
+func (f *fakeConn) QueryContext(context.Context, string, []driver.NamedValue) (driver.Rows, error) {
+	f.record("query")
+	return fakeRows{}, nil
+}
+
+func (f *fakeConn) ExecContext(context.Context, string, []driver.NamedValue) (driver.Result, error) {
+	f.record("exec")
+	return driver.RowsAffected(1), nil
+}
+
+type fakeStmt struct{}
+
+func (fakeStmt) Close() error                               { return nil }
+func (fakeStmt) NumInput() int                              { return -1 }
+func (fakeStmt) Exec([]driver.Value) (driver.Result, error) { return driver.RowsAffected(0), nil }
+func (fakeStmt) Query([]driver.Value) (driver.Rows, error)  { return fakeRows{}, nil }
+
+type fakeTx struct{}
+
+func (fakeTx) Commit() error   { return nil }
+func (fakeTx) Rollback() error { return nil }
+
+type fakeRows struct{}
+
+func (fakeRows) Columns() []string         { return []string{"v"} }
+func (fakeRows) Close() error              { return nil }
+func (fakeRows) Next([]driver.Value) error { return io.EOF }
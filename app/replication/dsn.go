@@ -0,0 +1,109 @@
+package replication
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/lib/pq"
+)
+
+// parseDSN libpqのkey=value形式またはURL形式の接続文字列をパース
+func parseDSN(dsn string) (map[string]string, error) {
+	if strings.HasPrefix(dsn, "postgres://") || strings.HasPrefix(dsn, "postgresql://") {
+		converted, err := pq.ParseURL(dsn)
+		if err != nil {
+			return nil, fmt.Errorf("接続URLパースエラー: %v", err)
+		}
+		dsn = converted
+	}
+
+	opts := make(map[string]string)
+	r := []rune(dsn)
+	i := 0
+	skipSpace := func() {
+		for i < len(r) && unicode.IsSpace(r[i]) {
+			i++
+		}
+	}
+
+	for {
+		skipSpace()
+		if i >= len(r) {
+			return opts, nil
+		}
+
+		start := i
+		for i < len(r) && r[i] != '=' && !unicode.IsSpace(r[i]) {
+			i++
+		}
+		key := string(r[start:i])
+		skipSpace()
+		if i >= len(r) || r[i] != '=' {
+			return nil, fmt.Errorf("接続文字列パースエラー: キー%qの後に'='がありません", key)
+		}
+		i++
+		skipSpace()
+
+		var value strings.Builder
+		if i < len(r) && r[i] == '\'' {
+			i++
+			closed := false
+			for i < len(r) {
+				switch r[i] {
+				case '\\':
+					i++
+					if i < len(r) {
+						value.WriteRune(r[i])
+					}
+				case '\'':
+					closed = true
+				default:
+					value.WriteRune(r[i])
+				}
+				i++
+				if closed {
+					break
+				}
+			}
+			if !closed {
+				return nil, fmt.Errorf("接続文字列パースエラー: キー%qの値の引用符が閉じていません", key)
+			}
+		} else {
+			for i < len(r) && !unicode.IsSpace(r[i]) {
+				if r[i] == '\\' && i+1 < len(r) {
+					i++
+				}
+				value.WriteRune(r[i])
+				i++
+			}
+		}
+		opts[key] = value.String()
+	}
+}
+
+// formatDSN パース済みの接続パラメータをkey=value形式に戻す
+func formatDSN(opts map[string]string) string {
+	keys := make([]string, 0, len(opts))
+	for k := range opts {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	parts := make([]string, 0, len(keys))
+	for _, k := range keys {
+		parts = append(parts, k+"="+quoteDSNValue(opts[k]))
+	}
+	return strings.Join(parts, " ")
+}
+
+// quoteDSNValue 必要に応じて値を引用符で囲みエスケープする
+func quoteDSNValue(v string) string {
+	if v != "" && !strings.ContainsAny(v, " \t\n\\'") {
+		return v
+	}
+	v = strings.ReplaceAll(v, `\`, `\\`)
+	v = strings.ReplaceAll(v, `'`, `\'`)
+	return "'" + v + "'"
+}
@@ -0,0 +1,167 @@
+package replication
+
+import (
+	"database/sql/driver"
+	"errors"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// レプリケーション関連のエラー種別。NodeErrorに包まれて返されるため、errors.Isで判定する
+var (
+	// ErrStandbyUnavailable スタンバイに接続できない、または利用可能なスタンバイがない
+	ErrStandbyUnavailable = errors.New("スタンバイを利用できません")
+	// ErrPrimaryUnavailable プライマリに接続できない
+	ErrPrimaryUnavailable = errors.New("プライマリを利用できません")
+	// ErrLagExceeded スタンバイの再生遅延が許容値を超えている
+	ErrLagExceeded = errors.New("スタンバイの再生遅延が許容値を超えています")
+	// ErrReadOnlyTransaction 読み取り専用のノードまたはトランザクションで書き込もうとした（SQLSTATE 25006）
+	ErrReadOnlyTransaction = errors.New("読み取り専用トランザクションでは実行できません")
+	// ErrRecoveryConflict スタンバイでのWAL再生と競合してクエリがキャンセルされた
+	ErrRecoveryConflict = errors.New("リカバリとの競合によりクエリがキャンセルされました")
+)
+
+// NodeError 特定のノードで発生したエラー。errors.Asで取り出して詳細を参照する
+type NodeError struct {
+	// Op 失敗した操作（"データ読み取り" など）
+	Op string
+	// Route エラーが発生したノードの種別
+	Route Route
+	// Node ノード名（プライマリの場合は "primary"）
+	Node     string
+	Endpoint Endpoint
+	// Kind エラー種別（ErrStandbyUnavailable など）。分類できない場合はnil
+	Kind error
+	// SQLState サーバーが返したSQLSTATE（サーバーからのエラーでない場合は空）
+	SQLState string
+	// Lag 計測した再生遅延（時間）。計測していない場合は0
+	Lag time.Duration
+	// LagBytes 計測した再生遅延（バイト）。計測していない場合は0
+	LagBytes int64
+	// Err 元のエラー
+	Err error
+}
+
+// Error エラーメッセージ
+func (e *NodeError) Error() string {
+	var b strings.Builder
+	b.WriteString(e.Node)
+	if e.Op != "" {
+		b.WriteString(": " + e.Op + "エラー")
+	}
+	switch {
+	case e.Err != nil:
+		b.WriteString(": " + e.Err.Error())
+	case e.Kind != nil:
+		b.WriteString(": " + e.Kind.Error())
+	}
+	if e.SQLState != "" {
+		b.WriteString(" (SQLSTATE " + e.SQLState + ")")
+	}
+	return b.String()
+}
+
+// Unwrap エラー種別と元のエラーの両方をerrors.Is/errors.Asの対象にする
+func (e *NodeError) Unwrap() []error {
+	var errs []error
+	if e.Kind != nil {
+		errs = append(errs, e.Kind)
+	}
+	if e.Err != nil {
+		errs = append(errs, e.Err)
+	}
+	return errs
+}
+
+// SQLState エラーに含まれるSQLSTATEを返す（含まれない場合は空）
+func SQLState(err error) string {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		return string(pqErr.Code)
+	}
+	return ""
+}
+
+// newNodeError errを分類してNodeErrorで包む。errがnilの場合はnilを返す
+func newNodeError(op string, route Route, name string, endpoint Endpoint, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &NodeError{
+		Op:       op,
+		Route:    route,
+		Node:     name,
+		Endpoint: endpoint,
+		Kind:     classifyError(route, err),
+		SQLState: SQLState(err),
+		Err:      err,
+	}
+}
+
+// primaryError プライマリで発生したエラーをNodeErrorで包む
+func (c *Cluster) primaryError(op string, err error) error {
+	return newNodeError(op, RoutePrimary, RoutePrimary.String(), c.config().Primary, err)
+}
+
+// nodeError スタンバイで発生したエラーをNodeErrorで包む
+func (n *Node) nodeError(op string, err error) error {
+	return newNodeError(op, RouteStandby, n.Name, n.Endpoint, err)
+}
+
+// readError 読み取りを処理したノードで発生したエラーをNodeErrorで包む
+func readError(op string, info ReadInfo, err error) error {
+	if err == nil {
+		return nil
+	}
+	ne := newNodeError(op, info.Route, info.Name, info.Node, err).(*NodeError)
+	ne.Lag, ne.LagBytes = info.Lag, info.LagBytes
+	return ne
+}
+
+// classifyError エラー種別を判定する。該当しない場合はnil
+func classifyError(route Route, err error) error {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		switch {
+		case pqErr.Code == "25006": // read_only_sql_transaction
+			return ErrReadOnlyTransaction
+		case isRecoveryConflict(pqErr):
+			return ErrRecoveryConflict
+		case pqErr.Code.Class() == "08", // connection_exception
+			pqErr.Code == "57P01", // admin_shutdown
+			pqErr.Code == "57P02", // crash_shutdown
+			pqErr.Code == "57P03": // cannot_connect_now
+			return unavailable(route)
+		}
+		return nil
+	}
+
+	var netErr net.Error
+	if errors.Is(err, driver.ErrBadConn) || errors.As(err, &netErr) {
+		return unavailable(route)
+	}
+	return nil
+}
+
+// isRecoveryConflict スタンバイでのリカバリ競合によるキャンセルか判定する
+func isRecoveryConflict(pqErr *pq.Error) bool {
+	switch pqErr.Code {
+	case "40001", "40P01": // serialization_failure / deadlock_detected
+		return strings.Contains(pqErr.Message, "conflict with recovery") ||
+			strings.Contains(pqErr.Detail, "recovery")
+	case "57P04": // database_dropped
+		return true
+	}
+	return false
+}
+
+// unavailable ノード種別に応じた接続不可エラー
+func unavailable(route Route) error {
+	if route == RoutePrimary {
+		return ErrPrimaryUnavailable
+	}
+	return ErrStandbyUnavailable
+}
@@ -0,0 +1,70 @@
+package replication
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/lib/pq"
+)
+
+// TestClassifyError SQLSTATEや接続エラーからエラー種別を判定するテスト
+func TestClassifyError(t *testing.T) {
+	tests := []struct {
+		route Route
+		err   error
+		want  error
+	}{
+		{RoutePrimary, &pq.Error{Code: "25006", Message: "cannot execute INSERT in a read-only transaction"}, ErrReadOnlyTransaction},
+		{RouteStandby, &pq.Error{Code: "40001", Message: "canceling statement due to conflict with recovery"}, ErrRecoveryConflict},
+		{RouteStandby, &pq.Error{Code: "40001", Message: "could not serialize access due to concurrent update"}, nil},
+		{RouteStandby, &pq.Error{Code: "57P03", Message: "the database system is starting up"}, ErrStandbyUnavailable},
+		{RoutePrimary, &pq.Error{Code: "08006", Message: "connection failure"}, ErrPrimaryUnavailable},
+		{RouteStandby, fmt.Errorf("query: %w", driver.ErrBadConn), ErrStandbyUnavailable},
+		{RouteStandby, &pq.Error{Code: "42P01", Message: "relation does not exist"}, nil},
+	}
+
+	for _, tt := range tests {
+		if got := classifyError(tt.route, tt.err); got != tt.want {
+			t.Errorf("classifyError(%s, %v) = %v, want %v", tt.route, tt.err, got, tt.want)
+		}
+	}
+}
+
+// TestNodeErrorIsAs NodeErrorがerrors.Is/errors.Asで判定できるテスト
+func TestNodeErrorIsAs(t *testing.T) {
+	cause := &pq.Error{Code: "40001", Message: "canceling statement due to conflict with recovery"}
+	err := fmt.Errorf("集計失敗: %w", newNodeError("データ読み取り", RouteStandby, "standby1", Endpoint{Host: "db2", Port: 5433}, cause))
+
+	if !errors.Is(err, ErrRecoveryConflict) {
+		t.Fatalf("ErrRecoveryConflictに該当するはず: %v", err)
+	}
+	var ne *NodeError
+	if !errors.As(err, &ne) || ne.Node != "standby1" || ne.SQLState != "40001" || ne.Route != RouteStandby {
+		t.Fatalf("NodeErrorの内容が不正: %+v", ne)
+	}
+	var pqErr *pq.Error
+	if !errors.As(err, &pqErr) {
+		t.Fatal("元のpq.Errorを取り出せるはず")
+	}
+}
+
+// TestStandbyOnlyUnavailable StandbyOnly指定時はスタンバイがなくてもプライマリで読み取らずエラーになるテスト
+func TestStandbyOnlyUnavailable(t *testing.T) {
+	node := &Node{Name: "standby1", Weight: 1} // ダウン中
+	c := &Cluster{Standbys: []*Node{node}}
+	c.SetRoutingPolicy(&RoundRobinPolicy{})
+	ctx := context.Background()
+
+	var info ReadInfo
+	if n, err := c.chooseStandby(ctx, &readOptions{}, &info); n != nil || err != nil || info.Reason == "" {
+		t.Fatalf("通常はプライマリで読み取るはず: %v %v %+v", n, err, info)
+	}
+
+	_, err := c.chooseStandby(ctx, &readOptions{standbyOnly: true}, &ReadInfo{})
+	if !errors.Is(err, ErrStandbyUnavailable) {
+		t.Fatalf("ErrStandbyUnavailableを返すはず: %v", err)
+	}
+}
@@ -0,0 +1,276 @@
+package replication
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// HealthCheckConfig バックグラウンドのヘルスチェック設定
+type HealthCheckConfig struct {
+	// Interval チェック間隔（0の場合は5秒）
+	Interval time.Duration
+	// Timeout 1回のチェックのタイムアウト（0の場合は2秒）
+	Timeout time.Duration
+	// MaxLag スタンバイの再生遅延がこれを超えたら異常とみなす（0の場合は判定しない）
+	MaxLag time.Duration
+	// FailThreshold 連続で何回失敗したらダウンとみなすか（0の場合は1）
+	FailThreshold int
+	// RiseThreshold ダウン中のノードが連続で何回成功したら復帰させるか（0の場合は3）
+	RiseThreshold int
+	// OnStateChange ノードの状態が変化したときに呼ばれる（チェック用goroutineから呼ばれる）
+	OnStateChange func(HealthEvent)
+}
+
+// HealthEvent ノードの状態変化
+type HealthEvent struct {
+	// Node ノード名（プライマリの場合は "primary"）
+	Node     string
+	Endpoint Endpoint
+	Route    Route
+	// Healthy 変化後の状態
+	Healthy bool
+	// Err ダウンと判定した原因（復帰時はnil）。NodeErrorに包まれている
+	Err error
+	// Lag 最後に計測したスタンバイの再生遅延
+	Lag time.Duration
+	At  time.Time
+}
+
+// String ログ出力用の表現
+func (e HealthEvent) String() string {
+	if e.Healthy {
+		return fmt.Sprintf("%s (%s) is up", e.Node, e.Endpoint)
+	}
+	return fmt.Sprintf("%s (%s) is down: %v", e.Node, e.Endpoint, e.Err)
+}
+
+// HealthChecker 各ノードを定期的に確認し、異常なスタンバイを読み取り対象から外す
+type HealthChecker struct {
+	cluster *Cluster
+	cfg     HealthCheckConfig
+
+	primaryHealthy bool
+	mu             sync.Mutex
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+	// stops スタンバイごとのチェック用goroutineの停止関数（Reloadでの追加・削除用）
+	stops map[*Node]context.CancelFunc
+}
+
+// probeTarget チェック対象1台分の状態
+type probeTarget struct {
+	name      string
+	endpoint  Endpoint
+	route     Route
+	db        *sql.DB
+	node      *Node // スタンバイの場合のみ
+	fails     int
+	successes int
+}
+
+// StartHealthCheck バックグラウンドのヘルスチェックを開始する。Cluster.Closeで停止する
+func (c *Cluster) StartHealthCheck(cfg HealthCheckConfig) *HealthChecker {
+	if cfg.Interval <= 0 {
+		cfg.Interval = 5 * time.Second
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = 2 * time.Second
+	}
+	if cfg.FailThreshold <= 0 {
+		cfg.FailThreshold = 1
+	}
+	if cfg.RiseThreshold <= 0 {
+		cfg.RiseThreshold = 3
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	h := &HealthChecker{
+		cluster: c, cfg: cfg, primaryHealthy: true,
+		ctx: ctx, cancel: cancel, stops: make(map[*Node]context.CancelFunc),
+	}
+
+	h.wg.Add(1)
+	go h.run(ctx, &probeTarget{name: RoutePrimary.String(), endpoint: c.config().Primary, route: RoutePrimary, db: c.Primary})
+
+	// Reloadと同時に開始した場合に備えて、登録してから現在のスタンバイを反映する
+	c.mu.Lock()
+	c.checkers = append(c.checkers, h)
+	c.mu.Unlock()
+	h.syncNodes(c.StandbyNodes())
+	return h
+}
+
+// syncNodes nodesに含まれるスタンバイのチェックを開始し、含まれないスタンバイのチェックを停止する
+func (h *HealthChecker) syncNodes(nodes []*Node) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.ctx.Err() != nil {
+		return
+	}
+
+	current := make(map[*Node]bool, len(nodes))
+	for _, n := range nodes {
+		current[n] = true
+		if _, ok := h.stops[n]; ok {
+			continue
+		}
+		ctx, cancel := context.WithCancel(h.ctx)
+		h.stops[n] = cancel
+		h.wg.Add(1)
+		go h.run(ctx, &probeTarget{name: n.Name, endpoint: n.Endpoint, route: RouteStandby, db: n.DB, node: n})
+	}
+	for n, cancel := range h.stops {
+		if !current[n] {
+			cancel()
+			delete(h.stops, n)
+		}
+	}
+}
+
+// Stop ヘルスチェックを停止し、全てのチェック用goroutineの終了を待つ。Clusterからも登録を外す
+func (h *HealthChecker) Stop() {
+	c := h.cluster
+	c.mu.Lock()
+	for i, hc := range c.checkers {
+		if hc == h {
+			c.checkers = append(c.checkers[:i:i], c.checkers[i+1:]...)
+			break
+		}
+	}
+	c.mu.Unlock()
+
+	// syncNodesがStop後に新しいgoroutineを開始しないよう、ロック中に停止する
+	h.mu.Lock()
+	h.cancel()
+	h.mu.Unlock()
+	h.wg.Wait()
+}
+
+// PrimaryHealthy 最後のチェックでプライマリが正常だったか
+func (h *HealthChecker) PrimaryHealthy() bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.primaryHealthy
+}
+
+// run 1台分のチェックを定期実行
+func (h *HealthChecker) run(ctx context.Context, t *probeTarget) {
+	defer h.wg.Done()
+	ticker := time.NewTicker(h.cfg.Interval)
+	defer ticker.Stop()
+
+	for {
+		h.check(ctx, t)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// check 1回分のチェックを行い、閾値に達したら状態を切り替える
+func (h *HealthChecker) check(ctx context.Context, t *probeTarget) {
+	probeCtx, cancel := context.WithTimeout(ctx, h.cfg.Timeout)
+	lag, err := h.probe(probeCtx, t)
+	cancel()
+	if ctx.Err() != nil {
+		// 停止中のチェック失敗は状態に反映しない
+		return
+	}
+
+	healthy := h.isHealthy(t)
+	if err != nil {
+		t.fails++
+		t.successes = 0
+		if healthy && t.fails >= h.cfg.FailThreshold {
+			h.setHealthy(t, false, err, lag)
+		}
+		return
+	}
+
+	t.successes++
+	t.fails = 0
+	if !healthy && t.successes >= h.cfg.RiseThreshold {
+		h.setHealthy(t, true, nil, lag)
+	}
+}
+
+// probe SELECT 1、pg_is_in_recovery()、再生遅延を確認する。失敗時はNodeErrorを返す
+func (h *HealthChecker) probe(ctx context.Context, t *probeTarget) (time.Duration, error) {
+	var one int
+	if err := t.db.QueryRowContext(ctx, "SELECT 1").Scan(&one); err != nil {
+		return 0, t.error("接続確認", nil, err)
+	}
+
+	var inRecovery bool
+	if err := t.db.QueryRowContext(ctx, "SELECT pg_is_in_recovery()").Scan(&inRecovery); err != nil {
+		return 0, t.error("サーバー種別確認", nil, err)
+	}
+	if t.route == RoutePrimary {
+		if inRecovery {
+			return 0, t.error("サーバー種別確認", ErrPrimaryUnavailable, errors.New("プライマリがリカバリ中です"))
+		}
+		return 0, nil
+	}
+	if !inRecovery {
+		return 0, t.error("サーバー種別確認", ErrStandbyUnavailable, errors.New("スタンバイがリカバリ中ではありません（昇格済みの可能性）"))
+	}
+
+	lag, err := t.node.ReplayLagContext(ctx)
+	if err != nil {
+		return 0, err
+	}
+	if h.cfg.MaxLag > 0 && lag > h.cfg.MaxLag {
+		ne := t.error("再生遅延確認", ErrLagExceeded, fmt.Errorf("再生遅延 %s が上限 %s を超過", lag, h.cfg.MaxLag)).(*NodeError)
+		ne.Lag = lag
+		return lag, ne
+	}
+	return lag, nil
+}
+
+// error チェック対象で発生したエラーをNodeErrorで包む。kindがnilの場合はerrから判定する
+func (t *probeTarget) error(op string, kind, err error) error {
+	ne := newNodeError(op, t.route, t.name, t.endpoint, err).(*NodeError)
+	if kind != nil {
+		ne.Kind = kind
+	}
+	return ne
+}
+
+// isHealthy 現在の状態
+func (h *HealthChecker) isHealthy(t *probeTarget) bool {
+	if t.node != nil {
+		return t.node.Healthy()
+	}
+	return h.PrimaryHealthy()
+}
+
+// setHealthy 状態を切り替えてコールバックを呼ぶ
+func (h *HealthChecker) setHealthy(t *probeTarget, healthy bool, err error, lag time.Duration) {
+	if t.node != nil {
+		t.node.healthy.Store(healthy)
+	} else {
+		h.mu.Lock()
+		h.primaryHealthy = healthy
+		h.mu.Unlock()
+	}
+
+	if h.cfg.OnStateChange != nil {
+		h.cfg.OnStateChange(HealthEvent{
+			Node:     t.name,
+			Endpoint: t.endpoint,
+			Route:    t.route,
+			Healthy:  healthy,
+			Err:      err,
+			Lag:      lag,
+			At:       time.Now(),
+		})
+	}
+}
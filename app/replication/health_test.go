@@ -0,0 +1,130 @@
+package replication
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// TestHealthCheckEjectAndReadmit ダウン判定で読み取り対象から外れ、連続成功で復帰するテスト
+func TestHealthCheckEjectAndReadmit(t *testing.T) {
+	var down atomic.Bool
+	node := &Node{Name: "standby1", Weight: 1, DB: sql.OpenDB(&probeConnector{down: &down})}
+	node.healthy.Store(true)
+	defer func() { _ = node.DB.Close() }()
+
+	c := &Cluster{Standbys: []*Node{node}}
+	c.SetRoutingPolicy(&RoundRobinPolicy{})
+
+	var events []HealthEvent
+	h := &HealthChecker{cluster: c, primaryHealthy: true, cfg: HealthCheckConfig{
+		Timeout:       time.Second,
+		FailThreshold: 2,
+		RiseThreshold: 2,
+		OnStateChange: func(e HealthEvent) { events = append(events, e) },
+	}}
+	target := &probeTarget{name: node.Name, route: RouteStandby, db: node.DB, node: node}
+	ctx := context.Background()
+
+	down.Store(true)
+	h.check(ctx, target)
+	if !node.Healthy() {
+		t.Fatal("1回目の失敗ではダウンにならないはず")
+	}
+	h.check(ctx, target)
+	if node.Healthy() || len(events) != 1 || events[0].Healthy {
+		t.Fatalf("2回連続の失敗でダウンになるはず: healthy=%v events=%v", node.Healthy(), events)
+	}
+	if c.PickStandby() != nil {
+		t.Fatal("ダウン中のスタンバイが選ばれた")
+	}
+
+	var info ReadInfo
+	if n, _ := c.chooseStandby(ctx, &readOptions{}, &info); n != nil || info.Reason == "" {
+		t.Fatalf("正常なスタンバイがない場合はプライマリで読み取るはず: %v %+v", n, info)
+	}
+
+	down.Store(false)
+	h.check(ctx, target)
+	if node.Healthy() {
+		t.Fatal("1回目の成功では復帰しないはず")
+	}
+	h.check(ctx, target)
+	if !node.Healthy() || len(events) != 2 || !events[1].Healthy {
+		t.Fatalf("2回連続の成功で復帰するはず: healthy=%v events=%v", node.Healthy(), events)
+	}
+	if c.PickStandby() != node {
+		t.Fatal("復帰したスタンバイが選ばれない")
+	}
+}
+
+// TestHealthCheckStop 停止したヘルスチェックがClusterから登録を外れるテスト
+func TestHealthCheckStop(t *testing.T) {
+	var down atomic.Bool
+	c := &Cluster{Primary: sql.OpenDB(&probeConnector{down: &down})}
+	defer c.Close()
+
+	first := c.StartHealthCheck(HealthCheckConfig{Interval: time.Hour})
+	second := c.StartHealthCheck(HealthCheckConfig{Interval: time.Hour})
+	first.Stop()
+	c.mu.Lock()
+	checkers := append([]*HealthChecker(nil), c.checkers...)
+	c.mu.Unlock()
+	if len(checkers) != 1 || checkers[0] != second {
+		t.Fatalf("停止したヘルスチェックが残っている: %d件", len(checkers))
+	}
+}
+
+// probeConnector ヘルスチェックのクエリに固定値を返すテスト用コネクタ。errを指定すると全てのクエリが失敗する
+type probeConnector struct {
+	down *atomic.Bool
+	err  error
+}
+
+func (p *probeConnector) Connect(context.Context) (driver.Conn, error) { return &probeConn{p}, nil }
+func (p *probeConnector) Driver() driver.Driver                        { return nil }
+
+type probeConn struct{ p *probeConnector }
+
+func (c *probeConn) Prepare(string) (driver.Stmt, error) { return nil, errors.New("not supported") }
+func (c *probeConn) Close() error                        { return nil }
+func (c *probeConn) Begin() (driver.Tx, error)           { return nil, errors.New("not supported") }
+
+func (c *probeConn) QueryContext(_ context.Context, query string, _ []driver.NamedValue) (driver.Rows, error) {
+	if c.p.down.Load() {
+		return nil, errors.New("connection refused")
+	}
+	if c.p.err != nil {
+		return nil, c.p.err
+	}
+	switch {
+	case strings.Contains(query, "pg_is_in_recovery"):
+		return &probeRows{value: true}, nil
+	case query == "SELECT 1":
+		return &probeRows{value: int64(1)}, nil
+	default:
+		return &probeRows{value: float64(0)}, nil
+	}
+}
+
+type probeRows struct {
+	value driver.Value
+	done  bool
+}
+
+func (r *probeRows) Columns() []string { return []string{"v"} }
+func (r *probeRows) Close() error      { return nil }
+func (r *probeRows) Next(dest []driver.Value) error {
+	if r.done {
+		return io.EOF
+	}
+	r.done = true
+	dest[0] = r.value
+	return nil
+}
@@ -0,0 +1,179 @@
+package replication
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// HeartbeatTable ハートビートを書き込むテーブル
+const HeartbeatTable = "replication_heartbeat"
+
+// heartbeatCreateQuery ハートビート用テーブルの作成（scripts/setup-primary.sh でも作成する）
+const heartbeatCreateQuery = `CREATE TABLE IF NOT EXISTS ` + HeartbeatTable + ` (
+	id integer PRIMARY KEY,
+	written_at timestamptz NOT NULL
+)`
+
+// heartbeatWriteQuery ハートビートの書き込み（1行を上書きし続ける）
+const heartbeatWriteQuery = `INSERT INTO ` + HeartbeatTable + ` (id, written_at) VALUES (1, now())
+ON CONFLICT (id) DO UPDATE SET written_at = EXCLUDED.written_at`
+
+// heartbeatLagQuery スタンバイに見えている最新のハートビートと、その時刻から現在までの経過時間（秒）
+const heartbeatLagQuery = `SELECT written_at, GREATEST(EXTRACT(EPOCH FROM (now() - written_at)), 0)::float8
+FROM ` + HeartbeatTable + ` WHERE id = 1`
+
+// HeartbeatConfig ハートビートの書き込み設定
+type HeartbeatConfig struct {
+	// Interval 書き込み間隔（0の場合は1秒）。計測できる遅延の分解能になる
+	Interval time.Duration
+	// Timeout 1回の書き込みのタイムアウト（0の場合はInterval）
+	Timeout time.Duration
+	// OnError 書き込みに失敗したときに呼ばれる（書き込み用goroutineから呼ばれる）
+	OnError func(error)
+}
+
+// HeartbeatWriter プライマリのハートビート用テーブルに一定間隔で現在時刻を書き込む
+type HeartbeatWriter struct {
+	cluster *Cluster
+	cfg     HeartbeatConfig
+	created bool
+
+	mu        sync.Mutex
+	lastWrite time.Time
+	err       error
+
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// StartHeartbeat ハートビートの書き込みを開始する。Cluster.Closeで停止する。
+// 書き込み中はGetReplicationStatusがスタンバイから見たハートビートの遅延を返す
+func (c *Cluster) StartHeartbeat(cfg HeartbeatConfig) *HeartbeatWriter {
+	if cfg.Interval <= 0 {
+		cfg.Interval = time.Second
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = cfg.Interval
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	w := &HeartbeatWriter{cluster: c, cfg: cfg, cancel: cancel, done: make(chan struct{})}
+	go w.run(ctx)
+
+	c.mu.Lock()
+	c.heartbeats = append(c.heartbeats, w)
+	c.mu.Unlock()
+	return w
+}
+
+// Stop 書き込みを停止し、Clusterから登録を外す（GetReplicationStatusはpg_stat_replicationの値に戻る）
+func (w *HeartbeatWriter) Stop() {
+	c := w.cluster
+	c.mu.Lock()
+	for i, hw := range c.heartbeats {
+		if hw == w {
+			c.heartbeats = append(c.heartbeats[:i:i], c.heartbeats[i+1:]...)
+			break
+		}
+	}
+	c.mu.Unlock()
+
+	w.cancel()
+	<-w.done
+}
+
+// LastWrite 最後に書き込みに成功した時刻（Goのプロセスの時計）と、直近の書き込みのエラー
+func (w *HeartbeatWriter) LastWrite() (time.Time, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.lastWrite, w.err
+}
+
+// run 定期的に書き込む
+func (w *HeartbeatWriter) run(ctx context.Context) {
+	defer close(w.done)
+	ticker := time.NewTicker(w.cfg.Interval)
+	defer ticker.Stop()
+
+	for {
+		writeCtx, cancel := context.WithTimeout(ctx, w.cfg.Timeout)
+		err := w.write(writeCtx)
+		cancel()
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil && w.cfg.OnError != nil {
+			w.cfg.OnError(err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// write テーブルがなければ作成し、ハートビートを1回書き込む
+func (w *HeartbeatWriter) write(ctx context.Context) error {
+	var err error
+	if !w.created {
+		_, err = w.cluster.Primary.ExecContext(ctx, heartbeatCreateQuery)
+		w.created = err == nil
+	}
+	if err == nil {
+		_, err = w.cluster.Primary.ExecContext(ctx, heartbeatWriteQuery)
+	}
+	err = w.cluster.primaryError("ハートビート書き込み", err)
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.err = err
+	if err == nil {
+		w.lastWrite = time.Now()
+	}
+	return err
+}
+
+// heartbeatRunning このClusterでハートビートを書き込んでいるか
+func (c *Cluster) heartbeatRunning() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.heartbeats) > 0
+}
+
+// HeartbeatLag スタンバイに見えている最新のハートビートから現在までの経過時間。
+// プライマリでのコミットからスタンバイで読めるようになるまでの遅延に、最大で書き込み間隔分を加えた値になる
+func (n *Node) HeartbeatLag() (time.Duration, time.Time, error) {
+	return n.HeartbeatLagContext(context.Background())
+}
+
+// HeartbeatLagContext ctxに従って中断できるHeartbeatLag。ハートビートの書き込みを開始していない場合はエラーを返す
+func (n *Node) HeartbeatLagContext(ctx context.Context) (time.Duration, time.Time, error) {
+	var written time.Time
+	var seconds float64
+	if err := n.DB.QueryRowContext(ctx, heartbeatLagQuery).Scan(&written, &seconds); err != nil {
+		return 0, time.Time{}, n.nodeError("ハートビート取得", err)
+	}
+	return secondsToDuration(seconds), written, nil
+}
+
+// maxHeartbeatLag 正常なスタンバイのハートビートの遅延の最大値。ヘルスチェックで除外されたノードと
+// 読み取りに失敗したノードは除き、1台も読めなかった場合のみエラーを返す。
+// 正常なスタンバイがない場合はokにfalseを返す
+func (c *Cluster) maxHeartbeatLag(ctx context.Context) (lag time.Duration, ok bool, err error) {
+	var errs []error
+	for _, n := range c.HealthyStandbys() {
+		l, _, err := n.HeartbeatLagContext(ctx)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		lag, ok = max(lag, l), true
+	}
+	if ok {
+		return lag, true, nil
+	}
+	return 0, false, errors.Join(errs...)
+}
@@ -0,0 +1,86 @@
+package replication
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestHeartbeat ハートビートの書き込みと、書き込み中のGetReplicationStatusがスタンバイのハートビートの遅延を返すテスト
+func TestHeartbeat(t *testing.T) {
+	written := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	primary := &tableConnector{}
+	c := &Cluster{Primary: sql.OpenDB(primary)}
+	for _, sb := range []struct {
+		name string
+		lag  float64
+	}{{"standby1", 0.25}, {"standby2", 1.5}} {
+		n := &Node{Name: sb.name, Weight: 1, DB: sql.OpenDB(&tableConnector{rows: [][]driver.Value{{written, sb.lag}}})}
+		n.healthy.Store(true)
+		c.Standbys = append(c.Standbys, n)
+	}
+	defer c.Close()
+
+	lag, at, err := c.Standbys[0].HeartbeatLag()
+	if err != nil || lag <= 0 || !at.Equal(written) {
+		t.Fatalf("ハートビートの遅延 = %v, %v, %v", lag, at, err)
+	}
+
+	w := c.StartHeartbeat(HeartbeatConfig{Interval: time.Millisecond})
+	deadline := time.Now().Add(time.Second)
+	for {
+		if last, _ := w.LastWrite(); !last.IsZero() {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("ハートビートが書き込まれない")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	primary.mu.Lock()
+	execs := append([]string(nil), primary.execs...)
+	primary.mu.Unlock()
+	if len(execs) < 2 || !strings.Contains(execs[0], "CREATE TABLE IF NOT EXISTS "+HeartbeatTable) ||
+		!strings.Contains(execs[1], "ON CONFLICT (id) DO UPDATE") {
+		t.Errorf("テーブル作成の後に上書きするはず: %q", execs)
+	}
+	for _, q := range execs[1:] {
+		if strings.Contains(q, "CREATE TABLE") {
+			t.Errorf("テーブル作成は1回だけのはず: %q", execs)
+		}
+	}
+
+	if got, err := c.GetReplicationStatus(); err != nil || got != 1.5 {
+		t.Errorf("遅延 = %v, %v, want 1.5", got, err)
+	}
+
+	// 読めないスタンバイは除き、読めたスタンバイの遅延を返す
+	_ = c.Standbys[1].DB.Close()
+	c.Standbys[1].DB = sql.OpenDB(&tableConnector{err: errors.New(`relation "replication_heartbeat" does not exist`)})
+	if got, err := c.GetReplicationStatus(); err != nil || got != 0.25 {
+		t.Errorf("遅延 = %v, %v, want 0.25", got, err)
+	}
+
+	// ヘルスチェックで除外されたスタンバイは問い合わせず、1台も読めなければエラーを返す
+	c.Standbys[0].healthy.Store(false)
+	_ = c.Standbys[0].DB.Close()
+	c.Standbys[0].DB = sql.OpenDB(&tableConnector{err: errors.New("connection refused")})
+	var ne *NodeError
+	if _, err := c.GetReplicationStatusContext(context.Background()); !errors.As(err, &ne) || ne.Node != "standby2" ||
+		strings.Contains(err.Error(), "connection refused") {
+		t.Errorf("読めなかったスタンバイのエラーだけを返すはず: %v", err)
+	}
+
+	// 停止するとpg_stat_replicationの値に戻る
+	w.Stop()
+	if c.heartbeatRunning() {
+		t.Error("停止後もハートビートの書き込み中と判定されている")
+	}
+	if got, err := c.GetReplicationStatus(); err != nil || got != 0 {
+		t.Errorf("停止後の遅延 = %v, %v, want 0", got, err)
+	}
+}
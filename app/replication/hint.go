@@ -0,0 +1,131 @@
+package replication
+
+import (
+	"fmt"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// RouteHint SQL先頭のコメントで指定されたルーティングヒント。
+//
+//	/* route=primary */ SELECT ...
+//	/* route=standby max_lag=2s */ SELECT ...
+type RouteHint struct {
+	// Route 送信先
+	Route Route
+	// MaxLag スタンバイで許容する再生遅延（0の場合は判定しない）。超えている場合はプライマリへ送る
+	MaxLag time.Duration
+}
+
+// String コメントに書く形式で返す
+func (h RouteHint) String() string {
+	if h.MaxLag > 0 {
+		return fmt.Sprintf("route=%s max_lag=%s", h.Route, h.MaxLag)
+	}
+	return "route=" + h.Route.String()
+}
+
+// ParseRouteHint クエリ先頭のコメントからルーティングヒントを取り出す。
+// ヒントがない場合はnilを返す。strippedはヒントのコメントを取り除いたクエリ
+func ParseRouteHint(query string) (hint *RouteHint, stripped string, err error) {
+	pos := len(query) - len(strings.TrimLeftFunc(query, unicode.IsSpace))
+	for strings.HasPrefix(query[pos:], "/*") {
+		end := strings.Index(query[pos:], "*/")
+		if end < 0 {
+			break
+		}
+		body := query[pos+2 : pos+end]
+		next := pos + end + 2
+
+		hint, err := parseHintBody(body)
+		if err != nil {
+			return nil, query, err
+		}
+		if hint != nil {
+			rest := strings.TrimLeftFunc(query[next:], unicode.IsSpace)
+			return hint, query[:pos] + rest, nil
+		}
+		pos = next + len(query[next:]) - len(strings.TrimLeftFunc(query[next:], unicode.IsSpace))
+	}
+	return nil, query, nil
+}
+
+// parseHintBody コメント本文をヒントとして解釈する。route= を含まない場合はnilを返す
+func parseHintBody(body string) (*RouteHint, error) {
+	fields := strings.Fields(body)
+	values := make(map[string]string, len(fields))
+	for _, f := range fields {
+		k, v, ok := strings.Cut(f, "=")
+		if ok {
+			values[strings.ToLower(k)] = v
+		}
+	}
+	route, ok := values["route"]
+	if !ok {
+		return nil, nil
+	}
+
+	var hint RouteHint
+	switch strings.ToLower(route) {
+	case "primary":
+		hint.Route = RoutePrimary
+	case "standby":
+		hint.Route = RouteStandby
+	default:
+		return nil, fmt.Errorf("不明なルーティングヒントです: route=%s", route)
+	}
+	if v, ok := values["max_lag"]; ok {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < 0 {
+			return nil, fmt.Errorf("不正なルーティングヒントです: max_lag=%s", v)
+		}
+		hint.MaxLag = d
+	}
+	return &hint, nil
+}
+
+// ルーティングの判断根拠
+const (
+	// DecisionHint SQLのルーティングヒントに従った
+	DecisionHint = "hint"
+	// DecisionClassified SQL文の解析結果に従った
+	DecisionClassified = "classified"
+	// DecisionTransaction 実行中のトランザクションの接続に固定された
+	DecisionTransaction = "transaction"
+	// DecisionSticky 書き込み直後のセッションのためプライマリに固定された
+	DecisionSticky = "sticky"
+)
+
+// RouteDecision 1つの文のルーティング結果
+type RouteDecision struct {
+	// Query ノードへ送信したクエリ（ヒントを削除した場合は削除後）
+	Query string
+	// Route 送信先
+	Route Route
+	// Source 判断根拠（DecisionHint / DecisionClassified / DecisionTransaction / DecisionSticky）
+	Source string
+	// Hint 指定されていたルーティングヒント（なければnil）
+	Hint *RouteHint
+	// Reason 本来の送信先から変更した理由、または無視したヒントの理由（なければ空）
+	Reason string
+}
+
+// RouteQuery ヒントとSQL文の解析結果から送信先を決める。stripHintsがtrueの場合は
+// 返されるQueryからヒントのコメントを取り除く。不正なヒントは無視してSQL文の解析結果に従う
+func RouteQuery(query string, stripHints bool) RouteDecision {
+	d := RouteDecision{Query: query}
+	hint, stripped, err := ParseRouteHint(query)
+	switch {
+	case err != nil:
+		d.Reason = err.Error()
+	case hint != nil:
+		d.Route, d.Source, d.Hint = hint.Route, DecisionHint, hint
+		if stripHints {
+			d.Query = stripped
+		}
+		return d
+	}
+	d.Route, d.Source = ClassifyQuery(query), DecisionClassified
+	return d
+}
@@ -0,0 +1,80 @@
+package replication
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+)
+
+// TestParseRouteHint ルーティングヒントの解析テスト
+func TestParseRouteHint(t *testing.T) {
+	tests := []struct {
+		query    string
+		want     *RouteHint
+		stripped string
+		wantErr  bool
+	}{
+		{"SELECT 1", nil, "SELECT 1", false},
+		{"/* route=primary */ SELECT 1", &RouteHint{Route: RoutePrimary}, "SELECT 1", false},
+		{"  /* route=standby max_lag=2s */\nSELECT 1", &RouteHint{Route: RouteStandby, MaxLag: 2 * time.Second}, "  SELECT 1", false},
+		{"/* app=report */ /* ROUTE=Standby */ SELECT 1", &RouteHint{Route: RouteStandby}, "/* app=report */ SELECT 1", false},
+		{"SELECT 1 /* route=primary */", nil, "SELECT 1 /* route=primary */", false},
+		{"/* route=replica */ SELECT 1", nil, "", true},
+		{"/* route=standby max_lag=soon */ SELECT 1", nil, "", true},
+	}
+
+	for _, tt := range tests {
+		hint, stripped, err := ParseRouteHint(tt.query)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("ParseRouteHint(%q): エラーにならない", tt.query)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ParseRouteHint(%q): %v", tt.query, err)
+			continue
+		}
+		if (hint == nil) != (tt.want == nil) || (hint != nil && *hint != *tt.want) {
+			t.Errorf("ParseRouteHint(%q) = %v, want %v", tt.query, hint, tt.want)
+		}
+		if stripped != tt.stripped {
+			t.Errorf("ParseRouteHint(%q) stripped = %q, want %q", tt.query, stripped, tt.stripped)
+		}
+	}
+}
+
+// TestConnectorRouteHints ヒントによる送信先の上書きと、ヒントの削除・記録のテスト
+func TestConnectorRouteHints(t *testing.T) {
+	var calls []string
+	var decisions []RouteDecision
+	c := NewConnector(&fakeConnector{name: "primary", calls: &calls}, &fakeConnector{name: "standby", calls: &calls})
+	c.StripHints = true
+	c.OnRoute = func(d RouteDecision) { decisions = append(decisions, d) }
+	db := sql.OpenDB(c)
+	defer func() { _ = db.Close() }()
+
+	rows, err := db.Query("/* route=primary */ SELECT 1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = rows.Close()
+	if calls[len(calls)-1] != "primary:query" {
+		t.Fatalf("ヒントに従ってプライマリへ送信されるはず: %v", calls)
+	}
+	d := decisions[len(decisions)-1]
+	if d.Source != DecisionHint || d.Route != RoutePrimary || d.Query != "SELECT 1" {
+		t.Fatalf("ルーティング結果が不正: %+v", d)
+	}
+
+	// 不正なヒントは無視してSQL文の解析結果に従う
+	rows, err = db.Query("/* route=replica */ SELECT 2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = rows.Close()
+	d = decisions[len(decisions)-1]
+	if calls[len(calls)-1] != "standby:query" || d.Source != DecisionClassified || d.Reason == "" {
+		t.Fatalf("不正なヒントが無視されていない: %v %+v", calls, d)
+	}
+}
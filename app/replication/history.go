@@ -0,0 +1,269 @@
+package replication
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// LagSample 1回分の計測結果。履歴ファイル（JSONL）の1行になる
+type LagSample struct {
+	Time time.Time `json:"time"`
+	// PrimaryLSN プライマリの現在のWAL位置
+	PrimaryLSN LSN `json:"primary_lsn"`
+	// WALRate 前回の計測からのWAL生成量（bytes/s）。前回の計測がない場合はnil
+	WALRate *float64 `json:"wal_bytes_per_second,omitempty"`
+	// Standbys プライマリのpg_stat_replicationから見たスタンバイごとの遅延
+	Standbys []StandbySample `json:"standbys"`
+	// Heartbeats ノード名ごとのハートビートの遅延（秒）。StartHeartbeat実行中のみ
+	Heartbeats map[string]float64 `json:"heartbeat_lag_seconds,omitempty"`
+	// Slots レプリケーションスロットごとの保持WAL
+	Slots []SlotSample `json:"slots"`
+	// Errors 取得に失敗した項目のエラー
+	Errors []string `json:"errors,omitempty"`
+}
+
+// StandbySample スタンバイ1台分の計測結果
+type StandbySample struct {
+	ApplicationName string `json:"application_name"`
+	ClientAddr      string `json:"client_addr,omitempty"`
+	ReplayLSN       LSN    `json:"replay_lsn"`
+	LagBytes        int64  `json:"lag_bytes"`
+	// ReplayLag pg_stat_replicationのreplay_lag（秒）
+	ReplayLag float64 `json:"replay_lag_seconds"`
+}
+
+// Name レポートでの表示名（application_nameが重複しても区別できるようclient_addrを付ける）
+func (s StandbySample) Name() string {
+	if s.ClientAddr == "" {
+		return s.ApplicationName
+	}
+	return fmt.Sprintf("%s (%s)", s.ApplicationName, s.ClientAddr)
+}
+
+// SlotSample レプリケーションスロット1つ分の計測結果
+type SlotSample struct {
+	Name          string `json:"name"`
+	Active        bool   `json:"active"`
+	RetainedBytes int64  `json:"retained_bytes"`
+}
+
+// SampleLag プライマリとスタンバイの遅延・WAL位置・スロットの保持WALを計測する。
+// prevを渡すとWAL生成量を計算する。取得に失敗した項目はErrorsに記録し、計測できた分だけを返す
+func (c *Cluster) SampleLag(ctx context.Context, prev *LagSample) LagSample {
+	s := LagSample{Time: time.Now()}
+	addErr := func(err error) { s.Errors = append(s.Errors, err.Error()) }
+
+	if lsn, err := c.CurrentLSNContext(ctx); err != nil {
+		addErr(err)
+	} else {
+		s.PrimaryLSN = lsn
+		if prev != nil && prev.PrimaryLSN != 0 && lsn >= prev.PrimaryLSN {
+			if elapsed := s.Time.Sub(prev.Time).Seconds(); elapsed > 0 {
+				rate := float64(lsn-prev.PrimaryLSN) / elapsed
+				s.WALRate = &rate
+			}
+		}
+	}
+
+	if statuses, err := c.StandbyStatusesContext(ctx); err != nil {
+		addErr(err)
+	} else {
+		for _, st := range statuses {
+			s.Standbys = append(s.Standbys, StandbySample{
+				ApplicationName: st.ApplicationName, ClientAddr: st.ClientAddr,
+				ReplayLSN: st.ReplayLSN, LagBytes: st.LagBytes, ReplayLag: st.ReplayLag.Seconds(),
+			})
+		}
+	}
+
+	if c.heartbeatRunning() {
+		for _, n := range c.StandbyNodes() {
+			lag, _, err := n.HeartbeatLagContext(ctx)
+			if err != nil {
+				addErr(err)
+				continue
+			}
+			if s.Heartbeats == nil {
+				s.Heartbeats = make(map[string]float64)
+			}
+			s.Heartbeats[n.Name] = lag.Seconds()
+		}
+	}
+
+	if slots, err := c.ReplicationSlotsContext(ctx); err != nil {
+		addErr(err)
+	} else {
+		for _, sl := range slots {
+			s.Slots = append(s.Slots, SlotSample{Name: sl.Name, Active: sl.Active, RetainedBytes: sl.RetainedBytes})
+		}
+	}
+	return s
+}
+
+// HistoryFile 計測結果を1行1件のJSON（JSONL）で追記する履歴ファイル
+type HistoryFile struct {
+	mu sync.Mutex
+	f  *os.File
+}
+
+// OpenHistory 履歴ファイルを追記モードで開く（なければ作成する）
+func OpenHistory(path string) (*HistoryFile, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("履歴ファイルを開けません: %w", err)
+	}
+	return &HistoryFile{f: f}, nil
+}
+
+// Append 計測結果を1行追記する。1回のwriteで書き込むため、途中で停止しても既存の行は壊れない
+func (h *HistoryFile) Append(s LagSample) error {
+	line, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if _, err := h.f.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("履歴ファイルへの書き込みエラー: %w", err)
+	}
+	return nil
+}
+
+// Close 履歴ファイルを閉じる
+func (h *HistoryFile) Close() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.f.Close()
+}
+
+// ReadHistory 履歴を読み込み、from以上to未満の計測結果を返す（ゼロ値の場合は制限しない）。
+// 書き込み途中で停止した場合に残る末尾の不完全な行は無視する
+func ReadHistory(r io.Reader, from, to time.Time) ([]LagSample, error) {
+	br := bufio.NewReader(r)
+	var samples []LagSample
+	for lineNo := 1; ; lineNo++ {
+		line, err := br.ReadBytes('\n')
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+		complete := bytes.HasSuffix(line, []byte("\n"))
+		if line = bytes.TrimSpace(line); len(line) > 0 {
+			var s LagSample
+			if jsonErr := json.Unmarshal(line, &s); jsonErr != nil {
+				if !complete {
+					break
+				}
+				return nil, fmt.Errorf("履歴の%d行目を読み込めません: %w", lineNo, jsonErr)
+			}
+			if (from.IsZero() || !s.Time.Before(from)) && (to.IsZero() || s.Time.Before(to)) {
+				samples = append(samples, s)
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+	}
+	return samples, nil
+}
+
+// HistoryRecorderConfig 履歴の記録設定
+type HistoryRecorderConfig struct {
+	// Path 履歴ファイルのパス
+	Path string
+	// Interval 計測間隔（0の場合は10秒）
+	Interval time.Duration
+	// Timeout 1回の計測のタイムアウト（0の場合は5秒）
+	Timeout time.Duration
+	// OnSample 記録した計測結果を受け取る（記録用goroutineから呼ばれる）
+	OnSample func(LagSample)
+	// OnError 履歴ファイルへの書き込みに失敗したときに呼ばれる
+	OnError func(error)
+}
+
+// HistoryRecorder 一定間隔で計測し、履歴ファイルに追記する
+type HistoryRecorder struct {
+	cluster *Cluster
+	cfg     HistoryRecorderConfig
+	file    *HistoryFile
+	prev    *LagSample
+
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// StartHistoryRecorder 計測結果の記録を開始する。Cluster.Closeで停止し、履歴ファイルを閉じる
+func (c *Cluster) StartHistoryRecorder(cfg HistoryRecorderConfig) (*HistoryRecorder, error) {
+	if cfg.Interval <= 0 {
+		cfg.Interval = 10 * time.Second
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = 5 * time.Second
+	}
+	file, err := OpenHistory(cfg.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	r := &HistoryRecorder{cluster: c, cfg: cfg, file: file, cancel: cancel, done: make(chan struct{})}
+	go r.run(ctx)
+
+	c.mu.Lock()
+	c.recorders = append(c.recorders, r)
+	c.mu.Unlock()
+	return r, nil
+}
+
+// Stop 記録を停止し、履歴ファイルを閉じる
+func (r *HistoryRecorder) Stop() {
+	r.cancel()
+	<-r.done
+}
+
+// run 定期的に計測して記録する
+func (r *HistoryRecorder) run(ctx context.Context) {
+	defer close(r.done)
+	defer func() { _ = r.file.Close() }()
+	ticker := time.NewTicker(r.cfg.Interval)
+	defer ticker.Stop()
+
+	for {
+		r.record(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// record 1回計測して追記する
+func (r *HistoryRecorder) record(ctx context.Context) {
+	sampleCtx, cancel := context.WithTimeout(ctx, r.cfg.Timeout)
+	s := r.cluster.SampleLag(sampleCtx, r.prev)
+	cancel()
+	if ctx.Err() != nil {
+		// 停止中の計測は記録しない
+		return
+	}
+
+	if err := r.file.Append(s); err != nil {
+		if r.cfg.OnError != nil {
+			r.cfg.OnError(err)
+		}
+		return
+	}
+	if s.PrimaryLSN != 0 {
+		r.prev = &s
+	}
+	if r.cfg.OnSample != nil {
+		r.cfg.OnSample(s)
+	}
+}
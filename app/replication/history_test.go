@@ -0,0 +1,155 @@
+package replication
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestSampleLag 遅延・WAL位置・スロットを1件の計測結果にまとめ、前回からのWAL生成量を計算するテスト
+func TestSampleLag(t *testing.T) {
+	c := &Cluster{Primary: sql.OpenDB(&tableConnector{
+		rows: [][]driver.Value{{"0/3000000"}},
+		queries: map[string][][]driver.Value{
+			"pg_stat_replication": {{int64(101), "standby1", "172.18.0.3", "streaming", "async",
+				"0/3000000", "0/3000000", "0/3000000", "0/2FFFF00", int64(256), 0.0, 0.0, 0.5, nil}},
+			"pg_replication_slots": {slotRow("standby_slot", false, 1<<20, "reserved", nil, nil)},
+		},
+	})}
+	defer c.Close()
+
+	prev := &LagSample{Time: time.Now().Add(-2 * time.Second), PrimaryLSN: 0x3000000 - 2048}
+	s := c.SampleLag(context.Background(), prev)
+	if len(s.Errors) > 0 {
+		t.Fatalf("取得エラー: %v", s.Errors)
+	}
+	if s.PrimaryLSN.String() != "0/3000000" || s.WALRate == nil || *s.WALRate <= 0 || *s.WALRate > 1024 {
+		t.Errorf("WAL位置・生成量が不正: %s %v", s.PrimaryLSN, s.WALRate)
+	}
+	if len(s.Standbys) != 1 || s.Standbys[0].Name() != "standby1 (172.18.0.3)" || s.Standbys[0].LagBytes != 256 || s.Standbys[0].ReplayLag != 0.5 {
+		t.Errorf("スタンバイの計測結果が不正: %+v", s.Standbys)
+	}
+	if len(s.Slots) != 1 || s.Slots[0].Active || s.Slots[0].RetainedBytes != 1<<20 {
+		t.Errorf("スロットの計測結果が不正: %+v", s.Slots)
+	}
+	if first := c.SampleLag(context.Background(), nil); first.WALRate != nil {
+		t.Errorf("前回の計測がなければWAL生成量はnilのはず: %v", *first.WALRate)
+	}
+}
+
+// TestHistoryFile 追記した計測結果を期間で絞り込んで読み込み、末尾の不完全な行を無視するテスト
+func TestHistoryFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "lag_history.jsonl")
+	start := time.Date(2026, 1, 2, 3, 0, 0, 0, time.UTC)
+	for i := 0; i < 2; i++ {
+		// 開き直しても追記される
+		h, err := OpenHistory(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for j := 0; j < 3; j++ {
+			n := i*3 + j
+			if err := h.Append(LagSample{Time: start.Add(time.Duration(n) * time.Minute), PrimaryLSN: LSN(n + 1)}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := h.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _ = f.WriteString(`{"time":"2026-01-02T03:10:00Z","primary_l`)
+	_ = f.Close()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), `"primary_lsn":"0/1"`) {
+		t.Errorf("LSNは文字列で記録するはず: %s", data)
+	}
+
+	all, err := ReadHistory(bytes.NewReader(data), time.Time{}, time.Time{})
+	if err != nil || len(all) != 6 || all[5].PrimaryLSN != 6 {
+		t.Fatalf("全件 = %d件, %v", len(all), err)
+	}
+	window, err := ReadHistory(bytes.NewReader(data), start.Add(time.Minute), start.Add(4*time.Minute))
+	if err != nil || len(window) != 3 || !window[0].Time.Equal(start.Add(time.Minute)) {
+		t.Fatalf("期間の絞り込みが不正: %+v, %v", window, err)
+	}
+
+	// 末尾以外の壊れた行はエラー
+	if _, err := ReadHistory(strings.NewReader("{\n"+string(data)), time.Time{}, time.Time{}); err == nil {
+		t.Error("壊れた行はエラーになるはず")
+	}
+}
+
+// TestLagReport 項目・対象ごとにmin/avg/p95/maxを集計し、MarkdownとHTMLで出力するテスト
+func TestLagReport(t *testing.T) {
+	start := time.Date(2026, 1, 2, 3, 0, 0, 0, time.UTC)
+	var samples []LagSample
+	for i := 1; i <= 20; i++ {
+		rate := float64(i * 1024)
+		s := LagSample{
+			Time:     start.Add(time.Duration(i) * time.Second),
+			Standbys: []StandbySample{{ApplicationName: "standby1", LagBytes: int64(i), ReplayLag: float64(i) / 10}},
+			Slots:    []SlotSample{{Name: "<slot>", RetainedBytes: 1 << 20}},
+		}
+		if i > 1 {
+			s.WALRate = &rate
+		}
+		if i == 20 {
+			s.Errors = []string{"timeout"}
+		}
+		samples = append(samples, s)
+	}
+
+	r := BuildLagReport(samples, time.Time{}, time.Time{})
+	if !r.From.Equal(start.Add(time.Second)) || !r.To.Equal(start.Add(20*time.Second)) || r.Samples != 20 || r.Errors != 1 {
+		t.Errorf("期間・件数が不正: %+v", r)
+	}
+	rows := make(map[string]ReportRow)
+	for _, row := range r.Rows {
+		rows[row.Metric+"/"+row.Target] = row
+	}
+	if row := rows[metricLagBytes+"/standby1"]; row.Stats != (Stats{Count: 20, Min: 1, Avg: 10.5, P95: 19, Max: 20}) {
+		t.Errorf("再生遅延の集計が不正: %+v", row.Stats)
+	}
+	if row := rows[metricWALRate+"/"]; row.Count != 19 || row.Min != 2048 {
+		t.Errorf("最初の計測のWAL生成量は集計しないはず: %+v", row.Stats)
+	}
+	if r.Rows[0].Metric != metricWALRate {
+		t.Errorf("WAL生成量が先頭のはず: %+v", r.Rows)
+	}
+
+	var md bytes.Buffer
+	if err := r.WriteMarkdown(&md); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"- 期間: 2026-01-02 03:00:01 UTC 〜 2026-01-02 03:00:20 UTC",
+		"| 再生遅延（WAL位置の差） | standby1 | 20 | 1 B | 10 B | 19 B | 20 B |",
+		"| スロットの保持WAL | <slot> | 20 | 1.0 MiB |",
+	} {
+		if !strings.Contains(md.String(), want) {
+			t.Errorf("Markdownに %q がありません:\n%s", want, md.String())
+		}
+	}
+
+	var html bytes.Buffer
+	if err := r.WriteHTML(&html); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(html.String(), "<td>&lt;slot&gt;</td>") || !strings.Contains(html.String(), `<td class="num">0.100 s</td>`) {
+		t.Errorf("HTMLが不正:\n%s", html.String())
+	}
+}
@@ -0,0 +1,197 @@
+package replication
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"sync/atomic"
+)
+
+// LSNHeader サービス間でread-your-writesを保証するためにLSNを受け渡すHTTPヘッダー
+const LSNHeader = "X-Replication-LSN"
+
+// lsnKey contextに一貫性トークンを格納するためのキー
+type lsnKey struct{}
+
+// consistencyToken 1つのリクエスト処理中に受け取った、または書き込んだLSN
+type consistencyToken struct {
+	mu      sync.Mutex
+	lsn     LSN
+	written bool
+}
+
+// observe lsnがこれまでより新しければ記録する
+func (t *consistencyToken) observe(lsn LSN, written bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if lsn > t.lsn {
+		t.lsn = lsn
+	}
+	t.written = t.written || written
+}
+
+// get 記録されたLSNと、書き込みによるものを含むかを返す
+func (t *consistencyToken) get() (LSN, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.lsn, t.written
+}
+
+// WithLSN ctxにLSNを設定する。このctxでの読み取りはスタンバイがlsnまで再生してから行われ、
+// このctxでの書き込みのLSNも記録される
+func WithLSN(ctx context.Context, lsn LSN) context.Context {
+	t := &consistencyToken{lsn: lsn}
+	if parent, ok := ctx.Value(lsnKey{}).(*consistencyToken); ok {
+		if l, _ := parent.get(); l > t.lsn {
+			t.lsn = l
+		}
+	}
+	return context.WithValue(ctx, lsnKey{}, t)
+}
+
+// LSNFromContext ctxに設定された、またはctxでの書き込みで記録されたLSNを返す
+func LSNFromContext(ctx context.Context) (LSN, bool) {
+	t, ok := ctx.Value(lsnKey{}).(*consistencyToken)
+	if !ok {
+		return 0, false
+	}
+	lsn, _ := t.get()
+	return lsn, lsn != 0
+}
+
+// recordWrite ctxにトークンがあれば書き込みのLSNを記録する
+func recordWrite(ctx context.Context, lsn LSN) {
+	if t, ok := ctx.Value(lsnKey{}).(*consistencyToken); ok {
+		t.observe(lsn, true)
+	}
+}
+
+// Middleware リクエストのLSNHeaderをリクエストのctxに設定し、ハンドラー内での読み取りに適用する。
+// ハンドラー内でctxを使って書き込んだ場合は、そのLSNをレスポンスのLSNHeaderに設定する
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var lsn LSN
+		if v := r.Header.Get(LSNHeader); v != "" {
+			// 不正なトークンは無視する（スタンバイの再生を待たずに読み取る）
+			lsn, _ = ParseLSN(v)
+		}
+		ctx := WithLSN(r.Context(), lsn)
+		token := ctx.Value(lsnKey{}).(*consistencyToken)
+		tw := &tokenResponseWriter{ResponseWriter: w, token: token}
+		next.ServeHTTP(tw, r.WithContext(ctx))
+		// 何も書き込まずに戻った場合（暗黙の200）も、送信前にトークンを設定する
+		if !tw.wroteHeader {
+			tw.setToken()
+		}
+	})
+}
+
+// tokenResponseWriter レスポンスヘッダーの送信前にLSNHeaderを設定するResponseWriter
+type tokenResponseWriter struct {
+	http.ResponseWriter
+	token       *consistencyToken
+	wroteHeader bool
+}
+
+// WriteHeader 書き込みがあればLSNHeaderを設定してからステータスを送信する
+func (w *tokenResponseWriter) WriteHeader(code int) {
+	if !w.wroteHeader {
+		w.wroteHeader = true
+		w.setToken()
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+// setToken 書き込みがあればLSNHeaderを設定する
+func (w *tokenResponseWriter) setToken() {
+	if lsn, written := w.token.get(); written {
+		w.Header().Set(LSNHeader, lsn.String())
+	}
+}
+
+// Write ステータス未送信の場合は200で送信してから本文を書き込む
+func (w *tokenResponseWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// Flush http.Flusher実装。ストリーミングするハンドラーのためにLSNHeaderを設定してから送信する
+func (w *tokenResponseWriter) Flush() {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap http.ResponseControllerから元のResponseWriterを参照できるようにする
+func (w *tokenResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// Transport リクエストにLSNHeaderを付けるhttp.RoundTripper。
+// リクエストのctxのLSNを送る（ShareLastLSN指定時はこれまでのレスポンスで受け取った最新のLSNと新しい方を送る）
+type Transport struct {
+	// Base 実際に送信するRoundTripper（nilの場合はhttp.DefaultTransport）
+	Base http.RoundTripper
+	// ShareLastLSN trueの場合、このTransportで受け取った最新のLSNをctxにLSNのない以降のリクエストにも付ける。
+	// 複数の呼び出し元で共有するクライアントでは、ある呼び出し元の書き込みで他の呼び出し元の読み取りも待たされる
+	ShareLastLSN bool
+
+	last atomic.Uint64
+}
+
+// RoundTrip LSNHeaderを付けて送信し、レスポンスのLSNHeaderを記録する
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	var lsn LSN
+	if t.ShareLastLSN {
+		lsn = t.LastLSN()
+	}
+	if ctxLSN, ok := LSNFromContext(req.Context()); ok && ctxLSN > lsn {
+		lsn = ctxLSN
+	}
+	if lsn != 0 && req.Header.Get(LSNHeader) == "" {
+		req = req.Clone(req.Context())
+		req.Header.Set(LSNHeader, lsn.String())
+	}
+
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	resp, err := base.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if v := resp.Header.Get(LSNHeader); v != "" {
+		if respLSN, err := ParseLSN(v); err == nil {
+			if t.ShareLastLSN {
+				t.observe(respLSN)
+			}
+			// 呼び出し元のリクエスト処理中であれば、そのレスポンスにも引き継ぐ
+			if token, ok := req.Context().Value(lsnKey{}).(*consistencyToken); ok {
+				token.observe(respLSN, true)
+			}
+		}
+	}
+	return resp, nil
+}
+
+// LastLSN これまでのレスポンスで受け取った最新のLSN（ShareLastLSNがfalseの場合は常に0）
+func (t *Transport) LastLSN() LSN {
+	return LSN(t.last.Load())
+}
+
+// observe lsnがこれまでより新しければ記録する
+func (t *Transport) observe(lsn LSN) {
+	for {
+		cur := t.last.Load()
+		if uint64(lsn) <= cur || t.last.CompareAndSwap(cur, uint64(lsn)) {
+			return
+		}
+	}
+}
@@ -0,0 +1,125 @@
+package replication
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// TestLSNHeaderPropagation クライアントとミドルウェアの間でLSNが受け渡されるテスト
+func TestLSNHeaderPropagation(t *testing.T) {
+	var seen LSN
+	mux := http.NewServeMux()
+	mux.HandleFunc("/write", func(w http.ResponseWriter, r *http.Request) {
+		seen, _ = LSNFromContext(r.Context())
+		recordWrite(r.Context(), 0x20) // プライマリへの書き込みを模擬
+		_, _ = io.WriteString(w, "ok")
+	})
+	mux.HandleFunc("/read", func(w http.ResponseWriter, r *http.Request) {
+		seen, _ = LSNFromContext(r.Context())
+		w.WriteHeader(http.StatusNoContent)
+	})
+	server := httptest.NewServer(Middleware(mux))
+	defer server.Close()
+
+	transport := &Transport{}
+	get := func(ctx context.Context, path string) *http.Response {
+		return getWith(t, transport, ctx, server.URL+path)
+	}
+
+	resp := get(WithLSN(context.Background(), 0x10), "/write")
+	if seen != 0x10 {
+		t.Fatalf("リクエストのLSNがハンドラーに渡されていない: %s", seen)
+	}
+	if got := resp.Header.Get(LSNHeader); got != "0/20" {
+		t.Fatalf("書き込みのLSNがレスポンスヘッダーに設定されていない: %q", got)
+	}
+
+	// デフォルトでは他のリクエストのレスポンスのLSNを付けない
+	resp = get(context.Background(), "/read")
+	if seen != 0 {
+		t.Fatalf("ctxにLSNのないリクエストにLSNが付与された: %s", seen)
+	}
+	if got := resp.Header.Get(LSNHeader); got != "" {
+		t.Fatalf("書き込みのないレスポンスにLSNが設定された: %q", got)
+	}
+	if transport.LastLSN() != 0 {
+		t.Fatalf("ShareLastLSNなしでLSNが記録された: %s", transport.LastLSN())
+	}
+}
+
+// TestTransportShareLastLSN ShareLastLSN指定時に以前のレスポンスのLSNを以降のリクエストに付けるテスト
+func TestTransportShareLastLSN(t *testing.T) {
+	var seen LSN
+	mux := http.NewServeMux()
+	mux.HandleFunc("/write", func(w http.ResponseWriter, r *http.Request) {
+		recordWrite(r.Context(), 0x20)
+		_, _ = io.WriteString(w, "ok")
+	})
+	mux.HandleFunc("/read", func(w http.ResponseWriter, r *http.Request) {
+		seen, _ = LSNFromContext(r.Context())
+		w.WriteHeader(http.StatusNoContent)
+	})
+	server := httptest.NewServer(Middleware(mux))
+	defer server.Close()
+
+	transport := &Transport{ShareLastLSN: true}
+	getWith(t, transport, context.Background(), server.URL+"/write")
+	if transport.LastLSN() != 0x20 {
+		t.Fatalf("レスポンスのLSNが記録されていない: %s", transport.LastLSN())
+	}
+
+	getWith(t, transport, context.Background(), server.URL+"/read")
+	if seen != 0x20 {
+		t.Fatalf("以前のレスポンスのLSNが次のリクエストに付与されていない: %s", seen)
+	}
+
+	// ctxのLSNの方が新しければそちらを送る
+	getWith(t, transport, WithLSN(context.Background(), 0x30), server.URL+"/read")
+	if seen != 0x30 {
+		t.Fatalf("ctxのLSNが付与されていない: %s", seen)
+	}
+}
+
+// getWith transportでGETリクエストを送り、ボディを閉じたレスポンスを返す
+func getWith(t *testing.T, transport *Transport, ctx context.Context, url string) *http.Response {
+	t.Helper()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := (&http.Client{Transport: transport}).Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = resp.Body.Close()
+	return resp
+}
+
+// TestMiddlewareImplicitResponse 何も書き込まないハンドラーやストリーミングするハンドラーでもLSNが設定されるテスト
+func TestMiddlewareImplicitResponse(t *testing.T) {
+	silent := Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		recordWrite(r.Context(), 0x30)
+	}))
+	rec := httptest.NewRecorder()
+	silent.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/", nil))
+	if got := rec.Header().Get(LSNHeader); got != "0/30" {
+		t.Fatalf("暗黙の200のレスポンスにLSNが設定されていない: %q", got)
+	}
+
+	stream := Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		recordWrite(r.Context(), 0x40)
+		f, ok := w.(http.Flusher)
+		if !ok {
+			t.Fatal("http.Flusherを実装していない")
+		}
+		f.Flush()
+	}))
+	rec = httptest.NewRecorder()
+	stream.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	if !rec.Flushed || rec.Header().Get(LSNHeader) != "0/40" {
+		t.Fatalf("Flushが元のResponseWriterに届いていない、またはLSNが未設定: flushed=%v %q", rec.Flushed, rec.Header().Get(LSNHeader))
+	}
+}
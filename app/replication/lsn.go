@@ -0,0 +1,148 @@
+package replication
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrReplayTimeout スタンバイが指定LSNまで再生するのを待つ間にタイムアウトした
+var ErrReplayTimeout = errors.New("スタンバイのWAL再生待機がタイムアウトしました")
+
+// LSN WALの位置（Log Sequence Number）。書き込み後の一貫性トークンとして使う
+type LSN uint64
+
+// ParseLSN "16/B374D848" 形式の文字列をLSNに変換（前後の余分な文字はエラー）
+func ParseLSN(s string) (LSN, error) {
+	hiStr, loStr, ok := strings.Cut(s, "/")
+	if !ok {
+		return 0, fmt.Errorf("LSNパースエラー %q: \"/\" がありません", s)
+	}
+	hi, err := strconv.ParseUint(hiStr, 16, 32)
+	if err != nil {
+		return 0, fmt.Errorf("LSNパースエラー %q: %v", s, err)
+	}
+	lo, err := strconv.ParseUint(loStr, 16, 32)
+	if err != nil {
+		return 0, fmt.Errorf("LSNパースエラー %q: %v", s, err)
+	}
+	return LSN(hi<<32 | lo), nil
+}
+
+// String PostgreSQLと同じ "XX/XXXXXXXX" 形式で返す
+func (l LSN) String() string {
+	return fmt.Sprintf("%X/%X", uint32(l>>32), uint32(l))
+}
+
+// Scan sql.Scanner実装。pg_lsn型の列を読み取る（NULLは0）
+func (l *LSN) Scan(src any) error {
+	switch v := src.(type) {
+	case nil:
+		*l = 0
+		return nil
+	case []byte:
+		return l.Scan(string(v))
+	case string:
+		parsed, err := ParseLSN(v)
+		if err != nil {
+			return err
+		}
+		*l = parsed
+		return nil
+	default:
+		return fmt.Errorf("LSNに変換できない型です: %T", src)
+	}
+}
+
+// Value driver.Valuer実装。pg_lsn型のパラメータとして渡す
+func (l LSN) Value() (driver.Value, error) {
+	return l.String(), nil
+}
+
+// MarshalText encoding.TextMarshaler実装。JSONでは "XX/XXXXXXXX" 形式の文字列になる
+func (l LSN) MarshalText() ([]byte, error) {
+	return []byte(l.String()), nil
+}
+
+// UnmarshalText encoding.TextUnmarshaler実装
+func (l *LSN) UnmarshalText(text []byte) error {
+	parsed, err := ParseLSN(string(text))
+	if err != nil {
+		return err
+	}
+	*l = parsed
+	return nil
+}
+
+const (
+	// replayPollMinInterval / replayPollMaxInterval スタンバイのWAL再生位置を確認する間隔
+	replayPollMinInterval = 5 * time.Millisecond
+	replayPollMaxInterval = 100 * time.Millisecond
+	// defaultReplayWaitTimeout LSN待機のデフォルトタイムアウト
+	defaultReplayWaitTimeout = 5 * time.Second
+)
+
+// CurrentLSN プライマリの現在のWAL書き込み位置を取得
+func (c *Cluster) CurrentLSN() (LSN, error) {
+	return c.CurrentLSNContext(context.Background())
+}
+
+// CurrentLSNContext ctxに従って中断できるCurrentLSN
+func (c *Cluster) CurrentLSNContext(ctx context.Context) (LSN, error) {
+	lsn, err := currentLSN(ctx, c.Primary)
+	return lsn, c.primaryError("WAL位置取得", err)
+}
+
+// currentLSN 指定DBの現在のWAL書き込み位置を取得
+func currentLSN(ctx context.Context, db *sql.DB) (LSN, error) {
+	var lsn LSN
+	if err := db.QueryRowContext(ctx, "SELECT pg_current_wal_lsn()").Scan(&lsn); err != nil {
+		return 0, err
+	}
+	return lsn, nil
+}
+
+// errNotInRecovery スタンバイとして動作していない（昇格済みなど）
+var errNotInRecovery = errors.New("リカバリ中ではありません")
+
+// waitForReplay dbのpg_last_wal_replay_lsn()がlsnに到達するまでポーリング。
+// スタンバイとして動作していない場合は待たずにerrNotInRecoveryを返す
+func waitForReplay(ctx context.Context, db *sql.DB, lsn LSN) error {
+	if lsn == 0 {
+		return nil
+	}
+
+	interval := replayPollMinInterval
+	for {
+		var inRecovery bool
+		var replayed sql.NullBool
+		err := db.QueryRowContext(ctx, "SELECT pg_is_in_recovery(), pg_last_wal_replay_lsn() >= $1::pg_lsn", lsn).Scan(&inRecovery, &replayed)
+		if err != nil {
+			if ctx.Err() != nil {
+				return fmt.Errorf("%w: %s: %w", ErrReplayTimeout, lsn, ctx.Err())
+			}
+			return err
+		}
+		// 昇格済みなどでスタンバイでなければ、再生を待っても到達しない
+		if !inRecovery || !replayed.Valid {
+			return errNotInRecovery
+		}
+		if replayed.Bool {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("%w: %s: %w", ErrReplayTimeout, lsn, ctx.Err())
+		case <-time.After(interval):
+		}
+		if interval < replayPollMaxInterval {
+			interval *= 2
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package replication
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// TestParseLSN LSN文字列の変換テスト
+func TestParseLSN(t *testing.T) {
+	lsn, err := ParseLSN("16/B374D848")
+	if err != nil {
+		t.Fatalf("パースエラー: %v", err)
+	}
+	if lsn != LSN(0x16B374D848) {
+		t.Fatalf("LSNが不正: %d", uint64(lsn))
+	}
+	if lsn.String() != "16/B374D848" {
+		t.Fatalf("文字列表現が不正: %s", lsn)
+	}
+
+	var scanned LSN
+	if err := scanned.Scan([]byte("0/3000060")); err != nil || scanned != LSN(0x3000060) {
+		t.Fatalf("Scan結果が不正: %s (%v)", scanned, err)
+	}
+	if err := scanned.Scan(nil); err != nil || scanned != 0 {
+		t.Fatalf("NULLのScan結果が不正: %s (%v)", scanned, err)
+	}
+
+	for _, invalid := range []string{"invalid", "0/16B3748xyz", "0/16B3748 ", "1/2/3", "/1", "100000000/0"} {
+		if _, err := ParseLSN(invalid); err == nil {
+			t.Fatalf("不正なLSN %q でエラーにならない", invalid)
+		}
+	}
+}
+
+// TestWaitForReplayCanceled 呼び出し元のキャンセルがエラーから判別でき、プライマリへフォールバックしないテスト
+func TestWaitForReplayCanceled(t *testing.T) {
+	var down atomic.Bool
+	node := &Node{Name: "standby1", Weight: 1, DB: sql.OpenDB(&probeConnector{down: &down})}
+	node.healthy.Store(true)
+	defer func() { _ = node.DB.Close() }()
+
+	c := &Cluster{Standbys: []*Node{node}}
+	c.SetRoutingPolicy(&RoundRobinPolicy{})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	o := readOptions{afterLSN: 1, waitTimeout: time.Second, primaryFallback: true}
+	_, err := c.chooseStandby(ctx, &o, &ReadInfo{})
+	if !errors.Is(err, context.Canceled) || !errors.Is(err, ErrReplayTimeout) {
+		t.Fatalf("キャンセル時はcontext.CanceledとErrReplayTimeoutの両方で判別できるはず: %v", err)
+	}
+}
+
+// TestWaitForReplayPromoted 昇格済みのノードでは再生を待たず、ErrStandbyUnavailableでプライマリへフォールバックするテスト
+func TestWaitForReplayPromoted(t *testing.T) {
+	node := &Node{Name: "standby1", Weight: 1, DB: sql.OpenDB(&tableConnector{rows: [][]driver.Value{{false, nil}}})}
+	node.healthy.Store(true)
+	defer func() { _ = node.DB.Close() }()
+
+	start := time.Now()
+	err := node.WaitForLSN(1, time.Second)
+	if !errors.Is(err, ErrStandbyUnavailable) || errors.Is(err, ErrReplayTimeout) {
+		t.Fatalf("ErrStandbyUnavailableを返すはず: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Fatalf("タイムアウトまで待たないはず: %s", elapsed)
+	}
+
+	c := &Cluster{Standbys: []*Node{node}}
+	c.SetRoutingPolicy(&RoundRobinPolicy{})
+	o := readOptions{afterLSN: 1, waitTimeout: time.Second, primaryFallback: true}
+	var info ReadInfo
+	if n, err := c.chooseStandby(context.Background(), &o, &info); err != nil || n != nil || info.Fallback != FallbackStandbyUnavailable {
+		t.Fatalf("プライマリへフォールバックするはず: %v, %v, %+v", n, err, info)
+	}
+}
@@ -0,0 +1,84 @@
+package replication
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// FallbackReason スタンバイではなくプライマリで読み取った理由の分類
+type FallbackReason string
+
+// プライマリで読み取った理由の分類
+const (
+	// FallbackStickySession 書き込み直後のセッションをプライマリに固定した
+	FallbackStickySession FallbackReason = "sticky_session"
+	// FallbackStandbyUnavailable 利用可能なスタンバイがない、または遅延を計測できなかった
+	FallbackStandbyUnavailable FallbackReason = "standby_unavailable"
+	// FallbackRecoveryConflict リカバリ競合のリトライで他のスタンバイがなかった
+	FallbackRecoveryConflict FallbackReason = "recovery_conflict"
+	// FallbackReplayTimeout AfterLSNの待機がタイムアウトした（WithPrimaryFallback指定時）
+	FallbackReplayTimeout FallbackReason = "replay_timeout"
+	// FallbackLagExceeded 全てのスタンバイが許容遅延を超えていた
+	FallbackLagExceeded FallbackReason = "lag_exceeded"
+)
+
+// clusterMetrics Clusterの動作を集計するカウンタ
+type clusterMetrics struct {
+	recoveryConflicts atomic.Int64
+	retries           atomic.Int64
+	retriesExhausted  atomic.Int64
+
+	mu sync.Mutex
+	// reads ノード名ごとの読み取り回数
+	reads map[string]int64
+	// fallbacks 理由ごとのプライマリでの読み取り回数
+	fallbacks map[FallbackReason]int64
+}
+
+// routed 読み取りを処理したノードを記録する
+func (m *clusterMetrics) routed(info ReadInfo) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.reads == nil {
+		m.reads = make(map[string]int64)
+		m.fallbacks = make(map[FallbackReason]int64)
+	}
+	m.reads[info.Name]++
+	if info.Fallback != "" {
+		m.fallbacks[info.Fallback]++
+	}
+}
+
+// Metrics Clusterの動作の累積カウンタ
+type Metrics struct {
+	// RecoveryConflicts 読み取りで検出したリカバリ競合の回数
+	RecoveryConflicts int64 `json:"recovery_conflicts"`
+	// Retries リカバリ競合によるリトライの回数
+	Retries int64 `json:"retries"`
+	// RetriesExhausted リトライ回数または時間の上限に達して失敗した回数
+	RetriesExhausted int64 `json:"retries_exhausted"`
+	// Reads ノード名（プライマリは "primary"）ごとの読み取り回数。リトライも1回と数える
+	Reads map[string]int64 `json:"reads"`
+	// Fallbacks スタンバイではなくプライマリで読み取った回数の理由ごとの内訳
+	Fallbacks map[FallbackReason]int64 `json:"fallbacks"`
+}
+
+// Metrics 現在のカウンタの値を返す
+func (c *Cluster) Metrics() Metrics {
+	m := Metrics{
+		RecoveryConflicts: c.metrics.recoveryConflicts.Load(),
+		Retries:           c.metrics.retries.Load(),
+		RetriesExhausted:  c.metrics.retriesExhausted.Load(),
+		Reads:             make(map[string]int64),
+		Fallbacks:         make(map[FallbackReason]int64),
+	}
+	c.metrics.mu.Lock()
+	defer c.metrics.mu.Unlock()
+	for name, n := range c.metrics.reads {
+		m.Reads[name] = n
+	}
+	for reason, n := range c.metrics.fallbacks {
+		m.Fallbacks[reason] = n
+	}
+	return m
+}
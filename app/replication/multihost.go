@@ -0,0 +1,270 @@
+package replication
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/lib/pq"
+)
+
+// MultiHostDriverName 複数ホスト指定に対応したドライバーの登録名
+const MultiHostDriverName = "postgres-multihost"
+
+func init() {
+	sql.Register(MultiHostDriverName, &MultiHostDriver{})
+}
+
+// TargetSessionAttrs 接続先として受け入れるサーバーの種類（libpqのtarget_session_attrs）
+type TargetSessionAttrs string
+
+// target_session_attrs の値
+const (
+	// SessionAny 最初に接続できたサーバー
+	SessionAny TargetSessionAttrs = "any"
+	// SessionReadWrite 書き込み可能なサーバー（transaction_read_only = off）
+	SessionReadWrite TargetSessionAttrs = "read-write"
+	// SessionReadOnly 読み取り専用のサーバー（transaction_read_only = on）
+	SessionReadOnly TargetSessionAttrs = "read-only"
+	// SessionPrimary プライマリ（pg_is_in_recovery() = false）
+	SessionPrimary TargetSessionAttrs = "primary"
+	// SessionStandby スタンバイ（pg_is_in_recovery() = true）
+	SessionStandby TargetSessionAttrs = "standby"
+	// SessionPreferStandby スタンバイを優先し、なければ任意のサーバー
+	SessionPreferStandby TargetSessionAttrs = "prefer-standby"
+)
+
+// sessionStateQuery 接続先の種類を判定するクエリ
+const sessionStateQuery = "SELECT pg_is_in_recovery(), current_setting('transaction_read_only') = 'on'"
+
+// ParseTargetSessionAttrs target_session_attrs の値を検証する（空の場合はany）
+func ParseTargetSessionAttrs(s string) (TargetSessionAttrs, error) {
+	switch a := TargetSessionAttrs(s); a {
+	case "":
+		return SessionAny, nil
+	case SessionAny, SessionReadWrite, SessionReadOnly, SessionPrimary, SessionStandby, SessionPreferStandby:
+		return a, nil
+	default:
+		return "", fmt.Errorf("不明なtarget_session_attrsです: %s", s)
+	}
+}
+
+// accepts サーバーの状態がこの条件を満たすか（prefer-standbyはスタンバイのみ）
+func (a TargetSessionAttrs) accepts(inRecovery, readOnly bool) bool {
+	switch a {
+	case SessionReadWrite:
+		return !readOnly
+	case SessionReadOnly:
+		return readOnly
+	case SessionPrimary:
+		return !inRecovery
+	case SessionStandby, SessionPreferStandby:
+		return inRecovery
+	default:
+		return true
+	}
+}
+
+// kind 条件を満たすサーバーがない場合のエラー種別
+func (a TargetSessionAttrs) kind() error {
+	switch a {
+	case SessionReadWrite, SessionPrimary:
+		return ErrPrimaryUnavailable
+	default:
+		return ErrStandbyUnavailable
+	}
+}
+
+// MultiHostDriver host / port にカンマ区切りで複数のサーバーを指定できるdatabase/sqlドライバー。
+// lib/pqが対応していないtarget_session_attrsを、接続後にpg_is_in_recovery()と
+// transaction_read_onlyを確認することで再現する。ホストは指定順に試す。
+//
+//	sql.Open("postgres-multihost", "host=primary,standby1 port=5432,5433 user=postgres dbname=testdb target_session_attrs=standby")
+type MultiHostDriver struct{}
+
+// Open 接続文字列から新しい接続を開く
+func (d *MultiHostDriver) Open(name string) (driver.Conn, error) {
+	c, err := d.OpenConnector(name)
+	if err != nil {
+		return nil, err
+	}
+	return c.Connect(context.Background())
+}
+
+// OpenConnector 接続文字列からConnectorを作成
+func (d *MultiHostDriver) OpenConnector(name string) (driver.Connector, error) {
+	return NewMultiHostConnector(name)
+}
+
+// MultiHostConnector 複数のサーバーからtarget_session_attrsを満たすものへ接続するdriver.Connector
+type MultiHostConnector struct {
+	attrs TargetSessionAttrs
+	hosts []hostConnector
+}
+
+// hostConnector 候補のサーバー1台分
+type hostConnector struct {
+	endpoint  string
+	connector driver.Connector
+}
+
+// NewMultiHostConnector libpq形式（key=value またはURL）の複数ホスト接続文字列からConnectorを作成。
+// portが1つの場合は全ホスト共通とする
+func NewMultiHostConnector(dsn string) (*MultiHostConnector, error) {
+	opts, err := parseMultiHostDSN(dsn)
+	if err != nil {
+		return nil, err
+	}
+	attrs, err := ParseTargetSessionAttrs(opts["target_session_attrs"])
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", MultiHostDriverName, err)
+	}
+	delete(opts, "target_session_attrs")
+
+	hosts := strings.Split(opts["host"], ",")
+	ports := strings.Split(opts["port"], ",")
+	if len(ports) != 1 && len(ports) != len(hosts) {
+		return nil, fmt.Errorf("%s: host と port の数が一致しません", MultiHostDriverName)
+	}
+
+	c := &MultiHostConnector{attrs: attrs}
+	for i, host := range hosts {
+		hostOpts := make(map[string]string, len(opts))
+		for k, v := range opts {
+			hostOpts[k] = v
+		}
+		hostOpts["host"] = host
+		port := ports[min(i, len(ports)-1)]
+		if port != "" {
+			hostOpts["port"] = port
+		}
+		connector, err := pq.NewConnector(formatDSN(hostOpts))
+		if err != nil {
+			return nil, err
+		}
+		endpoint := host
+		if port != "" {
+			endpoint += ":" + port
+		}
+		c.hosts = append(c.hosts, hostConnector{endpoint: endpoint, connector: connector})
+	}
+	return c, nil
+}
+
+// NewMultiHostConnectorFromConfig プライマリ、スタンバイの順に全ノードを候補とするConnectorを作成。
+// 各ノードへはNewCredentialConnectorで接続するため、Config.Credentialsによる認証情報の取得と
+// 認証失敗時の再取得、PrimaryTLS・スタンバイごとのTLS設定が、単一ノードへの接続と同じように適用される
+func NewMultiHostConnectorFromConfig(cfg Config, attrs TargetSessionAttrs) *MultiHostConnector {
+	c := &MultiHostConnector{attrs: attrs}
+	for _, e := range cfg.endpoints() {
+		c.hosts = append(c.hosts, hostConnector{endpoint: e.String(), connector: NewCredentialConnector(cfg, e)})
+	}
+	return c
+}
+
+// parseMultiHostDSN 複数ホストを含む接続文字列をパースする。
+// URL形式の postgres://user@a:5432,b:5433/db はlib/pqが解釈できないため、
+// ホスト部分を取り出してから残りをパースする
+func parseMultiHostDSN(dsn string) (map[string]string, error) {
+	scheme, rest, isURL := strings.Cut(dsn, "://")
+	if !isURL || (scheme != "postgres" && scheme != "postgresql") {
+		return parseDSN(dsn)
+	}
+
+	end := strings.IndexAny(rest, "/?")
+	if end < 0 {
+		end = len(rest)
+	}
+	authority := rest[:end]
+	userinfo, hostList := "", authority
+	if at := strings.LastIndex(authority, "@"); at >= 0 {
+		userinfo, hostList = authority[:at+1], authority[at+1:]
+	}
+
+	var hosts, ports []string
+	for _, hp := range strings.Split(hostList, ",") {
+		host, port := hp, ""
+		// [::1]:5432 のようなIPv6アドレスに対応する
+		if i := strings.LastIndex(hp, ":"); i >= 0 && i > strings.LastIndex(hp, "]") {
+			host, port = hp[:i], hp[i+1:]
+		}
+		hosts = append(hosts, strings.Trim(host, "[]"))
+		ports = append(ports, port)
+	}
+
+	opts, err := parseDSN(scheme + "://" + userinfo + "localhost" + rest[end:])
+	if err != nil {
+		return nil, err
+	}
+	opts["host"] = strings.Join(hosts, ",")
+	delete(opts, "port")
+	// ポートの指定が全くなければlibpqのデフォルトに任せる
+	if strings.Join(ports, "") != "" {
+		opts["port"] = strings.Join(ports, ",")
+	}
+	return opts, nil
+}
+
+// Connect 指定順にサーバーへ接続し、target_session_attrsを満たす最初の接続を返す。
+// prefer-standbyでスタンバイがない場合は、接続できた最初のサーバーを使う
+func (c *MultiHostConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	var fallback driver.Conn
+	var errs []error
+	for _, h := range c.hosts {
+		conn, err := h.connector.Connect(ctx)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", h.endpoint, err))
+			continue
+		}
+		if c.attrs == SessionAny {
+			return conn, nil
+		}
+
+		inRecovery, readOnly, err := sessionState(ctx, conn)
+		switch {
+		case err != nil:
+			errs = append(errs, fmt.Errorf("%s: サーバー種別確認エラー: %w", h.endpoint, err))
+		case c.attrs.accepts(inRecovery, readOnly):
+			if fallback != nil {
+				_ = fallback.Close()
+			}
+			return conn, nil
+		case c.attrs == SessionPreferStandby && fallback == nil:
+			fallback = conn
+			continue
+		default:
+			errs = append(errs, fmt.Errorf("%s: target_session_attrs=%s を満たしません", h.endpoint, c.attrs))
+		}
+		_ = conn.Close()
+	}
+	if fallback != nil {
+		return fallback, nil
+	}
+	if ctx.Err() != nil {
+		errs = append(errs, ctx.Err())
+	}
+	return nil, fmt.Errorf("%w: target_session_attrs=%s を満たすサーバーに接続できません: %w",
+		c.attrs.kind(), c.attrs, errors.Join(errs...))
+}
+
+// Driver 登録済みの複数ホストドライバーを返す
+func (c *MultiHostConnector) Driver() driver.Driver {
+	return &MultiHostDriver{}
+}
+
+// sessionState 物理接続でリカバリ中か、読み取り専用かを確認する
+func sessionState(ctx context.Context, conn driver.Conn) (inRecovery, readOnly bool, err error) {
+	dest, err := queryRowOn(ctx, conn, sessionStateQuery)
+	if err != nil {
+		return false, false, err
+	}
+	inRecovery, ok1 := dest[0].(bool)
+	readOnly, ok2 := dest[1].(bool)
+	if !ok1 || !ok2 {
+		return false, false, fmt.Errorf("サーバー種別の型が不正です: %T, %T", dest[0], dest[1])
+	}
+	return inRecovery, readOnly, nil
+}
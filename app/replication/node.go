@@ -0,0 +1,139 @@
+package replication
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Node スタンバイノード1台分の接続と状態
+type Node struct {
+	Name     string
+	Endpoint Endpoint
+	Weight   int
+	Zone     string
+	DB       *sql.DB
+
+	// MaxStaleness 読み取りで許容する再生遅延（0の場合は判定しない）
+	MaxStaleness time.Duration
+	// MaxLagBytes 読み取りで許容するWAL位置の差（0の場合は判定しない）
+	MaxLagBytes int64
+
+	// healthy ヘルスチェックの判定結果（ダウン中はfalse）
+	healthy atomic.Bool
+
+	mu            sync.Mutex
+	lag           time.Duration
+	lagMeasuredAt time.Time
+}
+
+// newNode スタンバイ設定からノードを作成（接続は行わない）
+func newNode(cfg Config, sc StandbyConfig) *Node {
+	db := openPool(cfg, sc.Endpoint)
+	configurePool(db, cfg.standbyPool(sc))
+	n := nodeFromConfig(sc, db)
+	n.healthy.Store(true)
+	return n
+}
+
+// nodeFromConfig スタンバイ設定とコネクションプールからノードを組み立てる
+func nodeFromConfig(sc StandbyConfig, db *sql.DB) *Node {
+	name := sc.Name
+	if name == "" {
+		name = sc.Endpoint.String()
+	}
+	weight := sc.Weight
+	if weight <= 0 {
+		weight = 1
+	}
+	return &Node{
+		Name: name, Endpoint: sc.Endpoint, Weight: weight, Zone: sc.Zone, DB: db,
+		MaxStaleness: sc.MaxStaleness, MaxLagBytes: sc.MaxLagBytes,
+	}
+}
+
+// String ノード名を返す
+func (n *Node) String() string {
+	return n.Name
+}
+
+// Healthy ヘルスチェックでダウンと判定されていなければtrue
+func (n *Node) Healthy() bool {
+	return n.healthy.Load()
+}
+
+// InUse 使用中の接続数
+func (n *Node) InUse() int {
+	return n.DB.Stats().InUse
+}
+
+// ReplayLag スタンバイの再生遅延を計測し、結果を記録する
+func (n *Node) ReplayLag() (time.Duration, error) {
+	return n.ReplayLagContext(context.Background())
+}
+
+// ReplayLagContext ctxに従って中断できるReplayLag
+func (n *Node) ReplayLagContext(ctx context.Context) (time.Duration, error) {
+	var seconds float64
+	if err := n.DB.QueryRowContext(ctx, standbyReplayLagQuery).Scan(&seconds); err != nil {
+		return 0, n.nodeError("再生遅延取得", err)
+	}
+	lag := time.Duration(seconds * float64(time.Second))
+
+	n.mu.Lock()
+	n.lag = lag
+	n.lagMeasuredAt = time.Now()
+	n.mu.Unlock()
+	return lag, nil
+}
+
+// LastReplayLag 最後に計測した再生遅延と計測時刻を返す（未計測の場合は時刻がゼロ値）
+func (n *Node) LastReplayLag() (time.Duration, time.Time) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.lag, n.lagMeasuredAt
+}
+
+// ReplayLSN スタンバイの再生済みWAL位置を取得（リカバリ中でない場合は0）
+func (n *Node) ReplayLSN() (LSN, error) {
+	return n.ReplayLSNContext(context.Background())
+}
+
+// ReplayLSNContext ctxに従って中断できるReplayLSN
+func (n *Node) ReplayLSNContext(ctx context.Context) (LSN, error) {
+	var replayed LSN
+	if err := n.DB.QueryRowContext(ctx, "SELECT pg_last_wal_replay_lsn()").Scan(&replayed); err != nil {
+		return 0, n.nodeError("WAL再生位置取得", err)
+	}
+	return replayed, nil
+}
+
+// WaitForLSN スタンバイが指定LSNまでWALを再生するまで待機
+func (n *Node) WaitForLSN(lsn LSN, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	return n.WaitForLSNContext(ctx, lsn)
+}
+
+// WaitForLSNContext スタンバイが指定LSNまでWALを再生するまで、ctxが終了するまで待機。
+// 待機が打ち切られた場合のエラーはErrReplayTimeoutとErrLagExceededの両方に該当する。
+// 昇格済みなどでスタンバイとして動作していない場合は、待たずにErrStandbyUnavailableを返す
+func (n *Node) WaitForLSNContext(ctx context.Context, lsn LSN) error {
+	err := waitForReplay(ctx, n.DB, lsn)
+	switch {
+	case errors.Is(err, ErrReplayTimeout):
+		return &NodeError{Op: "LSN待機", Route: RouteStandby, Node: n.Name, Endpoint: n.Endpoint, Kind: ErrLagExceeded, Err: err}
+	case errors.Is(err, errNotInRecovery):
+		return &NodeError{Op: "LSN待機", Route: RouteStandby, Node: n.Name, Endpoint: n.Endpoint, Kind: ErrStandbyUnavailable, Err: err}
+	}
+	return n.nodeError("LSN待機", err)
+}
+
+// standbyReplayLagQuery スタンバイでの再生遅延（秒）。受信済みWALを全て再生済みなら0
+const standbyReplayLagQuery = `SELECT CASE
+	WHEN pg_last_wal_receive_lsn() = pg_last_wal_replay_lsn() THEN 0
+	ELSE COALESCE(EXTRACT(EPOCH FROM (now() - pg_last_xact_replay_timestamp())), 0)
+END`
@@ -0,0 +1,141 @@
+package replication
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// 組み込みのルーティングポリシー名
+const (
+	PolicyRoundRobin       = "round-robin"
+	PolicyWeighted         = "weighted"
+	PolicyLeastConnections = "least-connections"
+	PolicyLeastLag         = "least-lag"
+)
+
+// RoutingPolicy 読み取りに使うスタンバイを候補から選ぶ。
+// 独自のポリシーを実装してCluster.SetRoutingPolicyで差し替えられる。
+// Pickは複数のgoroutineから同時に呼ばれる
+type RoutingPolicy interface {
+	// Pick 候補から1台を選ぶ。候補が空の場合はnilを返す。
+	// ctxは読み取りのctxで、ノードへ問い合わせる場合はこのctxに従って打ち切る
+	Pick(ctx context.Context, candidates []*Node) *Node
+}
+
+// NewRoutingPolicy 名前から組み込みのルーティングポリシーを作成
+func NewRoutingPolicy(name string) (RoutingPolicy, error) {
+	switch name {
+	case "", PolicyRoundRobin:
+		return &RoundRobinPolicy{}, nil
+	case PolicyWeighted:
+		return &WeightedPolicy{}, nil
+	case PolicyLeastConnections:
+		return LeastConnectionsPolicy{}, nil
+	case PolicyLeastLag:
+		return &LeastLagPolicy{}, nil
+	default:
+		return nil, fmt.Errorf("不明なルーティングポリシーです: %s", name)
+	}
+}
+
+// RoundRobinPolicy 候補を順番に選ぶ
+type RoundRobinPolicy struct {
+	next atomic.Uint64
+}
+
+// Pick 次の候補を選ぶ
+func (p *RoundRobinPolicy) Pick(_ context.Context, candidates []*Node) *Node {
+	if len(candidates) == 0 {
+		return nil
+	}
+	i := p.next.Add(1) - 1
+	return candidates[i%uint64(len(candidates))]
+}
+
+// WeightedPolicy Node.Weightに比例した頻度で選ぶ（smooth weighted round-robin）。
+// 候補から外れたノードの累積値は次のPickで破棄する
+type WeightedPolicy struct {
+	mu      sync.Mutex
+	current map[*Node]int
+}
+
+// Pick 重みに従って候補を選ぶ
+func (p *WeightedPolicy) Pick(_ context.Context, candidates []*Node) *Node {
+	if len(candidates) == 0 {
+		return nil
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	// トポロジーの再読み込みで置き換わったノードが残り続けないよう、今回の候補だけを引き継ぐ
+	current := make(map[*Node]int, len(candidates))
+	var best *Node
+	total := 0
+	for _, n := range candidates {
+		current[n] = p.current[n] + n.Weight
+		total += n.Weight
+		if best == nil || current[n] > current[best] {
+			best = n
+		}
+	}
+	current[best] -= total
+	p.current = current
+	return best
+}
+
+// LeastConnectionsPolicy 使用中の接続数が最も少ない候補を選ぶ
+type LeastConnectionsPolicy struct{}
+
+// Pick 使用中の接続数が最も少ない候補を選ぶ
+func (LeastConnectionsPolicy) Pick(_ context.Context, candidates []*Node) *Node {
+	var best *Node
+	bestInUse := 0
+	for _, n := range candidates {
+		inUse := n.InUse()
+		if best == nil || inUse < bestInUse {
+			best, bestInUse = n, inUse
+		}
+	}
+	return best
+}
+
+// defaultLagMaxAge LeastLagPolicyが計測済みの遅延を再利用する期間
+const defaultLagMaxAge = time.Second
+
+// LeastLagPolicy 再生遅延が最も小さい候補を選ぶ。
+// 計測結果がMaxAgeより古いノードはPick時に読み取りのctxで再計測する
+type LeastLagPolicy struct {
+	// MaxAge 計測済みの遅延を再利用する期間（0の場合は1秒）
+	MaxAge time.Duration
+}
+
+// Pick 再生遅延が最も小さい候補を選ぶ。計測に失敗したノードは選ばない
+func (p *LeastLagPolicy) Pick(ctx context.Context, candidates []*Node) *Node {
+	maxAge := p.MaxAge
+	if maxAge <= 0 {
+		maxAge = defaultLagMaxAge
+	}
+
+	var best *Node
+	var bestLag time.Duration
+	for _, n := range candidates {
+		lag, measuredAt := n.LastReplayLag()
+		if time.Since(measuredAt) > maxAge {
+			var err error
+			if lag, err = n.ReplayLagContext(ctx); err != nil {
+				continue
+			}
+		}
+		if best == nil || lag < bestLag {
+			best, bestLag = n, lag
+		}
+	}
+	if best == nil && len(candidates) > 0 {
+		return candidates[0]
+	}
+	return best
+}
@@ -0,0 +1,91 @@
+package replication
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"testing"
+	"time"
+)
+
+// TestRoundRobinPolicy ラウンドロビンの選択順テスト
+func TestRoundRobinPolicy(t *testing.T) {
+	nodes := []*Node{{Name: "a"}, {Name: "b"}, {Name: "c"}}
+	p := &RoundRobinPolicy{}
+
+	for i, want := range []string{"a", "b", "c", "a"} {
+		if got := p.Pick(context.Background(), nodes); got.Name != want {
+			t.Fatalf("%d回目の選択が不正: %s, want %s", i+1, got, want)
+		}
+	}
+	if p.Pick(context.Background(), nil) != nil {
+		t.Fatal("候補が空の場合はnilを返すべき")
+	}
+}
+
+// TestWeightedPolicy 重み付き選択の分布テスト
+func TestWeightedPolicy(t *testing.T) {
+	nodes := []*Node{{Name: "heavy", Weight: 3}, {Name: "light", Weight: 1}}
+	p := &WeightedPolicy{}
+
+	counts := map[string]int{}
+	for i := 0; i < 40; i++ {
+		counts[p.Pick(context.Background(), nodes).Name]++
+	}
+	if counts["heavy"] != 30 || counts["light"] != 10 {
+		t.Fatalf("重みに従った分布になっていない: %v", counts)
+	}
+
+	// 置き換わった候補の累積値は残さない
+	replaced := []*Node{{Name: "heavy", Weight: 3}, {Name: "light", Weight: 1}}
+	p.Pick(context.Background(), replaced)
+	if len(p.current) != len(replaced) {
+		t.Fatalf("候補から外れたノードの累積値が残っている: %d件", len(p.current))
+	}
+	for n := range p.current {
+		if n != replaced[0] && n != replaced[1] {
+			t.Fatalf("古いノードの累積値が残っている: %s", n)
+		}
+	}
+}
+
+// TestNewRoutingPolicy ポリシー名からの作成テスト
+func TestNewRoutingPolicy(t *testing.T) {
+	for _, name := range []string{"", PolicyRoundRobin, PolicyWeighted, PolicyLeastConnections, PolicyLeastLag} {
+		if _, err := NewRoutingPolicy(name); err != nil {
+			t.Errorf("%q: %v", name, err)
+		}
+	}
+	if _, err := NewRoutingPolicy("random"); err == nil {
+		t.Fatal("不明なポリシー名でエラーにならない")
+	}
+}
+
+// TestLeastLagPolicyContext 計測の古いノードの再計測が読み取りのctxで打ち切られるテスト
+func TestLeastLagPolicyContext(t *testing.T) {
+	stalled := &Node{Name: "stalled", Weight: 1, DB: sql.OpenDB(stalledConnector{})}
+	defer func() { _ = stalled.DB.Close() }()
+	measured := &Node{Name: "measured", Weight: 1}
+	measured.lag, measured.lagMeasuredAt = 2*time.Second, time.Now()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	got := (&LeastLagPolicy{MaxAge: time.Minute}).Pick(ctx, []*Node{stalled, measured})
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("ctxの期限で打ち切られていない: %s", elapsed)
+	}
+	if got != measured {
+		t.Fatalf("計測できたノードを選ぶはず: %v", got)
+	}
+}
+
+// stalledConnector ctxが終了するまで接続が完了しないテスト用Connector
+type stalledConnector struct{}
+
+func (stalledConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func (stalledConnector) Driver() driver.Driver { return nil }
@@ -0,0 +1,324 @@
+package replication
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// PrometheusContentType Prometheusのテキスト形式のContent-Type
+const PrometheusContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// defaultScrapeTimeout MetricsHandlerでの1回の収集のタイムアウト
+const defaultScrapeTimeout = 5 * time.Second
+
+// MetricsOption MetricsHandler・WritePrometheusの出力設定
+type MetricsOption func(*metricsOptions)
+
+// metricsOptions MetricsOptionを適用した結果
+type metricsOptions struct {
+	published       bool
+	publishedMaxAge time.Duration
+}
+
+// WithPublishedRoutingCounters 読み取り回数・フォールバック回数・リカバリ競合とリトライの回数を、このプロセスの値ではなく
+// 各プロセスがStartMetricsPublisherで書き込んだ値から instance ラベル付きで出力する。
+// maxAge以上更新のないプロセスは出力しない（0の場合は5分）。読み取りを処理しないexporterで使う
+func WithPublishedRoutingCounters(maxAge time.Duration) MetricsOption {
+	return func(o *metricsOptions) {
+		o.published = true
+		o.publishedMaxAge = maxAge
+	}
+}
+
+// MetricsHandler /metrics 用のhttp.Handler。リクエストごとにプライマリ・スタンバイの状態を問い合わせ、
+// Prometheusのテキスト形式で返す。読み取り回数などのカウンタはこのClusterで処理した分を出力する
+// （読み取りを処理しないプロセスではWithPublishedRoutingCountersを指定する）
+func (c *Cluster) MetricsHandler(opts ...MetricsOption) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), defaultScrapeTimeout)
+		defer cancel()
+
+		var buf bytes.Buffer
+		if err := c.WritePrometheus(ctx, &buf, opts...); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", PrometheusContentType)
+		_, _ = w.Write(buf.Bytes())
+	})
+}
+
+// nodeProbe 1台分の疎通確認の結果
+type nodeProbe struct {
+	name       string
+	role       Route
+	db         *sql.DB
+	up         bool
+	inRecovery bool
+	healthy    bool
+}
+
+// WritePrometheus レプリケーションとルーティングの状態をPrometheusのテキスト形式でwに書き込む。
+// ノードへの問い合わせの失敗はエラーにせず、pgrepl_node_up や pgrepl_collector_success に0として出力する
+func (c *Cluster) WritePrometheus(ctx context.Context, w io.Writer, opts ...MetricsOption) error {
+	var o metricsOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	probes := []*nodeProbe{{name: RoutePrimary.String(), role: RoutePrimary, db: c.Primary, healthy: true}}
+	for _, n := range c.StandbyNodes() {
+		probes = append(probes, &nodeProbe{name: n.Name, role: RouteStandby, db: n.DB, healthy: n.Healthy()})
+	}
+
+	var wg sync.WaitGroup
+	for _, p := range probes {
+		wg.Add(1)
+		go func(p *nodeProbe) {
+			defer wg.Done()
+			p.up = p.db.QueryRowContext(ctx, "SELECT pg_is_in_recovery()").Scan(&p.inRecovery) == nil
+		}(p)
+	}
+	statuses, statusErr := c.StandbyStatusesContext(ctx)
+	slots, slotErr := c.ReplicationSlotsContext(ctx)
+	wg.Wait()
+
+	// ハートビートを書き込んでいる場合は、スタンバイから見たハートビートの遅延も出力する
+	type heartbeatLag struct {
+		node string
+		lag  time.Duration
+	}
+	var heartbeats []heartbeatLag
+	if c.heartbeatRunning() {
+		for _, n := range c.StandbyNodes() {
+			if lag, _, err := n.HeartbeatLagContext(ctx); err == nil {
+				heartbeats = append(heartbeats, heartbeatLag{n.Name, lag})
+			}
+		}
+	}
+
+	// 読み取りのカウンタはこのプロセスの値か、各プロセスが書き込んだ値
+	instances := []InstanceMetrics{{Metrics: c.Metrics()}}
+	var instancesErr error
+	if o.published {
+		instances, instancesErr = c.InstanceMetricsContext(ctx, o.publishedMaxAge)
+	}
+
+	pw := &promWriter{}
+
+	pw.family("pgrepl_node_up", "gauge", "ノードに接続できるか（1: 接続可能）")
+	for _, p := range probes {
+		pw.sample("pgrepl_node_up", boolValue(p.up), "node", p.name, "role", p.role.String())
+	}
+	pw.family("pgrepl_node_in_recovery", "gauge", "pg_is_in_recovery()（1: スタンバイとして動作中）。接続できないノードは出力しない")
+	for _, p := range probes {
+		if p.up {
+			pw.sample("pgrepl_node_in_recovery", boolValue(p.inRecovery), "node", p.name, "role", p.role.String())
+		}
+	}
+	pw.family("pgrepl_node_healthy", "gauge", "ヘルスチェックの判定（1: 読み取り対象）")
+	for _, p := range probes {
+		if p.role == RouteStandby {
+			pw.sample("pgrepl_node_healthy", boolValue(p.healthy), "node", p.name, "role", p.role.String())
+		}
+	}
+
+	pw.family("pgrepl_collector_success", "gauge", "プライマリからの状態取得に成功したか")
+	pw.sample("pgrepl_collector_success", boolValue(statusErr == nil), "collector", "pg_stat_replication")
+	pw.sample("pgrepl_collector_success", boolValue(slotErr == nil), "collector", "pg_replication_slots")
+	if o.published {
+		pw.sample("pgrepl_collector_success", boolValue(instancesErr == nil), "collector", RoutingMetricsTable)
+	}
+
+	pw.family("pgrepl_standby_lag_bytes", "gauge", "プライマリの現在のWAL位置とスタンバイの再生位置の差")
+	for _, s := range statuses {
+		pw.sample("pgrepl_standby_lag_bytes", float64(s.LagBytes), standbyLabels(s)...)
+	}
+	for _, lag := range []struct {
+		name, help string
+		value      func(StandbyStatus) time.Duration
+	}{
+		{"pgrepl_standby_write_lag_seconds", "pg_stat_replicationのwrite_lag", func(s StandbyStatus) time.Duration { return s.WriteLag }},
+		{"pgrepl_standby_flush_lag_seconds", "pg_stat_replicationのflush_lag", func(s StandbyStatus) time.Duration { return s.FlushLag }},
+		{"pgrepl_standby_replay_lag_seconds", "pg_stat_replicationのreplay_lag", func(s StandbyStatus) time.Duration { return s.ReplayLag }},
+	} {
+		pw.family(lag.name, "gauge", lag.help)
+		for _, s := range statuses {
+			pw.sample(lag.name, lag.value(s).Seconds(), standbyLabels(s)...)
+		}
+	}
+	pw.family("pgrepl_standby_heartbeat_lag_seconds", "gauge", "スタンバイに見えている最新のハートビートからの経過時間（StartHeartbeat実行中のみ）")
+	for _, h := range heartbeats {
+		pw.sample("pgrepl_standby_heartbeat_lag_seconds", h.lag.Seconds(), "node", h.node, "role", RouteStandby.String())
+	}
+	pw.family("pgrepl_standby_streaming", "gauge", "WAL送信の状態がstreamingか")
+	for _, s := range statuses {
+		pw.sample("pgrepl_standby_streaming", boolValue(s.Streaming()), standbyLabels(s)...)
+	}
+
+	pw.family("pgrepl_slot_active", "gauge", "レプリケーションスロットが使用中か")
+	for _, s := range slots {
+		pw.sample("pgrepl_slot_active", boolValue(s.Active), "slot", s.Name, "slot_type", s.SlotType)
+	}
+	pw.family("pgrepl_slot_retained_bytes", "gauge", "レプリケーションスロットが保持しているWALの量")
+	for _, s := range slots {
+		pw.sample("pgrepl_slot_retained_bytes", float64(s.RetainedBytes), "slot", s.Name, "slot_type", s.SlotType)
+	}
+	pw.family("pgrepl_slot_safe_wal_size_bytes", "gauge", "WALが削除されるまでの残り（max_slot_wal_keep_size 設定時のみ）")
+	for _, s := range slots {
+		if s.SafeWALSize >= 0 {
+			pw.sample("pgrepl_slot_safe_wal_size_bytes", float64(s.SafeWALSize), "slot", s.Name, "slot_type", s.SlotType)
+		}
+	}
+	pw.family("pgrepl_slot_wal_status", "gauge", "レプリケーションスロットのwal_status（PostgreSQL 13以降）")
+	for _, s := range slots {
+		if s.WALStatus != "" {
+			pw.sample("pgrepl_slot_wal_status", 1, "slot", s.Name, "wal_status", s.WALStatus)
+		}
+	}
+
+	writeRoutingCounters(pw, instances)
+
+	stats := make([]sql.DBStats, len(probes))
+	for i, p := range probes {
+		stats[i] = p.db.Stats()
+	}
+	for _, f := range []struct {
+		name, typ, help string
+		value           func(sql.DBStats) float64
+	}{
+		{"pgrepl_pool_max_open_connections", "gauge", "コネクションプールの最大接続数（0は無制限）", func(s sql.DBStats) float64 { return float64(s.MaxOpenConnections) }},
+		{"pgrepl_pool_open_connections", "gauge", "開いている接続数", func(s sql.DBStats) float64 { return float64(s.OpenConnections) }},
+		{"pgrepl_pool_in_use_connections", "gauge", "使用中の接続数", func(s sql.DBStats) float64 { return float64(s.InUse) }},
+		{"pgrepl_pool_idle_connections", "gauge", "アイドル状態の接続数", func(s sql.DBStats) float64 { return float64(s.Idle) }},
+		{"pgrepl_pool_wait_count_total", "counter", "接続の空きを待った回数", func(s sql.DBStats) float64 { return float64(s.WaitCount) }},
+		{"pgrepl_pool_wait_duration_seconds_total", "counter", "接続の空きを待った合計時間", func(s sql.DBStats) float64 { return s.WaitDuration.Seconds() }},
+		{"pgrepl_pool_max_idle_closed_total", "counter", "SetMaxIdleConnsにより閉じた接続数", func(s sql.DBStats) float64 { return float64(s.MaxIdleClosed) }},
+		{"pgrepl_pool_max_idle_time_closed_total", "counter", "SetConnMaxIdleTimeにより閉じた接続数", func(s sql.DBStats) float64 { return float64(s.MaxIdleTimeClosed) }},
+		{"pgrepl_pool_max_lifetime_closed_total", "counter", "SetConnMaxLifetimeにより閉じた接続数", func(s sql.DBStats) float64 { return float64(s.MaxLifetimeClosed) }},
+	} {
+		pw.family(f.name, f.typ, f.help)
+		for i, p := range probes {
+			pw.sample(f.name, f.value(stats[i]), "node", p.name, "role", p.role.String())
+		}
+	}
+
+	_, err := w.Write(pw.buf.Bytes())
+	return err
+}
+
+// writeRoutingCounters 読み取りのカウンタを書き込む。Instanceが空でなければ instance ラベルを付ける
+func writeRoutingCounters(pw *promWriter, instances []InstanceMetrics) {
+	labels := func(m InstanceMetrics, kv ...string) []string {
+		if m.Instance == "" {
+			return kv
+		}
+		return append([]string{"instance", m.Instance}, kv...)
+	}
+
+	pw.family("pgrepl_reads_total", "counter", "ノードごとの読み取り回数（リトライを含む）")
+	for _, m := range instances {
+		for _, name := range sortedKeys(m.Reads) {
+			role := RouteStandby
+			if name == RoutePrimary.String() {
+				role = RoutePrimary
+			}
+			pw.sample("pgrepl_reads_total", float64(m.Reads[name]), labels(m, "node", name, "role", role.String())...)
+		}
+	}
+	pw.family("pgrepl_primary_fallbacks_total", "counter", "スタンバイではなくプライマリで読み取った回数")
+	for _, m := range instances {
+		for _, reason := range sortedKeys(m.Fallbacks) {
+			pw.sample("pgrepl_primary_fallbacks_total", float64(m.Fallbacks[reason]), labels(m, "reason", string(reason))...)
+		}
+	}
+	for _, f := range []struct {
+		name, help string
+		value      func(Metrics) int64
+	}{
+		{"pgrepl_recovery_conflicts_total", "読み取りで検出したリカバリ競合の回数", func(m Metrics) int64 { return m.RecoveryConflicts }},
+		{"pgrepl_read_retries_total", "リカバリ競合によるリトライの回数", func(m Metrics) int64 { return m.Retries }},
+		{"pgrepl_read_retries_exhausted_total", "リトライの上限に達して失敗した回数", func(m Metrics) int64 { return m.RetriesExhausted }},
+	} {
+		pw.family(f.name, "counter", f.help)
+		for _, m := range instances {
+			pw.sample(f.name, float64(f.value(m.Metrics)), labels(m)...)
+		}
+	}
+}
+
+// standbyLabels pg_stat_replicationの行を識別するラベル。application_nameとclient_addrが同じスタンバイもあるため、WAL送信プロセスのpidも含める
+func standbyLabels(s StandbyStatus) []string {
+	return []string{"application_name", s.ApplicationName, "client_addr", s.ClientAddr, "pid", strconv.Itoa(s.PID), "sync_state", s.SyncState}
+}
+
+// promWriter Prometheusのテキスト形式を組み立てる
+type promWriter struct {
+	buf bytes.Buffer
+}
+
+// family メトリクスのHELPとTYPEを書き込む
+func (p *promWriter) family(name, typ, help string) {
+	fmt.Fprintf(&p.buf, "# HELP %s %s\n# TYPE %s %s\n", name, escapeHelp(help), name, typ)
+}
+
+// sample 値を1行書き込む。labelsは名前と値の組の並び
+func (p *promWriter) sample(name string, value float64, labels ...string) {
+	p.buf.WriteString(name)
+	if len(labels) > 0 {
+		p.buf.WriteByte('{')
+		for i := 0; i+1 < len(labels); i += 2 {
+			if i > 0 {
+				p.buf.WriteByte(',')
+			}
+			fmt.Fprintf(&p.buf, "%s=\"%s\"", labels[i], escapeLabelValue(labels[i+1]))
+		}
+		p.buf.WriteByte('}')
+	}
+	p.buf.WriteByte(' ')
+	p.buf.WriteString(strconv.FormatFloat(value, 'g', -1, 64))
+	p.buf.WriteByte('\n')
+}
+
+// labelValueReplacer ラベル値のエスケープ（バックスラッシュ、ダブルクォート、改行）
+var labelValueReplacer = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// escapeLabelValue ラベル値をエスケープする
+func escapeLabelValue(s string) string {
+	return labelValueReplacer.Replace(s)
+}
+
+// helpReplacer HELP行のエスケープ（バックスラッシュと改行）
+var helpReplacer = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+
+// escapeHelp HELP行の説明文をエスケープする
+func escapeHelp(s string) string {
+	return helpReplacer.Replace(s)
+}
+
+// boolValue trueを1、falseを0に変換
+func boolValue(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// sortedKeys 出力順を固定するためにmapのキーを並べる
+func sortedKeys[K ~string, V any](m map[K]V) []K {
+	keys := make([]K, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i] < keys[j] })
+	return keys
+}
@@ -0,0 +1,93 @@
+package replication
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestMetricsHandler 状態の問い合わせ結果とカウンタをPrometheusのテキスト形式で出力するテスト
+func TestMetricsHandler(t *testing.T) {
+	primary := &tableConnector{queries: map[string][][]driver.Value{
+		"pg_is_in_recovery": {{false}},
+		"pg_stat_replication": {{int64(101), "standby1", "172.18.0.3", "streaming", "async",
+			"0/3000148", "0/3000148", "0/3000148", "0/3000060", int64(232), 0.0, 0.0, 1.5, nil}},
+		"pg_replication_slots": {slotRow("standby_slot", true, 4096, "reserved", nil, nil)},
+	}}
+	c := &Cluster{Primary: sql.OpenDB(primary)}
+	up := &Node{Name: "standby1", Weight: 1, DB: sql.OpenDB(&tableConnector{rows: [][]driver.Value{{true}}})}
+	up.healthy.Store(true)
+	down := &Node{Name: `standby"2`, Weight: 1, DB: sql.OpenDB(&tableConnector{err: errors.New("connection refused")})}
+	c.Standbys = []*Node{up, down}
+	defer c.Close()
+
+	c.metrics.routed(ReadInfo{Name: "standby1"})
+	c.metrics.routed(ReadInfo{Name: "primary", Fallback: FallbackLagExceeded})
+
+	rec := httptest.NewRecorder()
+	c.MetricsHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	if ct := rec.Header().Get("Content-Type"); ct != PrometheusContentType {
+		t.Errorf("Content-Type = %q", ct)
+	}
+	body := rec.Body.String()
+
+	for _, want := range []string{
+		"# TYPE pgrepl_node_up gauge\n",
+		`pgrepl_node_up{node="primary",role="primary"} 1`,
+		`pgrepl_node_up{node="standby1",role="standby"} 1`,
+		`pgrepl_node_up{node="standby\"2",role="standby"} 0`,
+		`pgrepl_node_in_recovery{node="primary",role="primary"} 0`,
+		`pgrepl_node_in_recovery{node="standby1",role="standby"} 1`,
+		`pgrepl_node_healthy{node="standby\"2",role="standby"} 0`,
+		`pgrepl_collector_success{collector="pg_stat_replication"} 1`,
+		`pgrepl_standby_lag_bytes{application_name="standby1",client_addr="172.18.0.3",pid="101",sync_state="async"} 232`,
+		`pgrepl_standby_replay_lag_seconds{application_name="standby1",client_addr="172.18.0.3",pid="101",sync_state="async"} 1.5`,
+		`pgrepl_slot_retained_bytes{slot="standby_slot",slot_type="physical"} 4096`,
+		`pgrepl_slot_wal_status{slot="standby_slot",wal_status="reserved"} 1`,
+		"# TYPE pgrepl_reads_total counter\n",
+		`pgrepl_reads_total{node="primary",role="primary"} 1`,
+		`pgrepl_reads_total{node="standby1",role="standby"} 1`,
+		`pgrepl_primary_fallbacks_total{reason="lag_exceeded"} 1`,
+		`pgrepl_pool_open_connections{node="primary",role="primary"} `,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("出力に %q がありません:\n%s", want, body)
+		}
+	}
+	// 接続できないノードのリカバリ状態と、制限のないsafe_wal_sizeは出力しない
+	for _, unwanted := range []string{`pgrepl_node_in_recovery{node="standby\"2"`, "pgrepl_slot_safe_wal_size_bytes{"} {
+		if strings.Contains(body, unwanted) {
+			t.Errorf("出力に %q が含まれています", unwanted)
+		}
+	}
+
+	// WithPublishedRoutingCountersの場合は各プロセスが書き込んだカウンタをinstanceラベル付きで出力する
+	published, err := json.Marshal(Metrics{Reads: map[string]int64{"standby1": 7}, Retries: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	primary.queries[RoutingMetricsTable] = [][]driver.Value{{"app-1", published, time.Now()}}
+	rec = httptest.NewRecorder()
+	c.MetricsHandler(WithPublishedRoutingCounters(time.Minute)).ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	body = rec.Body.String()
+	for _, want := range []string{
+		`pgrepl_node_up{node="primary",role="primary"} 1`,
+		`pgrepl_collector_success{collector="replication_routing_metrics"} 1`,
+		`pgrepl_reads_total{instance="app-1",node="standby1",role="standby"} 7`,
+		`pgrepl_read_retries_total{instance="app-1"} 2`,
+		`pgrepl_recovery_conflicts_total{instance="app-1"} 0`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("出力に %q がありません:\n%s", want, body)
+		}
+	}
+	// このプロセスのカウンタは出力しない
+	if strings.Contains(body, `pgrepl_reads_total{node=`) {
+		t.Errorf("このプロセスのカウンタが出力されています:\n%s", body)
+	}
+}
@@ -0,0 +1,189 @@
+package replication
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+)
+
+// RoutingMetricsTable 各プロセスの読み取りのカウンタを書き込むテーブル
+const RoutingMetricsTable = "replication_routing_metrics"
+
+// routingMetricsCreateQuery カウンタ用テーブルの作成（scripts/setup-primary.sh でも作成する）
+const routingMetricsCreateQuery = `CREATE TABLE IF NOT EXISTS ` + RoutingMetricsTable + ` (
+	instance text PRIMARY KEY,
+	metrics jsonb NOT NULL,
+	updated_at timestamptz NOT NULL
+)`
+
+// routingMetricsWriteQuery プロセスごとの1行にMetricsの値を上書きする
+const routingMetricsWriteQuery = `INSERT INTO ` + RoutingMetricsTable + ` (instance, metrics, updated_at) VALUES ($1, $2, now())
+ON CONFLICT (instance) DO UPDATE SET metrics = EXCLUDED.metrics, updated_at = EXCLUDED.updated_at`
+
+// routingMetricsReadQuery $1秒以内に更新されたプロセスのカウンタ
+const routingMetricsReadQuery = `SELECT instance, metrics, updated_at FROM ` + RoutingMetricsTable + `
+WHERE updated_at > now() - make_interval(secs => $1) ORDER BY instance`
+
+// defaultRoutingMetricsMaxAge InstanceMetricsで出力する、最後の更新からの経過時間の上限のデフォルト
+const defaultRoutingMetricsMaxAge = 5 * time.Minute
+
+// MetricsPublisherConfig 読み取りのカウンタの書き込み設定
+type MetricsPublisherConfig struct {
+	// Instance プロセスを識別する名前（空の場合は "ホスト名-PID"）。exporterの instance ラベルになる
+	Instance string
+	// Interval 書き込み間隔（0の場合は15秒）
+	Interval time.Duration
+	// Timeout 1回の書き込みのタイムアウト（0の場合はInterval）
+	Timeout time.Duration
+	// OnError 書き込みに失敗したときに呼ばれる（書き込み用goroutineから呼ばれる）
+	OnError func(error)
+}
+
+// MetricsPublisher このClusterのMetricsを一定間隔でプライマリのカウンタ用テーブルに書き込む。
+// 読み取りを処理しないexporterがWithPublishedRoutingCountersで各プロセスのカウンタを出力するために使う
+type MetricsPublisher struct {
+	cluster *Cluster
+	cfg     MetricsPublisherConfig
+	created bool
+
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// InstanceMetrics MetricsPublisherが書き込んだ1プロセス分のカウンタ
+type InstanceMetrics struct {
+	// Instance 書き込んだプロセスの名前
+	Instance string
+	// UpdatedAt 最後に書き込まれた時刻
+	UpdatedAt time.Time
+	Metrics
+}
+
+// StartMetricsPublisher カウンタの書き込みを開始する。Cluster.Closeで停止する
+func (c *Cluster) StartMetricsPublisher(cfg MetricsPublisherConfig) *MetricsPublisher {
+	if cfg.Instance == "" {
+		host, _ := os.Hostname()
+		cfg.Instance = fmt.Sprintf("%s-%d", host, os.Getpid())
+	}
+	if cfg.Interval <= 0 {
+		cfg.Interval = 15 * time.Second
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = cfg.Interval
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	p := &MetricsPublisher{cluster: c, cfg: cfg, cancel: cancel, done: make(chan struct{})}
+	go p.run(ctx)
+
+	c.mu.Lock()
+	c.publishers = append(c.publishers, p)
+	c.mu.Unlock()
+	return p
+}
+
+// Stop 最後のカウンタを書き込んでから停止し、Clusterから登録を外す
+func (p *MetricsPublisher) Stop() {
+	c := p.cluster
+	c.mu.Lock()
+	for i, mp := range c.publishers {
+		if mp == p {
+			c.publishers = append(c.publishers[:i:i], c.publishers[i+1:]...)
+			break
+		}
+	}
+	c.mu.Unlock()
+
+	p.cancel()
+	<-p.done
+}
+
+// run 定期的に書き込む
+func (p *MetricsPublisher) run(ctx context.Context) {
+	defer close(p.done)
+	defer p.publishFinal()
+	ticker := time.NewTicker(p.cfg.Interval)
+	defer ticker.Stop()
+
+	for {
+		writeCtx, cancel := context.WithTimeout(ctx, p.cfg.Timeout)
+		err := p.publish(writeCtx)
+		cancel()
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil && p.cfg.OnError != nil {
+			p.cfg.OnError(err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// publishFinal 停止時にもう一度書き込み、書き込み間隔より短く終了するプロセスのカウンタも残す
+func (p *MetricsPublisher) publishFinal() {
+	ctx, cancel := context.WithTimeout(context.Background(), p.cfg.Timeout)
+	defer cancel()
+	if err := p.publish(ctx); err != nil && p.cfg.OnError != nil {
+		p.cfg.OnError(err)
+	}
+}
+
+// publish テーブルがなければ作成し、現在のカウンタを1回書き込む
+func (p *MetricsPublisher) publish(ctx context.Context) error {
+	data, err := json.Marshal(p.cluster.Metrics())
+	if err != nil {
+		return err
+	}
+	if !p.created {
+		_, err = p.cluster.Primary.ExecContext(ctx, routingMetricsCreateQuery)
+		p.created = err == nil
+	}
+	if err == nil {
+		_, err = p.cluster.Primary.ExecContext(ctx, routingMetricsWriteQuery, p.cfg.Instance, data)
+	}
+	return p.cluster.primaryError("カウンタ書き込み", err)
+}
+
+// InstanceMetrics 各プロセスのMetricsPublisherが書き込んだカウンタのうち、maxAge以内に更新されたもの（0の場合は5分）
+func (c *Cluster) InstanceMetrics(maxAge time.Duration) ([]InstanceMetrics, error) {
+	return c.InstanceMetricsContext(context.Background(), maxAge)
+}
+
+// InstanceMetricsContext ctxに従って中断できるInstanceMetrics。
+// まだどのプロセスも書き込んでおらずテーブルがない場合は空を返す
+func (c *Cluster) InstanceMetricsContext(ctx context.Context, maxAge time.Duration) ([]InstanceMetrics, error) {
+	if maxAge <= 0 {
+		maxAge = defaultRoutingMetricsMaxAge
+	}
+	rows, err := c.Primary.QueryContext(ctx, routingMetricsReadQuery, maxAge.Seconds())
+	if err != nil {
+		if SQLState(err) == "42P01" { // undefined_table
+			return nil, nil
+		}
+		return nil, c.primaryError("カウンタ取得", err)
+	}
+	defer func() { _ = rows.Close() }()
+
+	var result []InstanceMetrics
+	for rows.Next() {
+		var m InstanceMetrics
+		var data []byte
+		if err := rows.Scan(&m.Instance, &data, &m.UpdatedAt); err != nil {
+			return nil, c.primaryError("カウンタ取得", err)
+		}
+		if err := json.Unmarshal(data, &m.Metrics); err != nil {
+			return nil, fmt.Errorf("インスタンス %s のカウンタを解析できません: %w", m.Instance, err)
+		}
+		result = append(result, m)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, c.primaryError("カウンタ取得", err)
+	}
+	return result, nil
+}
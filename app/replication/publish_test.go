@@ -0,0 +1,93 @@
+package replication
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// TestMetricsPublisher カウンタをプライマリに書き込み、停止時にも書き込んで登録を外すテスト
+func TestMetricsPublisher(t *testing.T) {
+	primary := &tableConnector{}
+	c := &Cluster{Primary: sql.OpenDB(primary)}
+	defer c.Close()
+
+	count := func() (creates, writes int) {
+		primary.mu.Lock()
+		defer primary.mu.Unlock()
+		for _, q := range primary.execs {
+			switch {
+			case strings.HasPrefix(q, "CREATE TABLE IF NOT EXISTS "+RoutingMetricsTable):
+				creates++
+			case strings.HasPrefix(q, "INSERT INTO "+RoutingMetricsTable):
+				writes++
+			}
+		}
+		return creates, writes
+	}
+
+	p := c.StartMetricsPublisher(MetricsPublisherConfig{Instance: "app-1", Interval: time.Hour})
+	// 開始時の書き込みを待つ
+	deadline := time.Now().Add(time.Second)
+	for _, writes := count(); writes == 0; _, writes = count() {
+		if time.Now().After(deadline) {
+			t.Fatal("開始時に書き込まれない")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	p.Stop()
+
+	if creates, writes := count(); creates != 1 || writes != 2 {
+		t.Fatalf("テーブル作成1回と、開始時・停止時の書き込み2回のはず: 作成%d回 書き込み%d回", creates, writes)
+	}
+
+	c.mu.Lock()
+	n := len(c.publishers)
+	c.mu.Unlock()
+	if n != 0 {
+		t.Fatalf("停止したMetricsPublisherが登録されたまま: %d件", n)
+	}
+}
+
+// TestInstanceMetrics 各プロセスが書き込んだカウンタを読み取るテスト
+func TestInstanceMetrics(t *testing.T) {
+	data, err := json.Marshal(Metrics{
+		Reads:     map[string]int64{"primary": 3, "standby1": 5},
+		Fallbacks: map[FallbackReason]int64{FallbackLagExceeded: 3},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	updated := time.Date(2024, 5, 1, 9, 0, 0, 0, time.UTC)
+	c := &Cluster{Primary: sql.OpenDB(&tableConnector{rows: [][]driver.Value{{"app-1", data, updated}}})}
+	defer c.Close()
+
+	got, err := c.InstanceMetrics(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 || got[0].Instance != "app-1" || !got[0].UpdatedAt.Equal(updated) ||
+		got[0].Reads["standby1"] != 5 || got[0].Fallbacks[FallbackLagExceeded] != 3 {
+		t.Fatalf("読み取ったカウンタが不正: %+v", got)
+	}
+
+	// まだ誰も書き込んでおらずテーブルがない場合は空
+	missing := &Cluster{Primary: sql.OpenDB(&tableConnector{err: &pq.Error{Code: "42P01"}})}
+	defer missing.Close()
+	if got, err := missing.InstanceMetrics(0); err != nil || len(got) != 0 {
+		t.Fatalf("テーブルがない場合は空のはず: %v, %v", got, err)
+	}
+
+	failing := &Cluster{Primary: sql.OpenDB(&tableConnector{err: errors.New("permission denied")})}
+	defer failing.Close()
+	var ne *NodeError
+	if _, err := failing.InstanceMetrics(0); !errors.As(err, &ne) || ne.Route != RoutePrimary {
+		t.Fatalf("プライマリのエラーを返すはず: %v", err)
+	}
+}
@@ -0,0 +1,309 @@
+package replication
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// readOptions 読み取り時の一貫性オプション
+type readOptions struct {
+	afterLSN        LSN
+	waitTimeout     time.Duration
+	primaryFallback bool
+	standbyOnly     bool
+
+	maxStaleness    time.Duration
+	hasMaxStaleness bool
+	maxLagBytes     int64
+	hasMaxLagBytes  bool
+
+	retry RetryPolicy
+	// exclude リカバリ競合のためリトライ時に除外するスタンバイ
+	exclude []*Node
+
+	info *ReadInfo
+}
+
+// ReadOption 読み取り系メソッドのオプション
+type ReadOption func(*readOptions)
+
+// AfterLSN 書き込み時に返されたLSNまでスタンバイが再生してから読み取る（read-your-writes）
+func AfterLSN(lsn LSN) ReadOption {
+	return func(o *readOptions) {
+		if lsn > o.afterLSN {
+			o.afterLSN = lsn
+		}
+	}
+}
+
+// WithWaitTimeout LSN待機のタイムアウトを指定（省略時はConfig.ReplayWaitTimeout）
+func WithWaitTimeout(d time.Duration) ReadOption {
+	return func(o *readOptions) {
+		o.waitTimeout = d
+	}
+}
+
+// WithPrimaryFallback LSN待機がタイムアウトした場合と、待機したノードがスタンバイでなかった場合にプライマリから読み取る
+func WithPrimaryFallback() ReadOption {
+	return func(o *readOptions) {
+		o.primaryFallback = true
+	}
+}
+
+// StandbyOnly プライマリでは読み取らない。スタンバイを利用できない場合や許容遅延を超えている場合は
+// ErrStandbyUnavailable / ErrLagExceeded を返す（WithPrimaryFallbackより優先される）
+func StandbyOnly() ReadOption {
+	return func(o *readOptions) {
+		o.standbyOnly = true
+	}
+}
+
+// WithMaxStaleness スタンバイの再生遅延（時間）がdを超えている場合はプライマリから読み取る
+func WithMaxStaleness(d time.Duration) ReadOption {
+	return func(o *readOptions) {
+		o.maxStaleness = d
+		o.hasMaxStaleness = true
+	}
+}
+
+// WithMaxLagBytes スタンバイの再生位置がプライマリのWAL位置からnバイト以上遅れている場合はプライマリから読み取る
+func WithMaxLagBytes(n int64) ReadOption {
+	return func(o *readOptions) {
+		o.maxLagBytes = n
+		o.hasMaxLagBytes = true
+	}
+}
+
+// ServedBy 読み取りを処理したノードの情報をinfoに記録する
+func ServedBy(info *ReadInfo) ReadOption {
+	return func(o *readOptions) {
+		o.info = info
+	}
+}
+
+// ReadInfo 読み取りを処理したノードと、その判断理由
+type ReadInfo struct {
+	// Route 読み取りを処理したノードの種別
+	Route Route
+	// Name 読み取りを処理したノード名（プライマリの場合は "primary"）
+	Name string
+	// Node 読み取りを処理したノードの接続先
+	Node Endpoint
+	// Reason プライマリで処理した場合の理由（スタンバイで処理した場合は空）
+	Reason string
+	// Lag 判定に使ったスタンバイの再生遅延（時間）。計測していない場合は0
+	Lag time.Duration
+	// LagBytes 判定に使ったスタンバイの再生遅延（バイト）。計測していない場合は0
+	LagBytes int64
+	// Retries リカバリ競合によりリトライした回数
+	Retries int
+	// Fallback スタンバイではなくプライマリで処理した場合の理由の分類（スタンバイで処理した場合は空）
+	Fallback FallbackReason
+}
+
+// newReadOptions 設定のデフォルト値にオプションを適用する
+func (c *Cluster) newReadOptions(opts []ReadOption) readOptions {
+	cfg := c.config()
+	o := readOptions{waitTimeout: cfg.ReplayWaitTimeout, retry: cfg.Retry}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.waitTimeout <= 0 {
+		o.waitTimeout = defaultReplayWaitTimeout
+	}
+	return o
+}
+
+// readDBWith オプションに従って読み取りに使う接続を選ぶ
+func (c *Cluster) readDBWith(ctx context.Context, o *readOptions) (*sql.DB, ReadInfo, error) {
+	var info ReadInfo
+	node, err := c.chooseStandby(ctx, o, &info)
+	if err != nil {
+		return nil, info, err
+	}
+
+	db := c.Primary
+	if node != nil {
+		db = node.DB
+		info.Route, info.Name, info.Node = RouteStandby, node.Name, node.Endpoint
+	} else {
+		info.Route, info.Name, info.Node = RoutePrimary, RoutePrimary.String(), c.config().Primary
+	}
+	return db, info, nil
+}
+
+// chooseStandby LSN待機と許容遅延の判定を行い、読み取りに使うスタンバイを選ぶ。
+// プライマリで読み取るべき場合はnilを返し、理由をinfoに記録する
+func (c *Cluster) chooseStandby(ctx context.Context, o *readOptions, info *ReadInfo) (*Node, error) {
+	if key, ok := c.sticky.active(ctx); ok && !o.standbyOnly {
+		info.Reason = fmt.Sprintf("セッション %s は書き込み直後のためプライマリに固定", key)
+		info.Fallback = FallbackStickySession
+		return nil, nil
+	}
+
+	candidates := c.HealthyStandbys()
+	if len(candidates) == 0 {
+		if o.standbyOnly {
+			return nil, &NodeError{Op: "スタンバイ選択", Route: RouteStandby, Node: RouteStandby.String(), Kind: ErrStandbyUnavailable}
+		}
+		info.Reason = ErrStandbyUnavailable.Error()
+		info.Fallback = FallbackStandbyUnavailable
+		return nil, nil
+	}
+	if len(o.exclude) > 0 {
+		remaining := excludeNodes(candidates, o.exclude)
+		if len(remaining) == 0 && !o.standbyOnly {
+			info.Reason = "リカバリ競合のためプライマリで再試行"
+			info.Fallback = FallbackRecoveryConflict
+			return nil, nil
+		}
+		// StandbyOnly指定時は他に候補がなければ同じスタンバイで再試行する
+		if len(remaining) > 0 {
+			candidates = remaining
+		}
+	}
+
+	if o.afterLSN != 0 {
+		node := c.pickStandby(ctx, candidates)
+		waitCtx, cancel := context.WithTimeout(ctx, o.waitTimeout)
+		defer cancel()
+		err := node.WaitForLSNContext(waitCtx, o.afterLSN)
+		if err != nil {
+			// 呼び出し元がキャンセルした場合はフォールバックせずに中断する
+			if o.primaryFallback && !o.standbyOnly && ctx.Err() == nil {
+				switch {
+				case errors.Is(err, ErrReplayTimeout):
+					info.Reason = err.Error()
+					info.Fallback = FallbackReplayTimeout
+					return nil, nil
+				case errors.Is(err, ErrStandbyUnavailable):
+					info.Reason = err.Error()
+					info.Fallback = FallbackStandbyUnavailable
+					return nil, nil
+				}
+			}
+			return nil, err
+		}
+		// 待機したノードで許容遅延も判定する
+		candidates = []*Node{node}
+	}
+
+	var lastErr error
+	for len(candidates) > 0 {
+		node := c.pickStandby(ctx, candidates)
+		err := c.checkStaleness(ctx, node, o, info)
+		if err == nil {
+			return node, nil
+		}
+		info.Reason = err.Error()
+		lastErr = err
+
+		// 許容遅延を超えたノードを除いて再選択
+		candidates = excludeNodes(candidates, []*Node{node})
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if o.standbyOnly {
+		return nil, lastErr
+	}
+	info.Fallback = FallbackLagExceeded
+	if !errors.Is(lastErr, ErrLagExceeded) {
+		// 遅延を計測できなかった
+		info.Fallback = FallbackStandbyUnavailable
+	}
+	return nil, nil
+}
+
+// excludeNodes candidatesからexcludeに含まれるノードを除いた新しいスライスを返す
+func excludeNodes(candidates, exclude []*Node) []*Node {
+	remaining := make([]*Node, 0, len(candidates))
+	for _, n := range candidates {
+		excluded := false
+		for _, e := range exclude {
+			if n == e {
+				excluded = true
+				break
+			}
+		}
+		if !excluded {
+			remaining = append(remaining, n)
+		}
+	}
+	return remaining
+}
+
+// checkStaleness ノードの遅延が許容範囲内か判定する。許容できない場合はErrLagExceededに該当するエラー、
+// 遅延を計測できない場合はその原因を返す。オプションの指定がなければノードの許容値を使う
+func (c *Cluster) checkStaleness(ctx context.Context, node *Node, o *readOptions, info *ReadInfo) error {
+	maxStaleness, hasMaxStaleness := o.maxStaleness, o.hasMaxStaleness
+	if !hasMaxStaleness && node.MaxStaleness > 0 {
+		maxStaleness, hasMaxStaleness = node.MaxStaleness, true
+	}
+	maxLagBytes, hasMaxLagBytes := o.maxLagBytes, o.hasMaxLagBytes
+	if !hasMaxLagBytes && node.MaxLagBytes > 0 {
+		maxLagBytes, hasMaxLagBytes = node.MaxLagBytes, true
+	}
+
+	if hasMaxStaleness {
+		lag, err := node.ReplayLagContext(ctx)
+		if err != nil {
+			return err
+		}
+		info.Lag = lag
+		if lag > maxStaleness {
+			return &NodeError{
+				Op: "許容遅延判定", Route: RouteStandby, Node: node.Name, Endpoint: node.Endpoint,
+				Kind: ErrLagExceeded, Lag: lag,
+				Err: fmt.Errorf("再生遅延 %s が許容値 %s を超過", lag, maxStaleness),
+			}
+		}
+	}
+
+	if hasMaxLagBytes {
+		lagBytes, err := c.ReplayLagBytesContext(ctx, node)
+		if err != nil {
+			return err
+		}
+		info.LagBytes = lagBytes
+		if lagBytes > maxLagBytes {
+			return &NodeError{
+				Op: "許容遅延判定", Route: RouteStandby, Node: node.Name, Endpoint: node.Endpoint,
+				Kind: ErrLagExceeded, Lag: info.Lag, LagBytes: lagBytes,
+				Err: fmt.Errorf("再生遅延 %dバイト が許容値 %dバイト を超過", lagBytes, maxLagBytes),
+			}
+		}
+	}
+	return nil
+}
+
+// ReplayLagBytes プライマリの現在のWAL位置とスタンバイの再生位置の差をバイトで取得
+func (c *Cluster) ReplayLagBytes(node *Node) (int64, error) {
+	return c.ReplayLagBytesContext(context.Background(), node)
+}
+
+// ReplayLagBytesContext ctxに従って中断できるReplayLagBytes
+func (c *Cluster) ReplayLagBytesContext(ctx context.Context, node *Node) (int64, error) {
+	current, err := c.CurrentLSNContext(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	replayed, err := node.ReplayLSNContext(ctx)
+	if err != nil {
+		return 0, err
+	}
+	if replayed == 0 {
+		return 0, &NodeError{
+			Op: "WAL再生位置取得", Route: RouteStandby, Node: node.Name, Endpoint: node.Endpoint,
+			Kind: ErrStandbyUnavailable, Err: errNotInRecovery,
+		}
+	}
+	if replayed >= current {
+		return 0, nil
+	}
+	return int64(current - replayed), nil
+}
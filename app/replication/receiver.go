@@ -0,0 +1,117 @@
+package replication
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// ReceiverStatus スタンバイ自身から見たレプリケーションの状態。プライマリに接続できなくても取得できる
+type ReceiverStatus struct {
+	// InRecovery リカバリ中（スタンバイとして動作中）か
+	InRecovery bool
+	// ReceiveLSN 受信してディスクにフラッシュしたWAL位置（pg_last_wal_receive_lsn）
+	ReceiveLSN LSN
+	// ReplayLSN 再生済みのWAL位置（pg_last_wal_replay_lsn）
+	ReplayLSN LSN
+	// ReplayGapBytes 受信済みでまだ再生していないWALの量
+	ReplayGapBytes int64
+	// ReplayPaused pg_wal_replay_pause() で再生が一時停止されているか
+	ReplayPaused bool
+	// LastReplayTime 最後に再生したトランザクションのコミット時刻（未再生の場合はゼロ値）
+	LastReplayTime time.Time
+
+	// Receiver WALレシーバーの状態（起動していない場合はnil）
+	Receiver *WALReceiver
+}
+
+// WALReceiver pg_stat_wal_receiverの内容
+type WALReceiver struct {
+	PID int
+	// Status streaming / starting / waiting / restarting / stopping
+	Status string
+	// ReceiveStartLSN / ReceiveStartTLI レシーバー起動時の受信開始位置とタイムライン
+	ReceiveStartLSN LSN
+	ReceiveStartTLI int
+	// WrittenLSN / FlushedLSN 書き込み済み・フラッシュ済みのWAL位置
+	WrittenLSN LSN
+	FlushedLSN LSN
+	// ReceivedTLI 最後に受信したWALのタイムライン
+	ReceivedTLI int
+	// LastMsgSendTime / LastMsgReceiptTime 上流から最後に受け取ったメッセージの送信時刻と受信時刻
+	LastMsgSendTime    time.Time
+	LastMsgReceiptTime time.Time
+	// LatestEndLSN / LatestEndTime 上流に最後に報告したWAL位置と時刻
+	LatestEndLSN  LSN
+	LatestEndTime time.Time
+	// SlotName 使用中のレプリケーションスロット（使っていない場合は空）
+	SlotName string
+	// SenderHost / SenderPort 接続中の上流サーバー
+	SenderHost string
+	SenderPort int
+	// Conninfo 上流への接続文字列（パスワードは伏せられる。権限がない場合は空）
+	Conninfo string
+}
+
+// Streaming ストリーミング中か
+func (r WALReceiver) Streaming() bool {
+	return r.Status == "streaming"
+}
+
+// Upstream 接続中の上流サーバー（取得できない場合はゼロ値）
+func (r WALReceiver) Upstream() Endpoint {
+	return Endpoint{Host: r.SenderHost, Port: r.SenderPort}
+}
+
+// receiverProgressQuery スタンバイの受信・再生位置を取得するクエリ。
+// pg_is_wal_replay_paused() はリカバリ中でなければエラーになるためCASEで避ける
+const receiverProgressQuery = `SELECT pg_is_in_recovery(), pg_last_wal_receive_lsn(), pg_last_wal_replay_lsn(),
+	GREATEST(COALESCE(pg_wal_lsn_diff(pg_last_wal_receive_lsn(), pg_last_wal_replay_lsn()), 0), 0)::bigint,
+	CASE WHEN pg_is_in_recovery() THEN pg_is_wal_replay_paused() ELSE false END,
+	pg_last_xact_replay_timestamp()`
+
+// walReceiverQuery WALレシーバーの状態を取得するクエリ（レシーバーが起動していなければ0行）
+const walReceiverQuery = `SELECT pid, COALESCE(status, ''), receive_start_lsn, COALESCE(receive_start_tli, 0),
+	written_lsn, flushed_lsn, COALESCE(received_tli, 0),
+	last_msg_send_time, last_msg_receipt_time, latest_end_lsn, latest_end_time,
+	COALESCE(slot_name, ''), COALESCE(sender_host, ''), COALESCE(sender_port, 0), COALESCE(conninfo, '')
+FROM pg_stat_wal_receiver
+WHERE pid IS NOT NULL`
+
+// ReceiverStatus スタンバイのpg_stat_wal_receiverと受信・再生位置を取得
+func (n *Node) ReceiverStatus() (ReceiverStatus, error) {
+	return n.ReceiverStatusContext(context.Background())
+}
+
+// ReceiverStatusContext ctxに従って中断できるReceiverStatus
+func (n *Node) ReceiverStatusContext(ctx context.Context) (ReceiverStatus, error) {
+	status, err := receiverStatus(ctx, n.DB)
+	return status, n.nodeError("WALレシーバー状態取得", err)
+}
+
+// receiverStatus 指定DB（スタンバイ）の受信・再生位置とWALレシーバーの状態を読み取る
+func receiverStatus(ctx context.Context, db *sql.DB) (ReceiverStatus, error) {
+	var s ReceiverStatus
+	var lastReplay sql.NullTime
+	if err := db.QueryRowContext(ctx, receiverProgressQuery).Scan(
+		&s.InRecovery, &s.ReceiveLSN, &s.ReplayLSN, &s.ReplayGapBytes, &s.ReplayPaused, &lastReplay); err != nil {
+		return ReceiverStatus{}, err
+	}
+	s.LastReplayTime = lastReplay.Time
+
+	var r WALReceiver
+	var sendTime, receiptTime, endTime sql.NullTime
+	err := db.QueryRowContext(ctx, walReceiverQuery).Scan(&r.PID, &r.Status, &r.ReceiveStartLSN, &r.ReceiveStartTLI,
+		&r.WrittenLSN, &r.FlushedLSN, &r.ReceivedTLI, &sendTime, &receiptTime, &r.LatestEndLSN, &endTime,
+		&r.SlotName, &r.SenderHost, &r.SenderPort, &r.Conninfo)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		return s, nil
+	case err != nil:
+		return ReceiverStatus{}, err
+	}
+	r.LastMsgSendTime, r.LastMsgReceiptTime, r.LatestEndTime = sendTime.Time, receiptTime.Time, endTime.Time
+	s.Receiver = &r
+	return s, nil
+}
@@ -0,0 +1,49 @@
+package replication
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"testing"
+	"time"
+)
+
+// TestReceiverStatus スタンバイ側の受信・再生位置とWALレシーバーの状態の読み取りテスト
+func TestReceiverStatus(t *testing.T) {
+	replayed := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	progress := []driver.Value{true, "0/3000148", "0/3000060", int64(232), false, replayed}
+	receiver := []driver.Value{int64(77), "streaming", "0/3000000", int64(1), "0/3000148", "0/3000148", int64(1),
+		replayed, replayed, "0/3000148", replayed, "standby_slot", "postgres-primary", int64(5432),
+		"user=replicator passfile=/var/lib/postgresql/.pgpass host=postgres-primary port=5432"}
+
+	db := sql.OpenDB(&tableConnector{queries: map[string][][]driver.Value{
+		"pg_last_wal_receive_lsn": {progress},
+		"pg_stat_wal_receiver":    {receiver},
+	}})
+	defer func() { _ = db.Close() }()
+
+	s, err := receiverStatus(context.Background(), db)
+	if err != nil {
+		t.Fatalf("取得エラー: %v", err)
+	}
+	if !s.InRecovery || s.ReceiveLSN.String() != "0/3000148" || s.ReplayLSN.String() != "0/3000060" ||
+		s.ReplayGapBytes != 232 || s.ReplayPaused || !s.LastReplayTime.Equal(replayed) {
+		t.Errorf("受信・再生位置が不正: %+v", s)
+	}
+	r := s.Receiver
+	if r == nil || !r.Streaming() || r.SlotName != "standby_slot" || r.Upstream() != (Endpoint{Host: "postgres-primary", Port: 5432}) ||
+		r.ReceivedTLI != 1 || r.FlushedLSN != s.ReceiveLSN {
+		t.Fatalf("WALレシーバーの状態が不正: %+v", r)
+	}
+
+	// レシーバーが起動していない場合（切断中・プライマリ）はReceiverがnil
+	stopped := sql.OpenDB(&tableConnector{queries: map[string][][]driver.Value{
+		"pg_last_wal_receive_lsn": {{false, nil, nil, int64(0), false, nil}},
+		"pg_stat_wal_receiver":    {},
+	}})
+	defer func() { _ = stopped.Close() }()
+	s, err = receiverStatus(context.Background(), stopped)
+	if err != nil || s.InRecovery || s.Receiver != nil || s.ReceiveLSN != 0 {
+		t.Errorf("レシーバー停止時の状態が不正: %+v %v", s, err)
+	}
+}
@@ -0,0 +1,262 @@
+package replication
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// retireDelay Reloadで外したスタンバイの接続を閉じるまでの猶予。
+// 外す直前に選ばれたノードでの読み取りが、接続を閉じたことで失敗しないようにする
+var retireDelay = 30 * time.Second
+
+// Reload 設定を反映する。スタンバイの追加・削除と、重み・ゾーン・許容遅延・プール設定・
+// ルーティングポリシー・タイムアウトなどの変更を、実行中のクエリを中断せずに反映する。
+// 追加したスタンバイへ接続できない場合や、プライマリの接続先・認証情報・TLS設定を変更した場合は
+// 何も反映せずにエラーを返す（これらの変更には再起動が必要）
+func (c *Cluster) Reload(ctx context.Context, cfg Config) error {
+	c.reloadMu.Lock()
+	defer c.reloadMu.Unlock()
+
+	cur := c.config()
+	if cfg.Primary != cur.Primary || cfg.User != cur.User || cfg.Password != cur.Password ||
+		cfg.DBName != cur.DBName || cfg.ConnectTimeout != cur.ConnectTimeout ||
+		cfg.TLS != cur.TLS || cfg.PrimaryTLS != cur.PrimaryTLS {
+		return errors.New("プライマリの接続先と認証情報・TLS設定の変更は再起動が必要です")
+	}
+	// 認証情報の取得方法は起動時のものを使い続ける（ファイルやコマンドの出力の変更は接続のたびに反映される）
+	cfg.Credentials = cur.Credentials
+	if len(cfg.Standbys) == 0 {
+		return errors.New("スタンバイが設定されていません")
+	}
+	var policy RoutingPolicy
+	if cfg.RoutingPolicy != cur.RoutingPolicy {
+		p, err := NewRoutingPolicy(cfg.RoutingPolicy)
+		if err != nil {
+			return err
+		}
+		policy = p
+	}
+
+	old := c.StandbyNodes()
+	byEndpoint := make(map[Endpoint]*Node, len(old))
+	for _, n := range old {
+		byEndpoint[n.Endpoint] = n
+	}
+
+	next := make([]*Node, 0, len(cfg.Standbys))
+	var opened []*Node
+	kept := make(map[*Node]bool)
+	for _, sc := range cfg.Standbys {
+		// TLS設定が変わったスタンバイは新しく接続し直す
+		if prev, ok := byEndpoint[sc.Endpoint]; ok && !kept[prev] && cfg.TLSFor(sc.Endpoint) == cur.TLSFor(sc.Endpoint) {
+			kept[prev] = true
+			next = append(next, prev.reconfigure(sc))
+			continue
+		}
+		n := newNode(cfg, sc)
+		if err := n.DB.PingContext(ctx); err != nil {
+			_ = n.DB.Close()
+			for _, o := range opened {
+				_ = o.DB.Close()
+			}
+			return fmt.Errorf("スタンバイ追加エラー: %w", n.nodeError("スタンバイDB ping", err))
+		}
+		opened = append(opened, n)
+		next = append(next, n)
+	}
+
+	// ここから先は失敗しない
+	for i, sc := range cfg.Standbys {
+		configurePool(next[i].DB, cfg.standbyPool(sc))
+	}
+	if c.Primary != nil {
+		configurePool(c.Primary, cfg.pool())
+	}
+	if policy != nil {
+		c.SetRoutingPolicy(policy)
+	}
+
+	c.topoMu.Lock()
+	c.Standbys = next
+	c.live.Store(&cfg)
+	c.topoMu.Unlock()
+
+	c.mu.Lock()
+	checkers := c.checkers
+	var retired []*Node
+	for _, n := range old {
+		if !kept[n] {
+			retired = append(retired, n)
+		}
+	}
+	c.retired = append(c.retired, retired...)
+	c.mu.Unlock()
+
+	for _, h := range checkers {
+		h.syncNodes(next)
+	}
+	for _, n := range retired {
+		time.AfterFunc(retireDelay, func() { c.retire(n) })
+	}
+	return nil
+}
+
+// reconfigure 同じ接続先のスタンバイに新しい設定を適用する。
+// 設定が変わっていなければnをそのまま返し、変わっていれば接続と状態を引き継いだ新しいノードを返す
+func (n *Node) reconfigure(sc StandbyConfig) *Node {
+	updated := nodeFromConfig(sc, n.DB)
+	if updated.Name == n.Name && updated.Weight == n.Weight && updated.Zone == n.Zone &&
+		updated.MaxStaleness == n.MaxStaleness && updated.MaxLagBytes == n.MaxLagBytes {
+		return n
+	}
+	updated.healthy.Store(n.Healthy())
+	n.mu.Lock()
+	updated.lag, updated.lagMeasuredAt = n.lag, n.lagMeasuredAt
+	n.mu.Unlock()
+	return updated
+}
+
+// retire Reloadで外したスタンバイの接続を閉じる（実行中のクエリは完了まで待たれる）
+func (c *Cluster) retire(n *Node) {
+	c.mu.Lock()
+	for i, r := range c.retired {
+		if r == n {
+			c.retired = append(c.retired[:i], c.retired[i+1:]...)
+			break
+		}
+	}
+	c.mu.Unlock()
+	_ = n.DB.Close()
+}
+
+// TopologyWatchConfig トポロジーファイルの監視設定
+type TopologyWatchConfig struct {
+	// Path トポロジーファイルのパス
+	Path string
+	// Interval ファイルの変更を確認する間隔（0の場合は2秒）
+	Interval time.Duration
+	// Timeout 1回の反映（追加したスタンバイへの接続確認）のタイムアウト（0の場合は10秒）
+	Timeout time.Duration
+	// OnReload 反映を試みたときに呼ばれる。失敗した場合はerrが設定され、設定は変更されない
+	OnReload func(cfg Config, err error)
+}
+
+// TopologyWatcher トポロジーファイルの変更とSIGHUPを監視し、クラスタに反映する
+type TopologyWatcher struct {
+	cluster *Cluster
+	cfg     TopologyWatchConfig
+
+	mu   sync.Mutex
+	last []byte
+
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// WatchTopology トポロジーファイルの監視を開始する。ファイルの内容が変わるか、SIGHUPを受け取ると
+// LoadConfigFileで読み込んでReloadする。Cluster.Closeで停止する
+func (c *Cluster) WatchTopology(cfg TopologyWatchConfig) *TopologyWatcher {
+	if cfg.Interval <= 0 {
+		cfg.Interval = 2 * time.Second
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = 10 * time.Second
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	w := &TopologyWatcher{cluster: c, cfg: cfg, cancel: cancel, done: make(chan struct{})}
+	// 起動時点の内容を基準にし、変更があった場合のみ反映する
+	w.last, _ = os.ReadFile(cfg.Path)
+
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go w.run(ctx, hup)
+
+	c.mu.Lock()
+	c.watchers = append(c.watchers, w)
+	c.mu.Unlock()
+	return w
+}
+
+// Stop 監視を停止する
+func (w *TopologyWatcher) Stop() {
+	w.cancel()
+	<-w.done
+}
+
+// Reload ファイルの変更の有無にかかわらず、今すぐ読み込んで反映する
+func (w *TopologyWatcher) Reload(ctx context.Context) error {
+	data, err := os.ReadFile(w.cfg.Path)
+	if err != nil {
+		err = fmt.Errorf("トポロジーファイル読み込みエラー: %w", err)
+		w.notify(Config{}, err)
+		return err
+	}
+	return w.apply(ctx, data)
+}
+
+// run SIGHUPとファイルの変更を待つ
+func (w *TopologyWatcher) run(ctx context.Context, hup chan os.Signal) {
+	defer close(w.done)
+	defer signal.Stop(hup)
+	ticker := time.NewTicker(w.cfg.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+			reloadCtx, cancel := context.WithTimeout(ctx, w.cfg.Timeout)
+			_ = w.Reload(reloadCtx)
+			cancel()
+		case <-ticker.C:
+			data, err := os.ReadFile(w.cfg.Path)
+			if err != nil || w.unchanged(data) {
+				// 書き換え中などで読めない場合は次の確認を待つ
+				continue
+			}
+			reloadCtx, cancel := context.WithTimeout(ctx, w.cfg.Timeout)
+			_ = w.apply(reloadCtx, data)
+			cancel()
+		}
+	}
+}
+
+// unchanged 前回反映を試みた内容と同じか
+func (w *TopologyWatcher) unchanged(data []byte) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return bytes.Equal(w.last, data)
+}
+
+// apply 読み込んだ内容を解釈して反映する。失敗した内容も記録し、再度変更されるまで再試行しない
+func (w *TopologyWatcher) apply(ctx context.Context, data []byte) error {
+	w.mu.Lock()
+	w.last = data
+	w.mu.Unlock()
+
+	cfg, err := ParseTopology(data)
+	if err == nil {
+		applyEnv(&cfg)
+		err = w.cluster.Reload(ctx, cfg)
+	} else {
+		err = fmt.Errorf("%s: %w", w.cfg.Path, err)
+	}
+	w.notify(cfg, err)
+	return err
+}
+
+// notify OnReloadを呼ぶ
+func (w *TopologyWatcher) notify(cfg Config, err error) {
+	if w.cfg.OnReload != nil {
+		w.cfg.OnReload(cfg, err)
+	}
+}
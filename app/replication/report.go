@@ -0,0 +1,241 @@
+package replication
+
+import (
+	"fmt"
+	"html/template"
+	"io"
+	"math"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Stats 1系列の集計値
+type Stats struct {
+	Count int
+	Min   float64
+	Avg   float64
+	P95   float64
+	Max   float64
+}
+
+// summarize 値の列を集計する。p95は最近傍順位法（昇順で ceil(0.95*n) 番目）
+func summarize(values []float64) Stats {
+	if len(values) == 0 {
+		return Stats{}
+	}
+	sorted := append([]float64(nil), values...)
+	sort.Float64s(sorted)
+	var sum float64
+	for _, v := range sorted {
+		sum += v
+	}
+	rank := int(math.Ceil(0.95 * float64(len(sorted))))
+	return Stats{
+		Count: len(sorted),
+		Min:   sorted[0],
+		Avg:   sum / float64(len(sorted)),
+		P95:   sorted[rank-1],
+		Max:   sorted[len(sorted)-1],
+	}
+}
+
+// ReportUnit 集計値の単位
+type ReportUnit string
+
+// 集計値の単位
+const (
+	UnitBytes       ReportUnit = "bytes"
+	UnitSeconds     ReportUnit = "s"
+	UnitBytesPerSec ReportUnit = "bytes/s"
+)
+
+// Format 単位に合わせて読みやすい形式に変換
+func (u ReportUnit) Format(v float64) string {
+	switch u {
+	case UnitBytes:
+		return formatBytes(v)
+	case UnitBytesPerSec:
+		return formatBytes(v) + "/s"
+	case UnitSeconds:
+		return fmt.Sprintf("%.3f s", v)
+	}
+	return fmt.Sprintf("%g", v)
+}
+
+// formatBytes バイト数をKiB/MiB/GiB単位で表す
+func formatBytes(v float64) string {
+	units := []string{"B", "KiB", "MiB", "GiB", "TiB"}
+	i := 0
+	for math.Abs(v) >= 1024 && i < len(units)-1 {
+		v /= 1024
+		i++
+	}
+	if i == 0 {
+		return fmt.Sprintf("%.0f %s", v, units[i])
+	}
+	return fmt.Sprintf("%.1f %s", v, units[i])
+}
+
+// ReportRow レポートの1行（1項目・1対象分の集計）
+type ReportRow struct {
+	// Metric 項目名
+	Metric string
+	// Target スタンバイ名・スロット名（クラスタ全体の値の場合は空）
+	Target string
+	Unit   ReportUnit
+	Stats
+}
+
+// LagReport 期間内の計測結果の集計
+type LagReport struct {
+	// From / To 集計した期間（指定がない場合は最初と最後の計測時刻）
+	From time.Time
+	To   time.Time
+	// Samples 集計した計測結果の件数
+	Samples int
+	// Errors 取得に失敗した項目を含む計測結果の件数
+	Errors int
+	Rows   []ReportRow
+}
+
+// 項目名
+const (
+	metricLagBytes      = "再生遅延（WAL位置の差）"
+	metricReplayLag     = "再生遅延（replay_lag）"
+	metricHeartbeatLag  = "ハートビート遅延"
+	metricWALRate       = "WAL生成量"
+	metricSlotRetention = "スロットの保持WAL"
+)
+
+// BuildLagReport 計測結果を項目・対象ごとに集計する。from / to がゼロ値の場合は計測結果の範囲を期間とする
+func BuildLagReport(samples []LagSample, from, to time.Time) LagReport {
+	r := LagReport{From: from, To: to, Samples: len(samples)}
+	type key struct{ metric, target string }
+	values := make(map[key][]float64)
+	units := make(map[key]ReportUnit)
+	var order []key
+	add := func(metric, target string, unit ReportUnit, v float64) {
+		k := key{metric, target}
+		if _, ok := values[k]; !ok {
+			order = append(order, k)
+			units[k] = unit
+		}
+		values[k] = append(values[k], v)
+	}
+
+	for _, s := range samples {
+		if r.From.IsZero() || (from.IsZero() && s.Time.Before(r.From)) {
+			r.From = s.Time
+		}
+		if to.IsZero() && s.Time.After(r.To) {
+			r.To = s.Time
+		}
+		if len(s.Errors) > 0 {
+			r.Errors++
+		}
+		if s.WALRate != nil {
+			add(metricWALRate, "", UnitBytesPerSec, *s.WALRate)
+		}
+		for _, st := range s.Standbys {
+			add(metricLagBytes, st.Name(), UnitBytes, float64(st.LagBytes))
+			add(metricReplayLag, st.Name(), UnitSeconds, st.ReplayLag)
+		}
+		for _, name := range sortedKeys(s.Heartbeats) {
+			add(metricHeartbeatLag, name, UnitSeconds, s.Heartbeats[name])
+		}
+		for _, sl := range s.Slots {
+			add(metricSlotRetention, sl.Name, UnitBytes, float64(sl.RetainedBytes))
+		}
+	}
+
+	// 項目の順序を固定し、同じ項目内は対象名順に並べる
+	rank := map[string]int{metricWALRate: 0, metricLagBytes: 1, metricReplayLag: 2, metricHeartbeatLag: 3, metricSlotRetention: 4}
+	sort.SliceStable(order, func(i, j int) bool {
+		if order[i].metric != order[j].metric {
+			return rank[order[i].metric] < rank[order[j].metric]
+		}
+		return order[i].target < order[j].target
+	})
+	for _, k := range order {
+		r.Rows = append(r.Rows, ReportRow{Metric: k.metric, Target: k.target, Unit: units[k], Stats: summarize(values[k])})
+	}
+	return r
+}
+
+// reportTimeLayout レポートでの時刻の表示形式
+const reportTimeLayout = "2006-01-02 15:04:05 MST"
+
+// WriteMarkdown Markdown形式で書き込む
+func (r LagReport) WriteMarkdown(w io.Writer) error {
+	var b strings.Builder
+	b.WriteString("# レプリケーション監視レポート\n\n")
+	fmt.Fprintf(&b, "- 期間: %s 〜 %s\n", formatReportTime(r.From), formatReportTime(r.To))
+	fmt.Fprintf(&b, "- 計測回数: %d（取得エラーを含む計測: %d）\n\n", r.Samples, r.Errors)
+	if len(r.Rows) == 0 {
+		b.WriteString("期間内の計測結果がありません。\n")
+	} else {
+		b.WriteString("| 項目 | 対象 | 件数 | 最小 | 平均 | p95 | 最大 |\n")
+		b.WriteString("|---|---|---:|---:|---:|---:|---:|\n")
+		for _, row := range r.Rows {
+			target := row.Target
+			if target == "" {
+				target = "-"
+			}
+			fmt.Fprintf(&b, "| %s | %s | %d | %s | %s | %s | %s |\n",
+				row.Metric, escapeMarkdownCell(target), row.Count,
+				row.Unit.Format(row.Min), row.Unit.Format(row.Avg), row.Unit.Format(row.P95), row.Unit.Format(row.Max))
+		}
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// escapeMarkdownCell 表のセル内で区切りとして解釈される文字をエスケープ
+func escapeMarkdownCell(s string) string {
+	return strings.ReplaceAll(s, "|", `\|`)
+}
+
+// formatReportTime 時刻を表示用に変換（ゼロ値は "-"）
+func formatReportTime(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+	return t.Format(reportTimeLayout)
+}
+
+// reportHTML HTML形式のテンプレート
+var reportHTML = template.Must(template.New("report").Funcs(template.FuncMap{
+	"time": formatReportTime,
+}).Parse(`<!DOCTYPE html>
+<html lang="ja">
+<head>
+<meta charset="utf-8">
+<title>レプリケーション監視レポート</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; }
+th, td { border: 1px solid #ccc; padding: 4px 10px; }
+td.num { text-align: right; font-variant-numeric: tabular-nums; }
+th { background: #f4f4f4; }
+</style>
+</head>
+<body>
+<h1>レプリケーション監視レポート</h1>
+<ul>
+<li>期間: {{time .From}} 〜 {{time .To}}</li>
+<li>計測回数: {{.Samples}}（取得エラーを含む計測: {{.Errors}}）</li>
+</ul>
+{{if .Rows}}<table>
+<tr><th>項目</th><th>対象</th><th>件数</th><th>最小</th><th>平均</th><th>p95</th><th>最大</th></tr>
+{{range .Rows}}<tr><td>{{.Metric}}</td><td>{{if .Target}}{{.Target}}{{else}}-{{end}}</td><td class="num">{{.Count}}</td><td class="num">{{.Unit.Format .Min}}</td><td class="num">{{.Unit.Format .Avg}}</td><td class="num">{{.Unit.Format .P95}}</td><td class="num">{{.Unit.Format .Max}}</td></tr>
+{{end}}</table>
+{{else}}<p>期間内の計測結果がありません。</p>
+{{end}}</body>
+</html>
+`))
+
+// WriteHTML HTML形式で書き込む
+func (r LagReport) WriteHTML(w io.Writer) error {
+	return reportHTML.Execute(w, r)
+}
@@ -0,0 +1,236 @@
+package replication
+
+import (
+	"context"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrNotFound 指定したIDのデータが存在しない
+var ErrNotFound = errors.New("データが見つかりません")
+
+// ErrInvalidCursor ページングのカーソルが不正
+var ErrInvalidCursor = errors.New("カーソルが不正です")
+
+// defaultPageSize ページサイズを指定しなかった場合の件数
+const defaultPageSize = 20
+
+// Repository test_replicationテーブルのCRUD。書き込みはプライマリ、読み取りはスタンバイで行う。
+// 独自のテーブル用のリポジトリを作る際のひな形として使える
+type Repository struct {
+	cluster *Cluster
+}
+
+// NewRepository クラスタ上のtest_replicationテーブルのリポジトリを作成
+func NewRepository(c *Cluster) *Repository {
+	return &Repository{cluster: c}
+}
+
+// Create データを追加し、追加した行とコミット後のWAL位置を返す。
+// 返したLSNはGet・ListにAfterLSNで渡すと、スタンバイがこの書き込みを再生してから読み取る
+func (r *Repository) Create(ctx context.Context, data string) (ReplicationData, LSN, error) {
+	return r.cluster.WriteToPrimaryContext(ctx, data)
+}
+
+// Get IDでデータを取得する。存在しない場合はErrNotFoundを返す。
+// スタンバイがまだ書き込みを再生していなければ、Create直後の行でもErrNotFoundになる。
+// 書き込み直後に読み取る場合は、Create・Updateが返したLSNをAfterLSNで渡す
+func (r *Repository) Get(ctx context.Context, id int, opts ...ReadOption) (ReplicationData, error) {
+	var row ReplicationData
+	err := r.cluster.readWithRetry(ctx, opts, func(db *sql.DB, info ReadInfo) error {
+		err := db.QueryRowContext(ctx,
+			"SELECT id, data, created_at FROM test_replication WHERE id = $1", id).Scan(
+			&row.ID, &row.Data, &row.CreatedAt)
+		if errors.Is(err, sql.ErrNoRows) {
+			return fmt.Errorf("%w: id=%d", ErrNotFound, id)
+		}
+		return readError("データ取得", info, err)
+	})
+	return row, err
+}
+
+// Update データを更新し、更新後の行とコミット後のWAL位置を返す。存在しない場合はErrNotFoundを返す
+func (r *Repository) Update(ctx context.Context, id int, data string) (ReplicationData, LSN, error) {
+	row := ReplicationData{ID: id, Data: data}
+	err := r.cluster.Primary.QueryRowContext(ctx,
+		"UPDATE test_replication SET data = $2 WHERE id = $1 RETURNING created_at", id, data).Scan(
+		&row.CreatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ReplicationData{}, 0, fmt.Errorf("%w: id=%d", ErrNotFound, id)
+	}
+	if err != nil {
+		return ReplicationData{}, 0, r.cluster.primaryError("更新", err)
+	}
+
+	lsn, err := r.cluster.committed(ctx)
+	return row, lsn, err
+}
+
+// Delete データを削除し、コミット後のWAL位置を返す。存在しない場合はErrNotFoundを返す
+func (r *Repository) Delete(ctx context.Context, id int) (LSN, error) {
+	result, err := r.cluster.Primary.ExecContext(ctx, "DELETE FROM test_replication WHERE id = $1", id)
+	if err != nil {
+		return 0, r.cluster.primaryError("削除", err)
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return 0, r.cluster.primaryError("削除", err)
+	}
+	if n == 0 {
+		return 0, fmt.Errorf("%w: id=%d", ErrNotFound, id)
+	}
+	return r.cluster.committed(ctx)
+}
+
+// ListCreatedBetween created_atが[from, to)の範囲のデータを古い順に取得する。ゼロ値の端は制限しない
+func (r *Repository) ListCreatedBetween(ctx context.Context, from, to time.Time, opts ...ReadOption) ([]ReplicationData, error) {
+	var where []string
+	var args []any
+	if !from.IsZero() {
+		args = append(args, from)
+		where = append(where, fmt.Sprintf("created_at >= $%d", len(args)))
+	}
+	if !to.IsZero() {
+		args = append(args, to)
+		where = append(where, fmt.Sprintf("created_at < $%d", len(args)))
+	}
+
+	query := "SELECT id, data, created_at FROM test_replication"
+	if len(where) > 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
+	}
+	query += " ORDER BY created_at, id"
+
+	var results []ReplicationData
+	err := r.cluster.readWithRetry(ctx, opts, func(db *sql.DB, info ReadInfo) error {
+		var err error
+		results, err = queryRows(ctx, db, info, query, args...)
+		return err
+	})
+	return results, err
+}
+
+// Cursor キーセットページングの位置。ページ内の最後の行の (created_at, id)
+type Cursor struct {
+	CreatedAt time.Time
+	ID        int
+}
+
+// Encode URLなどに埋め込める文字列に変換する
+func (c Cursor) Encode() string {
+	raw := c.CreatedAt.Format(time.RFC3339Nano) + "," + strconv.Itoa(c.ID)
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+// ParseCursor Encodeで作成した文字列からカーソルを復元する
+func ParseCursor(s string) (Cursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return Cursor{}, fmt.Errorf("%w: %v", ErrInvalidCursor, err)
+	}
+	ts, id, ok := strings.Cut(string(raw), ",")
+	if !ok {
+		return Cursor{}, fmt.Errorf("%w: %q", ErrInvalidCursor, s)
+	}
+	createdAt, err := time.Parse(time.RFC3339Nano, ts)
+	if err != nil {
+		return Cursor{}, fmt.Errorf("%w: %v", ErrInvalidCursor, err)
+	}
+	n, err := strconv.Atoi(id)
+	if err != nil {
+		return Cursor{}, fmt.Errorf("%w: %v", ErrInvalidCursor, err)
+	}
+	return Cursor{CreatedAt: createdAt, ID: n}, nil
+}
+
+// PageRequest キーセットページングの条件
+type PageRequest struct {
+	// Limit 1ページの件数（0以下の場合は20）
+	Limit int
+	// After 前のページのNextCursor。空の場合は先頭から
+	After string
+	// Ascending trueの場合は古い順、falseの場合は新しい順
+	Ascending bool
+}
+
+// Page 1ページ分の結果
+type Page struct {
+	Items []ReplicationData
+	// NextCursor 次のページを取得するためのカーソル。最後のページの場合は空
+	NextCursor string
+}
+
+// List (created_at, id) をキーとしたキーセットページングでデータを取得する
+func (r *Repository) List(ctx context.Context, req PageRequest, opts ...ReadOption) (Page, error) {
+	limit := req.Limit
+	if limit <= 0 {
+		limit = defaultPageSize
+	}
+	query, args, err := pageQuery(req, limit)
+	if err != nil {
+		return Page{}, err
+	}
+
+	var page Page
+	err = r.cluster.readWithRetry(ctx, opts, func(db *sql.DB, info ReadInfo) error {
+		rows, err := queryRows(ctx, db, info, query, args...)
+		if err != nil {
+			return err
+		}
+		// 1件多く取得して次のページの有無を判定する
+		page = Page{Items: rows}
+		if len(rows) > limit {
+			page.Items = rows[:limit]
+			last := page.Items[limit-1]
+			page.NextCursor = Cursor{CreatedAt: last.CreatedAt, ID: last.ID}.Encode()
+		}
+		return nil
+	})
+	return page, err
+}
+
+// pageQuery キーセットページングのクエリを組み立てる
+func pageQuery(req PageRequest, limit int) (string, []any, error) {
+	cmp, order := "<", "DESC"
+	if req.Ascending {
+		cmp, order = ">", "ASC"
+	}
+
+	query := "SELECT id, data, created_at FROM test_replication"
+	var args []any
+	if req.After != "" {
+		cursor, err := ParseCursor(req.After)
+		if err != nil {
+			return "", nil, err
+		}
+		args = append(args, cursor.CreatedAt, cursor.ID)
+		query += fmt.Sprintf(" WHERE (created_at, id) %s ($1, $2)", cmp)
+	}
+	args = append(args, limit+1)
+	query += fmt.Sprintf(" ORDER BY created_at %s, id %s LIMIT $%d", order, order, len(args))
+	return query, args, nil
+}
+
+// queryRows クエリを実行してReplicationDataの一覧を返す
+func queryRows(ctx context.Context, db *sql.DB, info ReadInfo, query string, args ...any) ([]ReplicationData, error) {
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, readError("データ読み取り", info, err)
+	}
+	defer func() { _ = rows.Close() }()
+
+	var results []ReplicationData
+	for rows.Next() {
+		var data ReplicationData
+		if err := rows.Scan(&data.ID, &data.Data, &data.CreatedAt); err != nil {
+			return nil, readError("データスキャン", info, err)
+		}
+		results = append(results, data)
+	}
+	return results, readError("データ読み取り", info, rows.Err())
+}
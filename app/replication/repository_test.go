@@ -0,0 +1,46 @@
+package replication
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestCursorRoundTrip カーソルの変換と復元のテスト
+func TestCursorRoundTrip(t *testing.T) {
+	want := Cursor{CreatedAt: time.Date(2025, 7, 1, 12, 34, 56, 789000000, time.UTC), ID: 42}
+	got, err := ParseCursor(want.Encode())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !got.CreatedAt.Equal(want.CreatedAt) || got.ID != want.ID {
+		t.Fatalf("カーソルが復元できない: %+v, want %+v", got, want)
+	}
+
+	for _, s := range []string{"!!", "bm90LWEtY3Vyc29y", want.Encode()[1:]} {
+		if _, err := ParseCursor(s); !errors.Is(err, ErrInvalidCursor) {
+			t.Errorf("ParseCursor(%q): ErrInvalidCursorにならない: %v", s, err)
+		}
+	}
+}
+
+// TestPageQuery キーセットページングのクエリ組み立てテスト
+func TestPageQuery(t *testing.T) {
+	query, args, err := pageQuery(PageRequest{}, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(query, "WHERE") || !strings.HasSuffix(query, "ORDER BY created_at DESC, id DESC LIMIT $1") || args[0] != 11 {
+		t.Fatalf("先頭ページのクエリが不正: %s %v", query, args)
+	}
+
+	after := Cursor{CreatedAt: time.Now(), ID: 7}.Encode()
+	query, args, err = pageQuery(PageRequest{After: after, Ascending: true}, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(query, "WHERE (created_at, id) > ($1, $2)") || !strings.HasSuffix(query, "ORDER BY created_at ASC, id ASC LIMIT $3") || len(args) != 3 {
+		t.Fatalf("続きのページのクエリが不正: %s %v", query, args)
+	}
+}
@@ -0,0 +1,107 @@
+package replication
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// RetryPolicy スタンバイでのリカバリ競合（ErrRecoveryConflict）時のリトライ設定
+type RetryPolicy struct {
+	// MaxRetries 最大リトライ回数（0の場合はリトライしない）
+	MaxRetries int
+	// InitialBackoff 1回目のリトライまでの待機時間。以降は倍々に増える
+	InitialBackoff time.Duration
+	// MaxBackoff 待機時間の上限
+	MaxBackoff time.Duration
+	// Budget 最初の試行からリトライを打ち切るまでの時間（0の場合は回数のみで判定）
+	Budget time.Duration
+}
+
+// デフォルトのリトライ設定
+const (
+	defaultMaxRetries     = 2
+	defaultInitialBackoff = 50 * time.Millisecond
+	defaultMaxBackoff     = time.Second
+	defaultRetryBudget    = 2 * time.Second
+)
+
+// WithRetryPolicy この読み取りのリトライ設定を指定（省略時はConfig.Retry）
+func WithRetryPolicy(p RetryPolicy) ReadOption {
+	return func(o *readOptions) {
+		o.retry = p
+	}
+}
+
+// backoff n回目（1始まり）のリトライ前の待機時間
+func (p RetryPolicy) backoff(n int) time.Duration {
+	d := p.InitialBackoff
+	if d <= 0 {
+		d = defaultInitialBackoff
+	}
+	maxBackoff := p.MaxBackoff
+	if maxBackoff <= 0 {
+		maxBackoff = defaultMaxBackoff
+	}
+	for i := 1; i < n && d < maxBackoff; i++ {
+		d *= 2
+	}
+	if d > maxBackoff {
+		d = maxBackoff
+	}
+	return d
+}
+
+// readWithRetry 読み取りノードを選んでfnを実行する。リカバリ競合で失敗した場合は、
+// 競合したスタンバイを除いて（スタンバイがなくなればプライマリで）リトライする
+func (c *Cluster) readWithRetry(ctx context.Context, opts []ReadOption, fn func(db *sql.DB, info ReadInfo) error) error {
+	o := c.newReadOptions(opts)
+	// WithLSN（Middleware）で受け取ったLSNまで再生してから読み取る
+	if lsn, ok := LSNFromContext(ctx); ok && lsn > o.afterLSN {
+		o.afterLSN = lsn
+	}
+	start := time.Now()
+
+	for attempt := 0; ; attempt++ {
+		db, info, err := c.readDBWith(ctx, &o)
+		if err != nil {
+			return err
+		}
+		info.Retries = attempt
+		c.metrics.routed(info)
+		if o.info != nil {
+			*o.info = info
+		}
+
+		err = fn(db, info)
+		if err == nil || !errors.Is(err, ErrRecoveryConflict) {
+			return err
+		}
+		c.metrics.recoveryConflicts.Add(1)
+
+		next := o.retry.backoff(attempt + 1)
+		if attempt >= o.retry.MaxRetries ||
+			(o.retry.Budget > 0 && time.Since(start)+next > o.retry.Budget) {
+			c.metrics.retriesExhausted.Add(1)
+			return err
+		}
+
+		// 競合したスタンバイを除外して再選択する
+		if info.Route == RouteStandby {
+			for _, n := range c.StandbyNodes() {
+				if n.Name == info.Name {
+					o.exclude = append(o.exclude, n)
+				}
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			// キャンセルと競合を区別できるよう、ctx.Err()に最後の競合エラーを添えて返す
+			return errors.Join(ctx.Err(), err)
+		case <-time.After(next):
+		}
+		c.metrics.retries.Add(1)
+	}
+}
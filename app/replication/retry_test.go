@@ -0,0 +1,62 @@
+package replication
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// TestReadRetryOnRecoveryConflict リカバリ競合時に他のスタンバイ、最後にプライマリでリトライするテスト
+func TestReadRetryOnRecoveryConflict(t *testing.T) {
+	var up atomic.Bool
+	conflict := &pq.Error{Code: "40001", Message: "canceling statement due to conflict with recovery"}
+
+	c := &Cluster{
+		Config:  Config{Retry: RetryPolicy{MaxRetries: 2, InitialBackoff: time.Millisecond}},
+		Primary: sql.OpenDB(&probeConnector{down: &up}),
+	}
+	for _, name := range []string{"standby1", "standby2"} {
+		n := &Node{Name: name, Weight: 1, DB: sql.OpenDB(&probeConnector{down: &up, err: conflict})}
+		n.healthy.Store(true)
+		c.Standbys = append(c.Standbys, n)
+	}
+	c.SetRoutingPolicy(&RoundRobinPolicy{})
+	defer c.Close()
+
+	var served ReadInfo
+	if _, err := c.GetDataCountContext(context.Background(), ServedBy(&served)); err != nil {
+		t.Fatalf("プライマリでのリトライで成功するはず: %v", err)
+	}
+	if served.Route != RoutePrimary || served.Retries != 2 {
+		t.Fatalf("2回のリトライ後にプライマリで処理されるはず: %+v", served)
+	}
+	if m := c.Metrics(); m.RecoveryConflicts != 2 || m.Retries != 2 || m.RetriesExhausted != 0 {
+		t.Fatalf("メトリクスが不正: %+v", m)
+	}
+	if m := c.Metrics(); m.Reads["standby1"] != 1 || m.Reads["standby2"] != 1 || m.Reads["primary"] != 1 ||
+		m.Fallbacks[FallbackRecoveryConflict] != 1 {
+		t.Fatalf("ノードごとの読み取り回数が不正: %+v", m)
+	}
+
+	// StandbyOnly指定時はプライマリを使わず、上限に達したらエラーを返す
+	_, err := c.GetDataCountContext(context.Background(), StandbyOnly())
+	if !errors.Is(err, ErrRecoveryConflict) {
+		t.Fatalf("ErrRecoveryConflictを返すはず: %v", err)
+	}
+	if m := c.Metrics(); m.RetriesExhausted != 1 {
+		t.Fatalf("上限到達の回数が不正: %+v", m)
+	}
+
+	// 待機中にキャンセルされた場合はキャンセルと競合の両方がわかるエラーを返す
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(20*time.Millisecond, cancel)
+	_, err = c.GetDataCountContext(ctx, StandbyOnly(), WithRetryPolicy(RetryPolicy{MaxRetries: 2, InitialBackoff: time.Hour, MaxBackoff: time.Hour}))
+	if !errors.Is(err, context.Canceled) || !errors.Is(err, ErrRecoveryConflict) {
+		t.Fatalf("context.CanceledとErrRecoveryConflictの両方を返すはず: %v", err)
+	}
+}
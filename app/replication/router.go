@@ -0,0 +1,200 @@
+package replication
+
+import (
+	"strings"
+	"unicode"
+)
+
+// Route クエリの送信先ノード
+type Route int
+
+const (
+	// RoutePrimary プライマリへ送信
+	RoutePrimary Route = iota
+	// RouteStandby スタンバイへ送信
+	RouteStandby
+)
+
+// String 送信先の名前を返す
+func (r Route) String() string {
+	if r == RouteStandby {
+		return "standby"
+	}
+	return "primary"
+}
+
+// readOnlyStatements スタンバイで実行できる文の先頭キーワード
+var readOnlyStatements = map[string]bool{
+	"SELECT":  true,
+	"WITH":    true,
+	"TABLE":   true,
+	"VALUES":  true,
+	"SHOW":    true,
+	"EXPLAIN": true,
+}
+
+// writeKeywords 出現した時点で書き込みとみなすキーワード
+var writeKeywords = map[string]bool{
+	"INSERT":  true,
+	"UPDATE":  true,
+	"DELETE":  true,
+	"MERGE":   true,
+	"INTO":    true, // SELECT ... INTO はテーブルを作成する
+	"ANALYZE": true, // EXPLAIN ANALYZE は文を実際に実行する
+}
+
+// writeFunctionPrefixes スタンバイで実行できない、または副作用のある関数名の接頭辞
+var writeFunctionPrefixes = []string{
+	"NEXTVAL",
+	"SETVAL",
+	"PG_ADVISORY",
+	"PG_TRY_ADVISORY",
+	"TXID_CURRENT",
+	"PG_CURRENT_XACT_ID",
+	"PG_CURRENT_WAL",
+	"PG_NOTIFY",
+	"LO_",
+}
+
+// ClassifyQuery SQL文を解析し、スタンバイで安全に実行できる読み取り専用文かを判定する。
+// 判定できないものは全てプライマリへ送る。
+func ClassifyQuery(query string) Route {
+	tokens := tokenizeSQL(query)
+
+	// 末尾のセミコロンは無視し、複数文はプライマリへ
+	for len(tokens) > 0 && tokens[len(tokens)-1] == ";" {
+		tokens = tokens[:len(tokens)-1]
+	}
+	if len(tokens) == 0 || !readOnlyStatements[tokens[0]] {
+		return RoutePrimary
+	}
+
+	for i, tok := range tokens {
+		if tok == ";" || writeKeywords[tok] {
+			return RoutePrimary
+		}
+		// SELECT ... FOR UPDATE / FOR SHARE / FOR NO KEY UPDATE / FOR KEY SHARE
+		if tok == "FOR" && i+1 < len(tokens) {
+			switch tokens[i+1] {
+			case "UPDATE", "SHARE", "NO", "KEY":
+				return RoutePrimary
+			}
+		}
+		for _, prefix := range writeFunctionPrefixes {
+			if strings.HasPrefix(tok, prefix) {
+				return RoutePrimary
+			}
+		}
+	}
+	return RouteStandby
+}
+
+// tokenizeSQL コメントと文字列リテラルを除去し、大文字化したキーワードと記号の列に分解
+func tokenizeSQL(query string) []string {
+	var tokens []string
+	r := []rune(query)
+	for i := 0; i < len(r); {
+		c := r[i]
+		switch {
+		case unicode.IsSpace(c):
+			i++
+		case c == '-' && i+1 < len(r) && r[i+1] == '-':
+			for i < len(r) && r[i] != '\n' {
+				i++
+			}
+		case c == '/' && i+1 < len(r) && r[i+1] == '*':
+			i = skipBlockComment(r, i)
+		case (c == 'E' || c == 'e') && i+1 < len(r) && r[i+1] == '\'' && (i == 0 || !isIdentRune(r[i-1])):
+			// E'...' はバックスラッシュエスケープを解釈する
+			i = skipQuoted(r, i+1, '\'', true)
+			tokens = append(tokens, "'")
+		case c == '\'':
+			i = skipQuoted(r, i, '\'', false)
+			tokens = append(tokens, "'")
+		case c == '"':
+			i = skipQuoted(r, i, '"', false)
+			tokens = append(tokens, `"`)
+		case c == '$' && dollarTag(r, i) != nil:
+			i = skipDollarQuoted(r, i, dollarTag(r, i))
+			tokens = append(tokens, "'")
+		case isIdentRune(c):
+			start := i
+			for i < len(r) && (isIdentRune(r[i]) || r[i] == '$') {
+				i++
+			}
+			tokens = append(tokens, strings.ToUpper(string(r[start:i])))
+		default:
+			tokens = append(tokens, string(c))
+			i++
+		}
+	}
+	return tokens
+}
+
+// skipBlockComment ネストを考慮してブロックコメントを読み飛ばす
+func skipBlockComment(r []rune, i int) int {
+	depth := 0
+	for i < len(r) {
+		switch {
+		case r[i] == '/' && i+1 < len(r) && r[i+1] == '*':
+			depth++
+			i += 2
+		case r[i] == '*' && i+1 < len(r) && r[i+1] == '/':
+			depth--
+			i += 2
+			if depth == 0 {
+				return i
+			}
+		default:
+			i++
+		}
+	}
+	return i
+}
+
+// skipQuoted 引用符で囲まれた部分を読み飛ばす（二重の引用符はエスケープとして扱う）
+func skipQuoted(r []rune, i int, quote rune, backslash bool) int {
+	i++
+	for i < len(r) {
+		if r[i] == quote {
+			if i+1 < len(r) && r[i+1] == quote {
+				i += 2
+				continue
+			}
+			return i + 1
+		}
+		if backslash && r[i] == '\\' {
+			i++
+		}
+		i++
+	}
+	return i
+}
+
+// dollarTag $tag$ 形式のドル引用符の開始タグを返す。該当しない場合はnil
+func dollarTag(r []rune, i int) []rune {
+	for j := i + 1; j < len(r); j++ {
+		if r[j] == '$' {
+			return r[i : j+1]
+		}
+		if !isIdentRune(r[j]) || (j == i+1 && unicode.IsDigit(r[j])) {
+			return nil
+		}
+	}
+	return nil
+}
+
+// skipDollarQuoted ドル引用符で囲まれた部分を読み飛ばす
+func skipDollarQuoted(r []rune, i int, tag []rune) int {
+	for j := i + len(tag); j+len(tag) <= len(r); j++ {
+		if string(r[j:j+len(tag)]) == string(tag) {
+			return j + len(tag)
+		}
+	}
+	return len(r)
+}
+
+// isIdentRune 識別子・キーワードに使える文字か
+func isIdentRune(c rune) bool {
+	return c == '_' || unicode.IsLetter(c) || unicode.IsDigit(c)
+}
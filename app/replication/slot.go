@@ -0,0 +1,365 @@
+package replication
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ReplicationSlot プライマリのpg_replication_slotsの1行
+type ReplicationSlot struct {
+	Name string
+	// SlotType physical / logical
+	SlotType string
+	// Database 論理スロットのデータベース（物理スロットは空）
+	Database string
+	// Active 接続中のWAL送信プロセスがスロットを使っているか
+	Active    bool
+	ActivePID int
+	// RestartLSN スロットが保持している最も古いWAL位置
+	RestartLSN LSN
+	// RetainedBytes プライマリの現在のWAL位置とRestartLSNの差（スロットのために保持しているWAL量）
+	RetainedBytes int64
+	// WALStatus reserved / extended / unreserved / lost（PostgreSQL 13以降。それより前は空）
+	WALStatus string
+	// SafeWALSize max_slot_wal_keep_size に達してWALが削除されるまでの残り（制限がない場合や取得できない場合は-1）
+	SafeWALSize int64
+	// InactiveSince 非アクティブになった時刻。PostgreSQL 17以降はサーバーの値、
+	// それより前はSlotMonitorが最初に非アクティブを観測した時刻（アクティブな場合はゼロ値）
+	InactiveSince time.Time
+}
+
+// InactiveFor nowの時点で非アクティブになってからの時間（アクティブな場合や不明な場合は0）
+func (s ReplicationSlot) InactiveFor(now time.Time) time.Duration {
+	if s.Active || s.InactiveSince.IsZero() {
+		return 0
+	}
+	return now.Sub(s.InactiveSince)
+}
+
+// replicationSlotsQuery スロット一覧を取得するクエリ。
+// wal_status / safe_wal_size（13以降）と inactive_since（17以降）はバージョンによって存在しないため、jsonb経由で読む
+const replicationSlotsQuery = `SELECT s.slot_name, s.slot_type, COALESCE(s.database, ''), s.active, COALESCE(s.active_pid, 0),
+	s.restart_lsn, COALESCE(pg_wal_lsn_diff(pg_current_wal_lsn(), s.restart_lsn), 0)::bigint,
+	COALESCE(to_jsonb(s)->>'wal_status', ''),
+	(to_jsonb(s)->>'safe_wal_size')::bigint,
+	(to_jsonb(s)->>'inactive_since')::timestamptz
+FROM pg_replication_slots s
+ORDER BY s.slot_name`
+
+// ReplicationSlots プライマリのレプリケーションスロット一覧を取得
+func (c *Cluster) ReplicationSlots() ([]ReplicationSlot, error) {
+	return c.ReplicationSlotsContext(context.Background())
+}
+
+// ReplicationSlotsContext ctxに従って中断できるReplicationSlots
+func (c *Cluster) ReplicationSlotsContext(ctx context.Context) ([]ReplicationSlot, error) {
+	slots, err := replicationSlots(ctx, c.Primary)
+	return slots, c.primaryError("レプリケーションスロット取得", err)
+}
+
+// replicationSlots 指定DB（プライマリ）のpg_replication_slotsを読み取る
+func replicationSlots(ctx context.Context, db *sql.DB) ([]ReplicationSlot, error) {
+	rows, err := db.QueryContext(ctx, replicationSlotsQuery)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rows.Close() }()
+
+	var slots []ReplicationSlot
+	for rows.Next() {
+		var s ReplicationSlot
+		var safeWALSize sql.NullInt64
+		var inactiveSince sql.NullTime
+		if err := rows.Scan(&s.Name, &s.SlotType, &s.Database, &s.Active, &s.ActivePID,
+			&s.RestartLSN, &s.RetainedBytes, &s.WALStatus, &safeWALSize, &inactiveSince); err != nil {
+			return nil, err
+		}
+		s.SafeWALSize = -1
+		if safeWALSize.Valid {
+			s.SafeWALSize = safeWALSize.Int64
+		}
+		if !s.Active {
+			s.InactiveSince = inactiveSince.Time
+		}
+		slots = append(slots, s)
+	}
+	return slots, rows.Err()
+}
+
+// SlotAlertKind スロットのアラートの種類
+type SlotAlertKind string
+
+// スロットのアラートの種類
+const (
+	// SlotAlertInactive 非アクティブの時間がMaxInactiveを超えた
+	SlotAlertInactive SlotAlertKind = "inactive"
+	// SlotAlertRetainedWAL 保持しているWALがMaxRetainedBytesを超えた
+	SlotAlertRetainedWAL SlotAlertKind = "retained_wal"
+	// SlotAlertSafeWALSize WALが削除されるまでの残りがMinSafeWALSizeを下回った
+	SlotAlertSafeWALSize SlotAlertKind = "safe_wal_size"
+	// SlotAlertWALLost wal_statusがunreservedまたはlost（スタンバイに必要なWALが削除される・された）
+	SlotAlertWALLost SlotAlertKind = "wal_lost"
+)
+
+// SlotMonitorConfig レプリケーションスロットの監視設定。閾値が0の項目は判定しない
+type SlotMonitorConfig struct {
+	// Interval 確認間隔（0の場合は30秒）
+	Interval time.Duration
+	// Timeout 1回の確認のタイムアウト（0の場合は5秒）
+	Timeout time.Duration
+	// Slots 監視するスロット名（空の場合は全てのスロット）
+	Slots []string
+	// MaxInactive スロットが非アクティブのままでいられる時間
+	MaxInactive time.Duration
+	// MaxRetainedBytes スロットが保持してよいWALの量
+	MaxRetainedBytes int64
+	// MinSafeWALSize WALが削除されるまでの残りの下限（max_slot_wal_keep_size を設定している場合のみ判定）
+	MinSafeWALSize int64
+	// OnAlert アラートが発生・解消したときに呼ばれる（監視用goroutineから呼ばれる）
+	OnAlert func(SlotAlert)
+	// OnError スロット一覧を取得できなかったときに呼ばれる
+	OnError func(error)
+}
+
+// SlotAlert スロットのアラートの発生・解消
+type SlotAlert struct {
+	Slot string
+	Kind SlotAlertKind
+	// Firing 発生時はtrue、解消時はfalse
+	Firing bool
+	// Message 発生時の理由（解消時は空）
+	Message string
+	// State 判定に使ったスロットの状態（スロットが削除されて解消した場合はゼロ値）
+	State ReplicationSlot
+	At    time.Time
+}
+
+// String ログ出力用の表現
+func (a SlotAlert) String() string {
+	if a.Firing {
+		return fmt.Sprintf("slot %s: %s: %s", a.Slot, a.Kind, a.Message)
+	}
+	return fmt.Sprintf("slot %s: %s resolved", a.Slot, a.Kind)
+}
+
+// SlotMonitor レプリケーションスロットを定期的に確認し、閾値を超えたらアラートを通知する
+type SlotMonitor struct {
+	cluster *Cluster
+	cfg     SlotMonitorConfig
+
+	mu sync.Mutex
+	// inactiveSince サーバーが非アクティブになった時刻を返さない場合に、最初に観測した時刻
+	inactiveSince map[string]time.Time
+	// firing 発生中のアラート（スロット名 → 種類 → メッセージ）
+	firing    map[string]map[SlotAlertKind]string
+	slots     []ReplicationSlot
+	checkedAt time.Time
+	err       error
+
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// StartSlotMonitor スロットの監視を開始する。Cluster.Closeで停止する
+func (c *Cluster) StartSlotMonitor(cfg SlotMonitorConfig) *SlotMonitor {
+	ctx, cancel := context.WithCancel(context.Background())
+	m := newSlotMonitor(c, cfg)
+	m.cancel, m.done = cancel, make(chan struct{})
+	go m.run(ctx)
+
+	c.mu.Lock()
+	c.slotMonitors = append(c.slotMonitors, m)
+	c.mu.Unlock()
+	return m
+}
+
+// newSlotMonitor 監視を開始していないSlotMonitorを作成
+func newSlotMonitor(c *Cluster, cfg SlotMonitorConfig) *SlotMonitor {
+	if cfg.Interval <= 0 {
+		cfg.Interval = 30 * time.Second
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = 5 * time.Second
+	}
+	return &SlotMonitor{
+		cluster:       c,
+		cfg:           cfg,
+		inactiveSince: make(map[string]time.Time),
+		firing:        make(map[string]map[SlotAlertKind]string),
+	}
+}
+
+// Stop 監視を停止する
+func (m *SlotMonitor) Stop() {
+	m.cancel()
+	<-m.done
+}
+
+// Slots 最後に確認したスロットの状態と確認時刻、取得に失敗した場合はそのエラー
+func (m *SlotMonitor) Slots() ([]ReplicationSlot, time.Time, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]ReplicationSlot(nil), m.slots...), m.checkedAt, m.err
+}
+
+// Alerts 発生中のアラート
+func (m *SlotMonitor) Alerts() []SlotAlert {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var alerts []SlotAlert
+	for _, s := range m.slots {
+		for kind, msg := range m.firing[s.Name] {
+			alerts = append(alerts, SlotAlert{Slot: s.Name, Kind: kind, Firing: true, Message: msg, State: s, At: m.checkedAt})
+		}
+	}
+	sortSlotAlerts(alerts)
+	return alerts
+}
+
+// sortSlotAlerts スロット名、種類の順に並べる
+func sortSlotAlerts(alerts []SlotAlert) {
+	sort.Slice(alerts, func(i, j int) bool {
+		if alerts[i].Slot != alerts[j].Slot {
+			return alerts[i].Slot < alerts[j].Slot
+		}
+		return alerts[i].Kind < alerts[j].Kind
+	})
+}
+
+// run 定期的に確認する
+func (m *SlotMonitor) run(ctx context.Context) {
+	defer close(m.done)
+	ticker := time.NewTicker(m.cfg.Interval)
+	defer ticker.Stop()
+
+	for {
+		m.check(ctx, time.Now())
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// check スロット一覧を取得してアラートを判定し、変化したアラートを通知する
+func (m *SlotMonitor) check(ctx context.Context, now time.Time) {
+	checkCtx, cancel := context.WithTimeout(ctx, m.cfg.Timeout)
+	slots, err := m.cluster.ReplicationSlotsContext(checkCtx)
+	cancel()
+	if ctx.Err() != nil {
+		// 停止中の取得失敗は状態に反映しない
+		return
+	}
+	if err != nil {
+		m.mu.Lock()
+		m.err, m.checkedAt = err, now
+		m.mu.Unlock()
+		if m.cfg.OnError != nil {
+			m.cfg.OnError(err)
+		}
+		return
+	}
+	slots = m.filter(slots)
+
+	var changes []SlotAlert
+	m.mu.Lock()
+	seen := make(map[string]bool, len(slots))
+	for i := range slots {
+		s := &slots[i]
+		seen[s.Name] = true
+		if s.Active {
+			delete(m.inactiveSince, s.Name)
+		} else if s.InactiveSince.IsZero() {
+			if _, ok := m.inactiveSince[s.Name]; !ok {
+				m.inactiveSince[s.Name] = now
+			}
+			s.InactiveSince = m.inactiveSince[s.Name]
+		}
+
+		current := m.cfg.evaluate(*s, now)
+		previous := m.firing[s.Name]
+		for kind, msg := range current {
+			if _, ok := previous[kind]; !ok {
+				changes = append(changes, SlotAlert{Slot: s.Name, Kind: kind, Firing: true, Message: msg, State: *s, At: now})
+			}
+		}
+		for kind := range previous {
+			if _, ok := current[kind]; !ok {
+				changes = append(changes, SlotAlert{Slot: s.Name, Kind: kind, State: *s, At: now})
+			}
+		}
+		if len(current) > 0 {
+			m.firing[s.Name] = current
+		} else {
+			delete(m.firing, s.Name)
+		}
+	}
+	// 削除されたスロットのアラートは解消とする
+	for name, previous := range m.firing {
+		if seen[name] {
+			continue
+		}
+		for kind := range previous {
+			changes = append(changes, SlotAlert{Slot: name, Kind: kind, At: now})
+		}
+		delete(m.firing, name)
+	}
+	for name := range m.inactiveSince {
+		if !seen[name] {
+			delete(m.inactiveSince, name)
+		}
+	}
+	m.slots, m.checkedAt, m.err = slots, now, nil
+	m.mu.Unlock()
+
+	sortSlotAlerts(changes)
+	if m.cfg.OnAlert != nil {
+		for _, a := range changes {
+			m.cfg.OnAlert(a)
+		}
+	}
+}
+
+// filter 監視対象のスロットだけを残す
+func (m *SlotMonitor) filter(slots []ReplicationSlot) []ReplicationSlot {
+	if len(m.cfg.Slots) == 0 {
+		return slots
+	}
+	want := make(map[string]bool, len(m.cfg.Slots))
+	for _, name := range m.cfg.Slots {
+		want[name] = true
+	}
+	var filtered []ReplicationSlot
+	for _, s := range slots {
+		if want[s.Name] {
+			filtered = append(filtered, s)
+		}
+	}
+	return filtered
+}
+
+// evaluate スロットの状態から発生中のアラートを判定する
+func (cfg SlotMonitorConfig) evaluate(s ReplicationSlot, now time.Time) map[SlotAlertKind]string {
+	alerts := make(map[SlotAlertKind]string)
+	if inactive := s.InactiveFor(now); cfg.MaxInactive > 0 && inactive > cfg.MaxInactive {
+		alerts[SlotAlertInactive] = fmt.Sprintf("%s 非アクティブです（上限 %s）", inactive.Truncate(time.Second), cfg.MaxInactive)
+	}
+	if cfg.MaxRetainedBytes > 0 && s.RetainedBytes > cfg.MaxRetainedBytes {
+		alerts[SlotAlertRetainedWAL] = fmt.Sprintf("%d bytes のWALを保持しています（上限 %d bytes）", s.RetainedBytes, cfg.MaxRetainedBytes)
+	}
+	if cfg.MinSafeWALSize > 0 && s.SafeWALSize >= 0 && s.SafeWALSize < cfg.MinSafeWALSize {
+		alerts[SlotAlertSafeWALSize] = fmt.Sprintf("WALが削除されるまでの残りが %d bytes です（下限 %d bytes）", s.SafeWALSize, cfg.MinSafeWALSize)
+	}
+	switch s.WALStatus {
+	case "unreserved":
+		alerts[SlotAlertWALLost] = "max_slot_wal_keep_size を超え、次のチェックポイントで必要なWALが削除されます（wal_status=unreserved）"
+	case "lost":
+		alerts[SlotAlertWALLost] = "必要なWALが削除されました。スタンバイの再構築が必要です（wal_status=lost）"
+	}
+	return alerts
+}
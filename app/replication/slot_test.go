@@ -0,0 +1,130 @@
+package replication
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"testing"
+	"time"
+)
+
+// slotRow pg_replication_slotsの1行
+func slotRow(name string, active bool, retained int64, walStatus string, safeWALSize, inactiveSince driver.Value) []driver.Value {
+	pid := int64(0)
+	if active {
+		pid = 200
+	}
+	return []driver.Value{name, "physical", "", active, pid, "0/3000000", retained, walStatus, safeWALSize, inactiveSince}
+}
+
+// TestReplicationSlots pg_replication_slotsの行をスロットの状態に変換するテスト
+func TestReplicationSlots(t *testing.T) {
+	since := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	c := &Cluster{Primary: sql.OpenDB(&tableConnector{rows: [][]driver.Value{
+		slotRow("standby_slot", true, 4096, "reserved", nil, nil),
+		slotRow("old_slot", false, 1<<30, "extended", int64(1<<20), since),
+	}})}
+	defer c.Close()
+
+	slots, err := c.ReplicationSlots()
+	if err != nil {
+		t.Fatalf("取得エラー: %v", err)
+	}
+	if len(slots) != 2 {
+		t.Fatalf("行数が不正: %+v", slots)
+	}
+	s := slots[0]
+	if s.Name != "standby_slot" || !s.Active || s.ActivePID != 200 || s.RestartLSN.String() != "0/3000000" || s.RetainedBytes != 4096 {
+		t.Errorf("スロットの状態が不正: %+v", s)
+	}
+	if s.SafeWALSize != -1 || !s.InactiveSince.IsZero() {
+		t.Errorf("NULLの列の扱いが不正: %+v", s)
+	}
+	if s := slots[1]; s.Active || s.WALStatus != "extended" || s.SafeWALSize != 1<<20 || !s.InactiveSince.Equal(since) {
+		t.Errorf("非アクティブなスロットの状態が不正: %+v", s)
+	}
+
+	failing := &Cluster{Primary: sql.OpenDB(&tableConnector{err: errors.New("permission denied")})}
+	defer failing.Close()
+	var ne *NodeError
+	if _, err := failing.ReplicationSlots(); !errors.As(err, &ne) || ne.Route != RoutePrimary {
+		t.Errorf("プライマリのエラーを返すはず: %v", err)
+	}
+}
+
+// TestSlotMonitorAlerts 閾値を超えたときと戻ったときにだけアラートを通知するテスト
+func TestSlotMonitorAlerts(t *testing.T) {
+	conn := &tableConnector{}
+	c := &Cluster{Primary: sql.OpenDB(conn)}
+	defer c.Close()
+
+	var alerts []SlotAlert
+	m := newSlotMonitor(c, SlotMonitorConfig{
+		Slots:            []string{"standby_slot"},
+		MaxInactive:      time.Minute,
+		MaxRetainedBytes: 1 << 20,
+		MinSafeWALSize:   1 << 20,
+		OnAlert:          func(a SlotAlert) { alerts = append(alerts, a) },
+	})
+	ctx := context.Background()
+	start := time.Date(2026, 1, 2, 3, 0, 0, 0, time.UTC)
+	expect := func(step string, want ...SlotAlert) {
+		t.Helper()
+		if len(alerts) != len(want) {
+			t.Fatalf("%s: アラート = %v, want %v", step, alerts, want)
+		}
+		for i, a := range alerts {
+			if a.Slot != want[i].Slot || a.Kind != want[i].Kind || a.Firing != want[i].Firing {
+				t.Errorf("%s: アラート[%d] = %v, want %v", step, i, a, want[i])
+			}
+		}
+		alerts = nil
+	}
+
+	// 監視対象外のスロットは閾値を超えていても通知しない
+	conn.rows = [][]driver.Value{
+		slotRow("standby_slot", false, 4096, "reserved", nil, nil),
+		slotRow("other_slot", false, 1<<30, "lost", nil, nil),
+	}
+	m.check(ctx, start)
+	expect("非アクティブ直後")
+
+	// 非アクティブの時間はサーバーが返さなくても最初に観測した時刻から数える
+	m.check(ctx, start.Add(2*time.Minute))
+	expect("非アクティブ継続", SlotAlert{Slot: "standby_slot", Kind: SlotAlertInactive, Firing: true})
+	if slots, _, _ := m.Slots(); len(slots) != 1 || !slots[0].InactiveSince.Equal(start) {
+		t.Errorf("非アクティブになった時刻が不正: %+v", slots)
+	}
+
+	// 同じアラートは繰り返し通知しない
+	conn.rows = [][]driver.Value{slotRow("standby_slot", false, 2<<20, "unreserved", int64(4096), nil)}
+	m.check(ctx, start.Add(3*time.Minute))
+	expect("WAL保持量超過",
+		SlotAlert{Slot: "standby_slot", Kind: SlotAlertRetainedWAL, Firing: true},
+		SlotAlert{Slot: "standby_slot", Kind: SlotAlertSafeWALSize, Firing: true},
+		SlotAlert{Slot: "standby_slot", Kind: SlotAlertWALLost, Firing: true})
+	if got := m.Alerts(); len(got) != 4 {
+		t.Errorf("発生中のアラート = %v", got)
+	}
+
+	// スタンバイが再接続して追いつけば全て解消する
+	conn.rows = [][]driver.Value{slotRow("standby_slot", true, 4096, "reserved", nil, nil)}
+	m.check(ctx, start.Add(4*time.Minute))
+	expect("再接続",
+		SlotAlert{Slot: "standby_slot", Kind: SlotAlertInactive},
+		SlotAlert{Slot: "standby_slot", Kind: SlotAlertRetainedWAL},
+		SlotAlert{Slot: "standby_slot", Kind: SlotAlertSafeWALSize},
+		SlotAlert{Slot: "standby_slot", Kind: SlotAlertWALLost})
+
+	// 再び非アクティブになったら観測し直す
+	conn.rows = [][]driver.Value{slotRow("standby_slot", false, 4096, "reserved", nil, nil)}
+	m.check(ctx, start.Add(5*time.Minute))
+	m.check(ctx, start.Add(6*time.Minute+time.Second))
+	expect("再び非アクティブ", SlotAlert{Slot: "standby_slot", Kind: SlotAlertInactive, Firing: true})
+
+	// スロットが削除されたら解消とする
+	conn.rows = nil
+	m.check(ctx, start.Add(7*time.Minute))
+	expect("スロット削除", SlotAlert{Slot: "standby_slot", Kind: SlotAlertInactive})
+}
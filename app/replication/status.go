@@ -0,0 +1,101 @@
+package replication
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// StandbyStatus プライマリのpg_stat_replicationから見たスタンバイ1台分のレプリケーション状態
+type StandbyStatus struct {
+	// PID WAL送信プロセスのPID
+	PID int
+	// ApplicationName スタンバイのprimary_conninfoのapplication_name（未指定の場合はcluster_nameまたはwalreceiver）
+	ApplicationName string
+	// ClientAddr スタンバイのIPアドレス（Unixソケット経由の場合は空）
+	ClientAddr string
+	// State startup / catchup / streaming / backup / stopping
+	State string
+	// SyncState async / potential / sync / quorum
+	SyncState string
+
+	// SentLSN 送信済みのWAL位置
+	SentLSN LSN
+	// WriteLSN スタンバイがディスクに書き込んだWAL位置
+	WriteLSN LSN
+	// FlushLSN スタンバイがフラッシュしたWAL位置
+	FlushLSN LSN
+	// ReplayLSN スタンバイが再生したWAL位置
+	ReplayLSN LSN
+	// LagBytes プライマリの現在のWAL位置とReplayLSNの差
+	LagBytes int64
+
+	// WriteLag / FlushLag / ReplayLag コミットからスタンバイでの書き込み・フラッシュ・再生までの時間。
+	// 新しいWALがなくスタンバイが追いついている間はPostgreSQLがNULLを返すため0になる
+	WriteLag  time.Duration
+	FlushLag  time.Duration
+	ReplayLag time.Duration
+
+	// ReplyTime スタンバイから最後に応答を受け取った時刻
+	ReplyTime time.Time
+}
+
+// Streaming ストリーミング中か
+func (s StandbyStatus) Streaming() bool {
+	return s.State == "streaming"
+}
+
+// standbyStatusQuery スタンバイごとのレプリケーション状態を取得するクエリ（時間は秒で取得する）
+const standbyStatusQuery = `SELECT pid, COALESCE(application_name, ''), COALESCE(host(client_addr), ''),
+	COALESCE(state, ''), COALESCE(sync_state, ''),
+	sent_lsn, write_lsn, flush_lsn, replay_lsn,
+	COALESCE(pg_wal_lsn_diff(pg_current_wal_lsn(), replay_lsn), 0)::bigint,
+	COALESCE(EXTRACT(EPOCH FROM write_lag), 0)::float8,
+	COALESCE(EXTRACT(EPOCH FROM flush_lag), 0)::float8,
+	COALESCE(EXTRACT(EPOCH FROM replay_lag), 0)::float8,
+	reply_time
+FROM pg_stat_replication
+ORDER BY application_name, pid`
+
+// StandbyStatuses プライマリのpg_stat_replicationからスタンバイごとのレプリケーション状態を取得
+func (c *Cluster) StandbyStatuses() ([]StandbyStatus, error) {
+	return c.StandbyStatusesContext(context.Background())
+}
+
+// StandbyStatusesContext ctxに従って中断できるStandbyStatuses
+func (c *Cluster) StandbyStatusesContext(ctx context.Context) ([]StandbyStatus, error) {
+	statuses, err := standbyStatuses(ctx, c.Primary)
+	return statuses, c.primaryError("レプリケーション状態取得", err)
+}
+
+// standbyStatuses 指定DB（プライマリ）のpg_stat_replicationを読み取る
+func standbyStatuses(ctx context.Context, db *sql.DB) ([]StandbyStatus, error) {
+	rows, err := db.QueryContext(ctx, standbyStatusQuery)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rows.Close() }()
+
+	var statuses []StandbyStatus
+	for rows.Next() {
+		var s StandbyStatus
+		var writeLag, flushLag, replayLag float64
+		var replyTime sql.NullTime
+		if err := rows.Scan(&s.PID, &s.ApplicationName, &s.ClientAddr, &s.State, &s.SyncState,
+			&s.SentLSN, &s.WriteLSN, &s.FlushLSN, &s.ReplayLSN, &s.LagBytes,
+			&writeLag, &flushLag, &replayLag, &replyTime); err != nil {
+			return nil, err
+		}
+		s.WriteLag = secondsToDuration(writeLag)
+		s.FlushLag = secondsToDuration(flushLag)
+		s.ReplayLag = secondsToDuration(replayLag)
+		s.ReplyTime = replyTime.Time
+		statuses = append(statuses, s)
+	}
+	return statuses, rows.Err()
+}
+
+// secondsToDuration 秒（小数）をtime.Durationに変換
+func secondsToDuration(sec float64) time.Duration {
+	return time.Duration(sec * float64(time.Second))
+}
@@ -0,0 +1,138 @@
+package replication
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestStandbyStatuses pg_stat_replicationの行をスタンバイごとの状態に変換するテスト
+func TestStandbyStatuses(t *testing.T) {
+	replied := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	db := sql.OpenDB(&tableConnector{rows: [][]driver.Value{
+		{int64(101), "standby1", "172.18.0.3", "streaming", "async",
+			"0/3000148", "0/3000148", "0/3000148", "0/3000060", int64(232),
+			0.0012, 0.0025, 1.5, replied},
+		{int64(102), "walreceiver", "", "catchup", "potential",
+			"0/2000000", nil, nil, nil, int64(0),
+			0.0, 0.0, 0.0, nil},
+	}})
+	defer func() { _ = db.Close() }()
+
+	statuses, err := standbyStatuses(context.Background(), db)
+	if err != nil {
+		t.Fatalf("取得エラー: %v", err)
+	}
+	if len(statuses) != 2 {
+		t.Fatalf("行数が不正: %+v", statuses)
+	}
+
+	s := statuses[0]
+	if s.PID != 101 || s.ApplicationName != "standby1" || s.ClientAddr != "172.18.0.3" || !s.Streaming() || s.SyncState != "async" {
+		t.Errorf("接続情報が不正: %+v", s)
+	}
+	if s.SentLSN.String() != "0/3000148" || s.ReplayLSN.String() != "0/3000060" || s.LagBytes != 232 {
+		t.Errorf("WAL位置が不正: sent=%s replay=%s lag=%d", s.SentLSN, s.ReplayLSN, s.LagBytes)
+	}
+	if s.WriteLag != 1200*time.Microsecond || s.FlushLag != 2500*time.Microsecond || s.ReplayLag != 1500*time.Millisecond {
+		t.Errorf("遅延が不正: write=%v flush=%v replay=%v", s.WriteLag, s.FlushLag, s.ReplayLag)
+	}
+	if !s.ReplyTime.Equal(replied) {
+		t.Errorf("応答時刻が不正: %v", s.ReplyTime)
+	}
+
+	// 追いつく前のスタンバイはNULLの列を0として扱う
+	if s := statuses[1]; s.Streaming() || s.WriteLSN != 0 || s.ReplayLSN != 0 || !s.ReplyTime.IsZero() {
+		t.Errorf("NULLの列の扱いが不正: %+v", s)
+	}
+}
+
+// TestGetReplicationStatus 全スタンバイのreplay_lagの最大値を返し、エラーを握りつぶさないテスト
+func TestGetReplicationStatus(t *testing.T) {
+	row := func(name string, replayLag float64) []driver.Value {
+		return []driver.Value{int64(1), name, "", "streaming", "async", nil, nil, nil, nil, int64(0), 0.0, 0.0, replayLag, nil}
+	}
+	c := &Cluster{Primary: sql.OpenDB(&tableConnector{rows: [][]driver.Value{row("a", 0.25), row("b", 2), row("c", 0)}})}
+	defer c.Close()
+
+	lag, err := c.GetReplicationStatus()
+	if err != nil || lag != 2 {
+		t.Errorf("遅延 = %v, %v, want 2", lag, err)
+	}
+
+	failing := &Cluster{Primary: sql.OpenDB(&tableConnector{err: errors.New("permission denied")})}
+	defer failing.Close()
+	var ne *NodeError
+	if _, err := failing.GetReplicationStatus(); !errors.As(err, &ne) || ne.Route != RoutePrimary {
+		t.Errorf("プライマリのエラーを返すはず: %v", err)
+	}
+}
+
+// tableConnector 固定の行を返すテスト用コネクタ。queriesにクエリの一部が含まれていればその行を、なければrowsを返す。
+// Execしたクエリはexecsに記録する
+type tableConnector struct {
+	rows    [][]driver.Value
+	queries map[string][][]driver.Value
+	err     error
+
+	mu    sync.Mutex
+	execs []string
+}
+
+func (c *tableConnector) Connect(context.Context) (driver.Conn, error) { return &tableConn{c}, nil }
+func (c *tableConnector) Driver() driver.Driver                        { return nil }
+
+type tableConn struct{ c *tableConnector }
+
+func (c *tableConn) Prepare(string) (driver.Stmt, error) { return nil, errors.New("not supported") }
+func (c *tableConn) Close() error                        { return nil }
+func (c *tableConn) Begin() (driver.Tx, error)           { return nil, errors.New("not supported") }
+
+func (c *tableConn) QueryContext(_ context.Context, query string, _ []driver.NamedValue) (driver.Rows, error) {
+	if c.c.err != nil {
+		return nil, c.c.err
+	}
+	for key, rows := range c.c.queries {
+		if strings.Contains(query, key) {
+			return &tableRows{rows: rows}, nil
+		}
+	}
+	return &tableRows{rows: c.c.rows}, nil
+}
+
+func (c *tableConn) ExecContext(_ context.Context, query string, _ []driver.NamedValue) (driver.Result, error) {
+	if c.c.err != nil {
+		return nil, c.c.err
+	}
+	c.c.mu.Lock()
+	defer c.c.mu.Unlock()
+	c.c.execs = append(c.c.execs, query)
+	return driver.RowsAffected(1), nil
+}
+
+type tableRows struct {
+	rows [][]driver.Value
+	next int
+}
+
+func (r *tableRows) Columns() []string {
+	if len(r.rows) == 0 {
+		return nil
+	}
+	return make([]string, len(r.rows[0]))
+}
+func (r *tableRows) Close() error { return nil }
+func (r *tableRows) Next(dest []driver.Value) error {
+	if r.next >= len(r.rows) {
+		return io.EOF
+	}
+	copy(dest, r.rows[r.next])
+	r.next++
+	return nil
+}
@@ -0,0 +1,69 @@
+package replication
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// defaultStickyPrimaryWindow 書き込み後に同じセッションの読み取りをプライマリへ固定する期間のデフォルト
+const defaultStickyPrimaryWindow = 2 * time.Second
+
+// sessionKey contextにセッションキーを格納するためのキー
+type sessionKey struct{}
+
+// WithSession セッションキーをctxに設定する。同じキーで書き込んだ直後の読み取りは
+// Config.StickyPrimaryWindow の間プライマリで処理される
+func WithSession(ctx context.Context, key string) context.Context {
+	return context.WithValue(ctx, sessionKey{}, key)
+}
+
+// SessionFromContext ctxに設定されたセッションキーを返す
+func SessionFromContext(ctx context.Context) (string, bool) {
+	key, ok := ctx.Value(sessionKey{}).(string)
+	return key, ok && key != ""
+}
+
+// stickySessions セッションごとにプライマリへ固定する期限を管理する
+type stickySessions struct {
+	mu        sync.Mutex
+	until     map[string]time.Time
+	lastSweep time.Time
+}
+
+// mark ctxのセッションをwindowの間プライマリへ固定する
+func (s *stickySessions) mark(ctx context.Context, window time.Duration) {
+	key, ok := SessionFromContext(ctx)
+	if !ok || window <= 0 {
+		return
+	}
+
+	now := time.Now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.until == nil {
+		s.until = make(map[string]time.Time)
+	}
+	s.until[key] = now.Add(window)
+
+	// 期限切れのセッションを定期的に削除する
+	if now.Sub(s.lastSweep) > window {
+		for k, t := range s.until {
+			if now.After(t) {
+				delete(s.until, k)
+			}
+		}
+		s.lastSweep = now
+	}
+}
+
+// active ctxのセッションがプライマリへ固定されているか
+func (s *stickySessions) active(ctx context.Context) (string, bool) {
+	key, ok := SessionFromContext(ctx)
+	if !ok {
+		return "", false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return key, time.Now().Before(s.until[key])
+}
@@ -0,0 +1,63 @@
+package replication
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+	"time"
+)
+
+// TestStickyPrimaryAfterWrite 書き込んだセッションの読み取りだけが一定期間プライマリへ固定されるテスト
+func TestStickyPrimaryAfterWrite(t *testing.T) {
+	var calls []string
+	c := NewConnector(&fakeConnector{name: "primary", calls: &calls}, &fakeConnector{name: "standby", calls: &calls})
+	c.StickyWindow = 50 * time.Millisecond
+	db := sql.OpenDB(c)
+	defer func() { _ = db.Close() }()
+
+	alice := WithSession(context.Background(), "alice")
+	bob := WithSession(context.Background(), "bob")
+	query := func(ctx context.Context) string {
+		t.Helper()
+		rows, err := db.QueryContext(ctx, "SELECT 1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		_ = rows.Close()
+		return calls[len(calls)-1]
+	}
+
+	if _, err := db.ExecContext(alice, "INSERT INTO t VALUES (1)"); err != nil {
+		t.Fatal(err)
+	}
+	if got := query(alice); got != "primary:query" {
+		t.Fatalf("書き込み直後のセッションはプライマリで読み取るはず: %s", got)
+	}
+	if got := query(bob); got != "standby:query" {
+		t.Fatalf("他のセッションはスタンバイで読み取るはず: %s", got)
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	if got := query(alice); got != "standby:query" {
+		t.Fatalf("期間経過後はスタンバイで読み取るはず: %s", got)
+	}
+}
+
+// TestClusterStickyPrimary Clusterの読み取りがセッションの固定期間中はプライマリになるテスト
+func TestClusterStickyPrimary(t *testing.T) {
+	node := &Node{Name: "standby1", Weight: 1}
+	node.healthy.Store(true)
+	c := &Cluster{Standbys: []*Node{node}}
+	c.SetRoutingPolicy(&RoundRobinPolicy{})
+
+	ctx := WithSession(context.Background(), "alice")
+	c.sticky.mark(ctx, time.Minute)
+
+	var info ReadInfo
+	if n, err := c.chooseStandby(ctx, &readOptions{}, &info); n != nil || err != nil || info.Reason == "" {
+		t.Fatalf("固定期間中はプライマリで読み取るはず: %v %v %+v", n, err, info)
+	}
+	if n, _ := c.chooseStandby(context.Background(), &readOptions{}, &ReadInfo{}); n != node {
+		t.Fatal("セッション未指定の読み取りはスタンバイで処理されるはず")
+	}
+}
@@ -0,0 +1,95 @@
+package replication
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// SSLモード（lib/pqが対応するもの）
+const (
+	SSLDisable    = "disable"
+	SSLRequire    = "require"
+	SSLVerifyCA   = "verify-ca"
+	SSLVerifyFull = "verify-full"
+)
+
+// TLSConfig ノードへの接続のTLS設定。ノードごとの設定では、空の項目はConfig.TLSの値を引き継ぐ
+type TLSConfig struct {
+	// SSLMode disable / require / verify-ca / verify-full（空の場合はdisable）。
+	// verify-fullはサーバー証明書がCAで署名され、接続先のホスト名と一致することを確認する
+	SSLMode string
+	// RootCert サーバー証明書を検証するCA証明書のファイル
+	RootCert string
+	// Cert クライアント証明書のファイル（pg_hba.confのclientcertで要求される場合）
+	Cert string
+	// Key クライアント証明書の秘密鍵のファイル（パーミッションは0600以下）
+	Key string
+}
+
+// Mode 実際に使うSSLモード
+func (t TLSConfig) Mode() string {
+	if t.SSLMode == "" {
+		return SSLDisable
+	}
+	return t.SSLMode
+}
+
+// Validate SSLモードと証明書の指定を確認する
+func (t TLSConfig) Validate() error {
+	switch t.Mode() {
+	case SSLDisable, SSLRequire, SSLVerifyCA, SSLVerifyFull:
+	default:
+		return fmt.Errorf("不明なsslmodeです: %q（disable / require / verify-ca / verify-full）", t.SSLMode)
+	}
+	if (t.Cert == "") != (t.Key == "") {
+		return errors.New("クライアント証明書と秘密鍵は両方指定してください")
+	}
+	return nil
+}
+
+// merge overrideで指定された項目を上書きした設定を返す
+func (t TLSConfig) merge(override TLSConfig) TLSConfig {
+	if override.SSLMode != "" {
+		t.SSLMode = override.SSLMode
+	}
+	if override.RootCert != "" {
+		t.RootCert = override.RootCert
+	}
+	if override.Cert != "" {
+		t.Cert = override.Cert
+	}
+	if override.Key != "" {
+		t.Key = override.Key
+	}
+	return t
+}
+
+// params 接続文字列に追加するssl関連のパラメータ（sslmode=disableの場合は証明書を指定しない）
+func (t TLSConfig) params() string {
+	mode := t.Mode()
+	params := []string{"sslmode=" + mode}
+	if mode != SSLDisable {
+		for _, p := range []struct{ key, value string }{
+			{"sslrootcert", t.RootCert}, {"sslcert", t.Cert}, {"sslkey", t.Key},
+		} {
+			if p.value != "" {
+				params = append(params, p.key+"="+quoteDSNValue(p.value))
+			}
+		}
+	}
+	return strings.Join(params, " ")
+}
+
+// TLSFor 指定ノードへの接続に使うTLS設定（Config.TLSにPrimaryTLSまたはスタンバイのTLSを上書きしたもの）
+func (c Config) TLSFor(e Endpoint) TLSConfig {
+	if e == c.Primary {
+		return c.TLS.merge(c.PrimaryTLS)
+	}
+	for _, sc := range c.Standbys {
+		if sc.Endpoint == e {
+			return c.TLS.merge(sc.TLS)
+		}
+	}
+	return c.TLS
+}
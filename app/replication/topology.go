@@ -0,0 +1,362 @@
+package replication
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+// TopologyFileEnv トポロジーファイルのパスを指定する環境変数
+const TopologyFileEnv = "POSTGRES_TOPOLOGY_FILE"
+
+// ノードの役割
+const (
+	RolePrimary = "primary"
+	RoleStandby = "standby"
+)
+
+// Topology トポロジーファイル（JSON）の形式。省略した項目はデフォルト値のまま。
+//
+//	{
+//	  "user": "postgres",
+//	  "dbname": "testdb",
+//	  "routing_policy": "weighted",
+//	  "pool": {"max_open_conns": 10, "max_idle_conns": 5, "conn_max_lifetime": "30m"},
+//	  "max_staleness": "2s",
+//	  "nodes": [
+//	    {"name": "primary", "role": "primary", "host": "localhost", "port": 5432, "zone": "a"},
+//	    {"name": "standby1", "role": "standby", "host": "localhost", "port": 5433, "weight": 3, "zone": "b"}
+//	  ]
+//	}
+type Topology struct {
+	User     string `json:"user,omitempty"`
+	Password string `json:"password,omitempty"`
+	DBName   string `json:"dbname,omitempty"`
+
+	// RoutingPolicy スタンバイの選択方式（round-robin / weighted / least-connections / least-lag）
+	RoutingPolicy string `json:"routing_policy,omitempty"`
+	// ConnectTimeout 接続タイムアウト（秒単位に切り上げる）
+	ConnectTimeout Duration `json:"connect_timeout,omitempty"`
+	// Pool プライマリと、指定のないスタンバイのコネクションプール設定
+	Pool *TopologyPool `json:"pool,omitempty"`
+
+	// ReplayWaitTimeout AfterLSN指定の読み取りでスタンバイの再生を待つ最大時間
+	ReplayWaitTimeout Duration `json:"replay_wait_timeout,omitempty"`
+	// StickyPrimaryWindow 書き込み後にセッションの読み取りをプライマリへ固定する期間
+	StickyPrimaryWindow Duration `json:"sticky_primary_window,omitempty"`
+	// Retry リカバリ競合時のリトライ設定
+	Retry *TopologyRetry `json:"retry,omitempty"`
+
+	// MaxStaleness 指定のないスタンバイで許容する再生遅延
+	MaxStaleness Duration `json:"max_staleness,omitempty"`
+	// MaxLagBytes 指定のないスタンバイで許容するWAL位置の差
+	MaxLagBytes int64 `json:"max_lag_bytes,omitempty"`
+
+	// Credentials パスワードの取得方法（省略時はpassword）
+	Credentials *TopologyCredentials `json:"credentials,omitempty"`
+	// TLS 全ノードへの接続のTLS設定（ノードごとの tls で上書きできる）
+	TLS *TopologyTLS `json:"tls,omitempty"`
+
+	// PrimaryContainer 指定した場合はプライマリの監視をdocker exec経由で行う
+	PrimaryContainer string `json:"primary_container,omitempty"`
+
+	// Nodes プライマリ1台とスタンバイ1台以上
+	Nodes []TopologyNode `json:"nodes"`
+}
+
+// TopologyNode トポロジーファイルのノード1台分
+type TopologyNode struct {
+	Name string `json:"name,omitempty"`
+	// Role primary または standby
+	Role string `json:"role"`
+	Host string `json:"host"`
+	Port int    `json:"port,omitempty"`
+	// Weight 重み付きルーティングでの重み（スタンバイのみ）
+	Weight int    `json:"weight,omitempty"`
+	Zone   string `json:"zone,omitempty"`
+	// Pool このノードのコネクションプール設定（スタンバイのみ）
+	Pool *TopologyPool `json:"pool,omitempty"`
+	// MaxStaleness このノードで許容する再生遅延（スタンバイのみ）
+	MaxStaleness Duration `json:"max_staleness,omitempty"`
+	// MaxLagBytes このノードで許容するWAL位置の差（スタンバイのみ）
+	MaxLagBytes int64 `json:"max_lag_bytes,omitempty"`
+	// TLS このノードへの接続のTLS設定（省略した項目はトップレベルの tls を使う）
+	TLS *TopologyTLS `json:"tls,omitempty"`
+}
+
+// TopologyTLS TLS設定。証明書のパスは実行時のカレントディレクトリからの相対パスで指定する
+type TopologyTLS struct {
+	// SSLMode disable / require / verify-ca / verify-full
+	SSLMode  string `json:"sslmode,omitempty"`
+	RootCert string `json:"root_cert,omitempty"`
+	Cert     string `json:"cert,omitempty"`
+	Key      string `json:"key,omitempty"`
+}
+
+// config TLSConfigに変換する（nilの場合は空の設定）
+func (t *TopologyTLS) config() TLSConfig {
+	if t == nil {
+		return TLSConfig{}
+	}
+	return TLSConfig{SSLMode: t.SSLMode, RootCert: t.RootCert, Cert: t.Cert, Key: t.Key}
+}
+
+// TopologyCredentials パスワードの取得方法。いずれか1つを指定する
+type TopologyCredentials struct {
+	// PasswordFile パスワードのファイル（UserFileでユーザー名のファイルも指定できる）
+	PasswordFile string `json:"password_file,omitempty"`
+	UserFile     string `json:"user_file,omitempty"`
+	// Passfile .pgpass形式のファイル（"default" の場合はPGPASSFILEまたは ~/.pgpass）
+	Passfile string `json:"passfile,omitempty"`
+	// Command パスワードを出力するコマンドと引数
+	Command []string `json:"command,omitempty"`
+	// CacheTTL コマンドの結果をキャッシュする時間（省略時は5分）
+	CacheTTL Duration `json:"cache_ttl,omitempty"`
+}
+
+// provider 指定された取得方法のCredentialProviderを返す
+func (t TopologyCredentials) provider() (CredentialProvider, error) {
+	var providers []CredentialProvider
+	if t.PasswordFile != "" {
+		providers = append(providers, PasswordFile{Path: t.PasswordFile, UserPath: t.UserFile})
+	} else if t.UserFile != "" {
+		return nil, errors.New("credentials: user_file は password_file と合わせて指定してください")
+	}
+	if t.Passfile == "default" {
+		providers = append(providers, Pgpass{})
+	} else if t.Passfile != "" {
+		providers = append(providers, Pgpass{Path: t.Passfile})
+	}
+	if len(t.Command) > 0 {
+		ttl := time.Duration(t.CacheTTL)
+		if ttl == 0 {
+			ttl = defaultCredentialTTL
+		}
+		providers = append(providers, &ExecCredentials{Command: t.Command, TTL: ttl})
+	}
+	if len(providers) != 1 {
+		return nil, errors.New("credentials には password_file / passfile / command のいずれか1つを指定してください")
+	}
+	return providers[0], nil
+}
+
+// TopologyPool コネクションプール設定
+type TopologyPool struct {
+	MaxOpenConns    int      `json:"max_open_conns,omitempty"`
+	MaxIdleConns    int      `json:"max_idle_conns,omitempty"`
+	ConnMaxLifetime Duration `json:"conn_max_lifetime,omitempty"`
+}
+
+// TopologyRetry リトライ設定
+type TopologyRetry struct {
+	MaxRetries     *int     `json:"max_retries,omitempty"`
+	InitialBackoff Duration `json:"initial_backoff,omitempty"`
+	MaxBackoff     Duration `json:"max_backoff,omitempty"`
+	Budget         Duration `json:"budget,omitempty"`
+}
+
+// Duration JSONで "500ms" や "5s" のように指定する時間
+type Duration time.Duration
+
+// UnmarshalJSON time.ParseDurationの形式の文字列を読み込む
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return fmt.Errorf("時間は \"5s\" のような文字列で指定してください: %s", b)
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil || v < 0 {
+		return fmt.Errorf("不正な時間です: %q", s)
+	}
+	*d = Duration(v)
+	return nil
+}
+
+// MarshalJSON time.Durationの文字列表現で書き出す
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// Load 接続設定を読み込む。POSTGRES_TOPOLOGY_FILEが設定されていればトポロジーファイルを、
+// なければ環境変数のみを使う
+func Load() (Config, error) {
+	if path := os.Getenv(TopologyFileEnv); path != "" {
+		return LoadConfigFile(path)
+	}
+	return LoadConfig(), nil
+}
+
+// LoadConfigFile トポロジーファイルから接続設定を読み込む。
+// POSTGRES_* 環境変数が設定されている項目はファイルより優先される
+func LoadConfigFile(path string) (Config, error) {
+	data, err := os.ReadFile(path) // #nosec G304 -- 設定ファイルのパスは利用者が指定する
+	if err != nil {
+		return Config{}, fmt.Errorf("トポロジーファイル読み込みエラー: %w", err)
+	}
+	cfg, err := ParseTopology(data)
+	if err != nil {
+		return Config{}, fmt.Errorf("%s: %w", path, err)
+	}
+	applyEnv(&cfg)
+	return cfg, nil
+}
+
+// ParseTopology トポロジーファイルの内容をデフォルト値に適用した設定を返す（環境変数は適用しない）
+func ParseTopology(data []byte) (Config, error) {
+	var t Topology
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&t); err != nil {
+		return Config{}, fmt.Errorf("トポロジーファイルパースエラー: %w", err)
+	}
+	cfg := defaultConfig()
+	if err := t.apply(&cfg); err != nil {
+		return Config{}, err
+	}
+	return cfg, nil
+}
+
+// apply ファイルで指定された項目をcfgに反映する
+func (t Topology) apply(cfg *Config) error {
+	if t.User != "" {
+		cfg.User = t.User
+	}
+	if t.Password != "" {
+		cfg.Password = t.Password
+	}
+	if t.DBName != "" {
+		cfg.DBName = t.DBName
+	}
+	if t.RoutingPolicy != "" {
+		if _, err := NewRoutingPolicy(t.RoutingPolicy); err != nil {
+			return err
+		}
+		cfg.RoutingPolicy = t.RoutingPolicy
+	}
+	if t.ConnectTimeout > 0 {
+		cfg.ConnectTimeout = int((time.Duration(t.ConnectTimeout) + time.Second - 1) / time.Second)
+	}
+	if t.Pool != nil {
+		if t.Pool.MaxOpenConns > 0 {
+			cfg.MaxOpenConns = t.Pool.MaxOpenConns
+		}
+		if t.Pool.MaxIdleConns > 0 {
+			cfg.MaxIdleConns = t.Pool.MaxIdleConns
+		}
+		if t.Pool.ConnMaxLifetime > 0 {
+			cfg.ConnMaxLifetime = time.Duration(t.Pool.ConnMaxLifetime)
+		}
+	}
+	if t.ReplayWaitTimeout > 0 {
+		cfg.ReplayWaitTimeout = time.Duration(t.ReplayWaitTimeout)
+	}
+	if t.StickyPrimaryWindow > 0 {
+		cfg.StickyPrimaryWindow = time.Duration(t.StickyPrimaryWindow)
+	}
+	if r := t.Retry; r != nil {
+		if r.MaxRetries != nil {
+			cfg.Retry.MaxRetries = *r.MaxRetries
+		}
+		if r.InitialBackoff > 0 {
+			cfg.Retry.InitialBackoff = time.Duration(r.InitialBackoff)
+		}
+		if r.MaxBackoff > 0 {
+			cfg.Retry.MaxBackoff = time.Duration(r.MaxBackoff)
+		}
+		if r.Budget > 0 {
+			cfg.Retry.Budget = time.Duration(r.Budget)
+		}
+	}
+	if t.TLS != nil {
+		cfg.TLS = t.TLS.config()
+		if err := cfg.TLS.Validate(); err != nil {
+			return fmt.Errorf("tls: %w", err)
+		}
+	}
+	if t.Credentials != nil {
+		provider, err := t.Credentials.provider()
+		if err != nil {
+			return err
+		}
+		cfg.Credentials = provider
+	}
+	// ファイルで構成を宣言した場合は直接接続で監視する
+	cfg.PrimaryDirect = t.PrimaryContainer == ""
+	if t.PrimaryContainer != "" {
+		cfg.PrimaryContainer = t.PrimaryContainer
+	}
+
+	return t.applyNodes(cfg)
+}
+
+// applyNodes ノード一覧をプライマリとスタンバイの設定に変換する
+func (t Topology) applyNodes(cfg *Config) error {
+	var primaries int
+	var standbys []StandbyConfig
+	names := make(map[string]bool)
+	for i, n := range t.Nodes {
+		if n.Host == "" {
+			return fmt.Errorf("nodes[%d]: host の指定が必要です", i)
+		}
+		port := n.Port
+		if port == 0 {
+			port = 5432
+		}
+		endpoint := Endpoint{Host: normalizeHost(n.Host), Port: port}
+		name := n.Name
+		if name == "" {
+			name = endpoint.String()
+		}
+		if names[name] {
+			return fmt.Errorf("nodes[%d]: ノード名 %s が重複しています", i, name)
+		}
+		names[name] = true
+		if err := cfg.TLS.merge(n.TLS.config()).Validate(); err != nil {
+			return fmt.Errorf("nodes[%d]: tls: %w", i, err)
+		}
+
+		switch n.Role {
+		case RolePrimary:
+			if n.Weight != 0 || n.Pool != nil || n.MaxStaleness != 0 || n.MaxLagBytes != 0 {
+				return fmt.Errorf("nodes[%d]: プライマリには weight / pool / max_staleness / max_lag_bytes を指定できません（プールはトップレベルの pool で指定）", i)
+			}
+			primaries++
+			cfg.Primary = endpoint
+			cfg.PrimaryZone = n.Zone
+			cfg.PrimaryTLS = n.TLS.config()
+		case RoleStandby:
+			sc := StandbyConfig{
+				Name: n.Name, Endpoint: endpoint, Weight: n.Weight, Zone: n.Zone,
+				MaxStaleness: time.Duration(t.MaxStaleness), MaxLagBytes: t.MaxLagBytes,
+				TLS: n.TLS.config(),
+			}
+			if n.Pool != nil {
+				if n.Pool.ConnMaxLifetime != 0 {
+					return fmt.Errorf("nodes[%d]: conn_max_lifetime はトップレベルの pool で指定してください", i)
+				}
+				sc.MaxOpenConns, sc.MaxIdleConns = n.Pool.MaxOpenConns, n.Pool.MaxIdleConns
+			}
+			if n.MaxStaleness > 0 {
+				sc.MaxStaleness = time.Duration(n.MaxStaleness)
+			}
+			if n.MaxLagBytes > 0 {
+				sc.MaxLagBytes = n.MaxLagBytes
+			}
+			standbys = append(standbys, sc)
+		default:
+			return fmt.Errorf("nodes[%d]: role には %s または %s を指定してください: %q", i, RolePrimary, RoleStandby, n.Role)
+		}
+	}
+
+	if primaries != 1 {
+		return fmt.Errorf("nodes にはプライマリを1台指定してください（%d台）", primaries)
+	}
+	if len(standbys) == 0 {
+		return errors.New("nodes にスタンバイが指定されていません")
+	}
+	cfg.Standbys = standbys
+	return nil
+}
@@ -0,0 +1,166 @@
+package replication
+
+import (
+	"context"
+	"database/sql"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// TestLoadConfigFile トポロジーファイルと環境変数による上書きのテスト
+func TestLoadConfigFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "topology.json")
+	data := `{
+		"user": "app",
+		"password": "from-file",
+		"routing_policy": "weighted",
+		"pool": {"max_open_conns": 20, "conn_max_lifetime": "10m"},
+		"max_staleness": "2s",
+		"retry": {"max_retries": 0},
+		"nodes": [
+			{"name": "primary", "role": "primary", "host": "db1", "zone": "a"},
+			{"name": "standby1", "role": "standby", "host": "db2", "port": 5433, "weight": 3, "zone": "b"},
+			{"role": "standby", "host": "db3", "pool": {"max_open_conns": 4}, "max_staleness": "500ms", "max_lag_bytes": 1048576}
+		]
+	}`
+	if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("POSTGRES_PASSWORD", "from-env")
+	t.Setenv("POSTGRES_PRIMARY_HOST", "")
+	t.Setenv("POSTGRES_STANDBY_HOSTS", "")
+	t.Setenv("POSTGRES_STANDBY_HOST", "")
+	t.Setenv("POSTGRES_STANDBY_PORT", "")
+
+	cfg, err := LoadConfigFile(path)
+	if err != nil {
+		t.Fatalf("読み込みエラー: %v", err)
+	}
+	if cfg.User != "app" || cfg.Password != "from-env" || cfg.DBName != "testdb" {
+		t.Errorf("認証情報が不正（環境変数が優先されるはず）: %s %s %s", cfg.User, cfg.Password, cfg.DBName)
+	}
+	if cfg.Primary != (Endpoint{Host: "db1", Port: 5432}) || cfg.PrimaryZone != "a" || !cfg.PrimaryDirect {
+		t.Errorf("プライマリが不正: %+v zone=%s direct=%v", cfg.Primary, cfg.PrimaryZone, cfg.PrimaryDirect)
+	}
+	if cfg.RoutingPolicy != PolicyWeighted || cfg.MaxOpenConns != 20 || cfg.MaxIdleConns != 5 ||
+		cfg.ConnMaxLifetime != 10*time.Minute || cfg.Retry.MaxRetries != 0 {
+		t.Errorf("全体設定が不正: %+v", cfg)
+	}
+
+	want := []StandbyConfig{
+		{Name: "standby1", Endpoint: Endpoint{Host: "db2", Port: 5433}, Weight: 3, Zone: "b", MaxStaleness: 2 * time.Second},
+		{Endpoint: Endpoint{Host: "db3", Port: 5432}, MaxOpenConns: 4, MaxStaleness: 500 * time.Millisecond, MaxLagBytes: 1 << 20},
+	}
+	if len(cfg.Standbys) != len(want) {
+		t.Fatalf("スタンバイ数が不正: %+v", cfg.Standbys)
+	}
+	for i := range want {
+		if cfg.Standbys[i] != want[i] {
+			t.Errorf("スタンバイ%dが不正: %+v, want %+v", i, cfg.Standbys[i], want[i])
+		}
+	}
+	if p := cfg.standbyPool(cfg.Standbys[1]); p.maxOpenConns != 4 || p.maxIdleConns != 5 {
+		t.Errorf("ノードのプール設定が不正: %+v", p)
+	}
+}
+
+// TestParseTopologyInvalid 不正なトポロジーファイルのテスト
+func TestParseTopologyInvalid(t *testing.T) {
+	cases := map[string]string{
+		"プライマリなし":    `{"nodes": [{"role": "standby", "host": "db2"}]}`,
+		"スタンバイなし":    `{"nodes": [{"role": "primary", "host": "db1"}]}`,
+		"不明な役割":      `{"nodes": [{"role": "primary", "host": "db1"}, {"role": "replica", "host": "db2"}]}`,
+		"名前の重複":      `{"nodes": [{"role": "primary", "host": "db1"}, {"role": "standby", "host": "db1"}]}`,
+		"プライマリの重み":   `{"nodes": [{"role": "primary", "host": "db1", "weight": 2}, {"role": "standby", "host": "db2"}]}`,
+		"不正な時間":      `{"max_staleness": 5, "nodes": [{"role": "primary", "host": "db1"}, {"role": "standby", "host": "db2"}]}`,
+		"不明なポリシー":    `{"routing_policy": "random", "nodes": [{"role": "primary", "host": "db1"}, {"role": "standby", "host": "db2"}]}`,
+		"不明なキー":      `{"standbys": [], "nodes": [{"role": "primary", "host": "db1"}, {"role": "standby", "host": "db2"}]}`,
+		"不明なsslmode": `{"tls": {"sslmode": "prefer"}, "nodes": [{"role": "primary", "host": "db1"}, {"role": "standby", "host": "db2"}]}`,
+		"秘密鍵なし":      `{"nodes": [{"role": "primary", "host": "db1", "tls": {"cert": "client.crt"}}, {"role": "standby", "host": "db2"}]}`,
+		"認証情報の重複":    `{"credentials": {"password_file": "/run/secrets/pw", "passfile": "default"}, "nodes": [{"role": "primary", "host": "db1"}, {"role": "standby", "host": "db2"}]}`,
+	}
+	for name, data := range cases {
+		if _, err := ParseTopology([]byte(data)); err == nil {
+			t.Errorf("%s: エラーにならない", name)
+		}
+	}
+}
+
+// TestClusterReload スタンバイの削除と設定変更が実行中のノードを保ったまま反映されるテスト
+func TestClusterReload(t *testing.T) {
+	defer func(d time.Duration) { retireDelay = d }(retireDelay)
+	retireDelay = 0
+
+	var down atomic.Bool
+	cfg := Config{
+		Primary:       Endpoint{Host: "db1", Port: 5432},
+		RoutingPolicy: PolicyRoundRobin,
+		Standbys: []StandbyConfig{
+			{Name: "standby1", Endpoint: Endpoint{Host: "db2", Port: 5432}, Weight: 1},
+			{Name: "standby2", Endpoint: Endpoint{Host: "db3", Port: 5432}, Weight: 1},
+			{Name: "standby3", Endpoint: Endpoint{Host: "db4", Port: 5432}, Weight: 1},
+		},
+	}
+	c := &Cluster{Config: cfg, Primary: sql.OpenDB(&probeConnector{down: &down})}
+	c.SetRoutingPolicy(&RoundRobinPolicy{})
+	for _, sc := range cfg.Standbys {
+		n := nodeFromConfig(sc, sql.OpenDB(&probeConnector{down: &down}))
+		n.healthy.Store(true)
+		c.Standbys = append(c.Standbys, n)
+	}
+	defer c.Close()
+	standby1, standby2, standby3 := c.Standbys[0], c.Standbys[1], c.Standbys[2]
+	h := c.StartHealthCheck(HealthCheckConfig{Interval: time.Hour})
+
+	next := cfg
+	next.RoutingPolicy = PolicyWeighted
+	next.Standbys = []StandbyConfig{
+		{Name: "standby1", Endpoint: Endpoint{Host: "db2", Port: 5432}, Weight: 1},
+		{Name: "standby2", Endpoint: Endpoint{Host: "db3", Port: 5432}, Weight: 5, MaxStaleness: time.Second},
+	}
+	if err := c.Reload(context.Background(), next); err != nil {
+		t.Fatalf("Reloadエラー: %v", err)
+	}
+
+	nodes := c.StandbyNodes()
+	if len(nodes) != 2 || nodes[0] != standby1 {
+		t.Fatalf("変更のないノードはそのまま残るはず: %v", nodes)
+	}
+	if nodes[1] == standby2 || nodes[1].DB != standby2.DB || nodes[1].Weight != 5 || nodes[1].MaxStaleness != time.Second {
+		t.Fatalf("設定を変更したノードは接続を引き継ぐはず: %+v", nodes[1])
+	}
+	if _, ok := c.RoutingPolicy().(*WeightedPolicy); !ok || c.CurrentConfig().RoutingPolicy != PolicyWeighted {
+		t.Errorf("ルーティングポリシーが反映されていない: %T", c.RoutingPolicy())
+	}
+
+	h.mu.Lock()
+	watching := len(h.stops)
+	_, watchingNew := h.stops[nodes[1]]
+	_, watchingOld := h.stops[standby3]
+	h.mu.Unlock()
+	if watching != 2 || !watchingNew || watchingOld {
+		t.Errorf("ヘルスチェック対象が反映されていない: %d台 new=%v old=%v", watching, watchingNew, watchingOld)
+	}
+
+	// 外したスタンバイの接続は猶予の後に閉じられ、残したノードの接続は使える
+	deadline := time.Now().Add(time.Second)
+	for standby3.DB.Ping() == nil {
+		if time.Now().After(deadline) {
+			t.Fatal("削除したスタンバイの接続が閉じられない")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err := nodes[1].DB.Ping(); err != nil {
+		t.Errorf("引き継いだ接続が閉じられた: %v", err)
+	}
+
+	changed := next
+	changed.Primary.Port = 6432
+	if err := c.Reload(context.Background(), changed); err == nil || !strings.Contains(err.Error(), "再起動") {
+		t.Errorf("プライマリの変更はエラーになるはず: %v", err)
+	}
+}
@@ -1,289 +1,17 @@
 package main
 
 import (
-	"database/sql"
 	"fmt"
-	"os"
-	"os/exec"
-	"strconv"
 	"strings"
 	"testing"
 	"time"
 
-	_ "github.com/lib/pq"
+	"postgres-replication-demo/replication"
 )
 
-// getEnv 環境変数を取得、存在しない場合はデフォルト値を返す
-func getEnv(key, defaultValue string) string {
-	if value := os.Getenv(key); value != "" {
-		return value
-	}
-	return defaultValue
-}
-
-// TestReplicationData レプリケーションデータ構造体
-type TestReplicationData struct {
-	ID        int
-	Data      string
-	CreatedAt time.Time
-}
-
-// TestDatabaseManager テスト用データベースマネージャー
-type TestDatabaseManager struct {
-	StandbyDB *sql.DB
-}
-
-// NewTestDatabaseManager テスト用データベースマネージャーを作成
-func NewTestDatabaseManager() (*TestDatabaseManager, error) {
-	// 環境変数から接続情報を取得（デモ用デフォルト値付き）
-	dbUser := getEnv("POSTGRES_USER", "postgres")
-	dbPassword := getEnv("POSTGRES_PASSWORD", "password")
-	dbName := getEnv("POSTGRES_DB", "testdb")
-
-	// Docker環境では異なるホスト名とポートを使用
-	standbyHost := getEnv("POSTGRES_STANDBY_HOST", "localhost")
-	standbyPort := getEnv("POSTGRES_STANDBY_PORT", "5433")
-	
-	// IPv4を強制するためにlocalhostを127.0.0.1に変換
-	if standbyHost == "localhost" {
-		standbyHost = "127.0.0.1"
-	}
-	
-	standbyConnStr := fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s sslmode=disable connect_timeout=10",
-		standbyHost, standbyPort, dbUser, dbPassword, dbName)
-	standbyDB, err := sql.Open("postgres", standbyConnStr)
-	if err != nil {
-		return nil, fmt.Errorf("スタンバイDB接続エラー: %v", err)
-	}
-
-	err = standbyDB.Ping()
-	if err != nil {
-		_ = standbyDB.Close()
-		return nil, fmt.Errorf("スタンバイDB ping エラー: %v", err)
-	}
-
-	return &TestDatabaseManager{StandbyDB: standbyDB}, nil
-}
-
-// Close データベース接続を閉じる
-func (tm *TestDatabaseManager) Close() {
-	if tm.StandbyDB != nil {
-		_ = tm.StandbyDB.Close()
-	}
-}
-
-// WriteToPrimary プライマリサーバーにデータを書き込み
-func (tm *TestDatabaseManager) WriteToPrimary(dataText string) (bool, int) {
-	// Docker環境では直接DB接続、ローカル環境ではdocker exec
-	if os.Getenv("POSTGRES_PRIMARY_HOST") != "" {
-		return tm.writeToPrimaryDirect(dataText)
-	}
-	return tm.writeToPrimaryDocker(dataText)
-}
-
-// writeToPrimaryDirect 直接DB接続でプライマリに書き込み
-func (tm *TestDatabaseManager) writeToPrimaryDirect(dataText string) (bool, int) {
-	dbUser := getEnv("POSTGRES_USER", "postgres")
-	dbPassword := getEnv("POSTGRES_PASSWORD", "password")
-	dbName := getEnv("POSTGRES_DB", "testdb")
-	primaryHost := getEnv("POSTGRES_PRIMARY_HOST", "localhost")
-	primaryPort := getEnv("POSTGRES_PRIMARY_PORT", "5432")
-	
-	// IPv4を強制するためにlocalhostを127.0.0.1に変換
-	if primaryHost == "localhost" {
-		primaryHost = "127.0.0.1"
-	}
-	
-	connStr := fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s sslmode=disable",
-		primaryHost, primaryPort, dbUser, dbPassword, dbName)
-	
-	db, err := sql.Open("postgres", connStr)
-	if err != nil {
-		return false, 0
-	}
-	defer func() { _ = db.Close() }()
-	
-	var id int
-	err = db.QueryRow("INSERT INTO test_replication (data) VALUES ($1) RETURNING id", dataText).Scan(&id)
-	if err != nil {
-		return false, 0
-	}
-	return true, id
-}
-
-// writeToPrimaryDocker docker exec経由でプライマリに書き込み
-func (tm *TestDatabaseManager) writeToPrimaryDocker(dataText string) (bool, int) {
-	cmd := exec.Command("docker", "exec", "postgres-primary",
-		"psql", "-U", "postgres", "-d", "testdb",
-		"-c", fmt.Sprintf("INSERT INTO test_replication (data) VALUES ('%s') RETURNING id;", dataText))
-
-	output, err := cmd.CombinedOutput()
-	if err != nil {
-		return false, 0
-	}
-
-	lines := strings.Split(strings.TrimSpace(string(output)), "\n")
-	for _, line := range lines {
-		line = strings.TrimSpace(line)
-		if id, err := strconv.Atoi(line); err == nil && id > 0 {
-			return true, id
-		}
-	}
-	return true, 0
-}
-
-// ReadFromStandby スタンバイサーバーからデータを読み取り
-func (tm *TestDatabaseManager) ReadFromStandby(limit int) ([]TestReplicationData, error) {
-	query := "SELECT id, data, created_at FROM test_replication ORDER BY created_at DESC LIMIT $1"
-	rows, err := tm.StandbyDB.Query(query, limit)
-	if err != nil {
-		return nil, fmt.Errorf("データ読み取りエラー: %v", err)
-	}
-	defer func() { _ = rows.Close() }()
-
-	var results []TestReplicationData
-	for rows.Next() {
-		var data TestReplicationData
-		err := rows.Scan(&data.ID, &data.Data, &data.CreatedAt)
-		if err != nil {
-			return nil, fmt.Errorf("データスキャンエラー: %v", err)
-		}
-		results = append(results, data)
-	}
-
-	return results, nil
-}
-
-// GetDataCount データ件数を取得
-func (tm *TestDatabaseManager) GetDataCount() (int, error) {
-	var count int
-	err := tm.StandbyDB.QueryRow("SELECT count(*) FROM test_replication").Scan(&count)
-	return count, err
-}
-
-// GetReplicationStatus レプリケーション遅延を取得
-func (tm *TestDatabaseManager) GetReplicationStatus() (float64, error) {
-	// Docker環境では直接DB接続、ローカル環境ではdocker exec
-	if os.Getenv("POSTGRES_PRIMARY_HOST") != "" {
-		return tm.getReplicationStatusDirect()
-	}
-	return tm.getReplicationStatusDocker()
-}
-
-// getReplicationStatusDirect 直接DB接続でレプリケーション状態を取得
-func (tm *TestDatabaseManager) getReplicationStatusDirect() (float64, error) {
-	dbUser := getEnv("POSTGRES_USER", "postgres")
-	dbPassword := getEnv("POSTGRES_PASSWORD", "password")
-	dbName := getEnv("POSTGRES_DB", "testdb")
-	primaryHost := getEnv("POSTGRES_PRIMARY_HOST", "localhost")
-	primaryPort := getEnv("POSTGRES_PRIMARY_PORT", "5432")
-	
-	// IPv4を強制するためにlocalhostを2127.0.0.1に変換
-	if primaryHost == "localhost" {
-		primaryHost = "127.0.0.1"
-	}
-	
-	connStr := fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s sslmode=disable",
-		primaryHost, primaryPort, dbUser, dbPassword, dbName)
-	
-	db, err := sql.Open("postgres", connStr)
-	if err != nil {
-		return -1, fmt.Errorf("プライマリDB接続エラー: %v", err)
-	}
-	defer func() { _ = db.Close() }()
-	
-	var lag sql.NullFloat64
-	query := `SELECT COALESCE(EXTRACT(EPOCH FROM (now() - pg_last_xact_replay_timestamp())), 0) FROM pg_stat_replication WHERE state = 'streaming' LIMIT 1`
-	err = db.QueryRow(query).Scan(&lag)
-	if err != nil {
-		// レプリケーションが接続されていない場合
-		return 0, nil
-	}
-	
-	if lag.Valid {
-		return lag.Float64, nil
-	}
-	return 0, nil
-}
-
-// getReplicationStatusDocker docker exec経由でレプリケーション状態を取得
-func (tm *TestDatabaseManager) getReplicationStatusDocker() (float64, error) {
-	cmd := exec.Command("docker", "exec", "postgres-primary",
-		"psql", "-U", "postgres", "-d", "testdb", "-t",
-		"-c", `SELECT COALESCE(EXTRACT(EPOCH FROM (now() - pg_last_xact_replay_timestamp())), 0) FROM pg_stat_replication WHERE state = 'streaming' LIMIT 1;`)
-
-	output, err := cmd.CombinedOutput()
-	if err != nil {
-		return -1, fmt.Errorf("レプリケーション状態取得エラー: %v", err)
-	}
-
-	lagStr := strings.TrimSpace(string(output))
-	if lagStr == "" {
-		return 0, nil
-	}
-
-	lag, err := strconv.ParseFloat(lagStr, 64)
-	if err != nil {
-		return 0, nil
-	}
-
-	return lag, nil
-}
-
-// TestConnection データベース接続をテスト
-func (tm *TestDatabaseManager) TestConnection() bool {
-	// スタンバイ接続テスト
-	var standbyVersion string
-	err := tm.StandbyDB.QueryRow("SELECT version()").Scan(&standbyVersion)
-	if err != nil {
-		return false
-	}
-
-	// プライマリ接続テスト
-	if os.Getenv("POSTGRES_PRIMARY_HOST") != "" {
-		return tm.testPrimaryConnectionDirect()
-	}
-	return tm.testPrimaryConnectionDocker()
-}
-
-// testPrimaryConnectionDirect 直接DB接続でプライマリをテスト
-func (tm *TestDatabaseManager) testPrimaryConnectionDirect() bool {
-	dbUser := getEnv("POSTGRES_USER", "postgres")
-	dbPassword := getEnv("POSTGRES_PASSWORD", "password")
-	dbName := getEnv("POSTGRES_DB", "testdb")
-	primaryHost := getEnv("POSTGRES_PRIMARY_HOST", "localhost")
-	primaryPort := getEnv("POSTGRES_PRIMARY_PORT", "5432")
-	
-	// IPv4を強制するためにlocalhostを2127.0.0.1に変換
-	if primaryHost == "localhost" {
-		primaryHost = "127.0.0.1"
-	}
-	
-	connStr := fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s sslmode=disable",
-		primaryHost, primaryPort, dbUser, dbPassword, dbName)
-	
-	db, err := sql.Open("postgres", connStr)
-	if err != nil {
-		return false
-	}
-	defer func() { _ = db.Close() }()
-	
-	var version string
-	err = db.QueryRow("SELECT version()").Scan(&version)
-	return err == nil
-}
-
-// testPrimaryConnectionDocker docker exec経由でプライマリをテスト
-func (tm *TestDatabaseManager) testPrimaryConnectionDocker() bool {
-	cmd := exec.Command("docker", "exec", "postgres-primary",
-		"psql", "-U", "postgres", "-d", "testdb", "-t", "-c", "SELECT version();")
-	_, err := cmd.CombinedOutput()
-	return err == nil
-}
-
 // TestDatabaseConnection データベース接続テスト
 func TestDatabaseConnection(t *testing.T) {
-	tm, err := NewTestDatabaseManager()
+	tm, err := replication.OpenFromEnv()
 	if err != nil {
 		t.Fatalf("データベースマネージャー作成エラー: %v", err)
 	}
@@ -298,7 +26,7 @@ func TestDatabaseConnection(t *testing.T) {
 
 // TestBasicReplication 基本的なレプリケーション機能テスト
 func TestBasicReplication(t *testing.T) {
-	tm, err := NewTestDatabaseManager()
+	tm, err := replication.OpenFromEnv()
 	if err != nil {
 		t.Fatalf("データベースマネージャー作成エラー: %v", err)
 	}
@@ -312,16 +40,13 @@ func TestBasicReplication(t *testing.T) {
 
 	// データ書き込み
 	testData := fmt.Sprintf("Test data at %s", time.Now().Format("2006-01-02 15:04:05"))
-	writeSuccess, _ := tm.WriteToPrimary(testData)
-	if !writeSuccess {
-		t.Fatal("データ書き込みに失敗")
+	_, lsn, err := tm.WriteToPrimary(testData)
+	if err != nil {
+		t.Fatalf("データ書き込みに失敗: %v", err)
 	}
 
-	// レプリケーション待機
-	time.Sleep(2 * time.Second)
-
-	// 最終データ件数確認
-	finalCount, err := tm.GetDataCount()
+	// 書き込みのLSNまでスタンバイが再生してから最終データ件数確認
+	finalCount, err := tm.GetDataCount(replication.AfterLSN(lsn))
 	if err != nil {
 		t.Fatalf("最終データ件数取得エラー: %v", err)
 	}
@@ -335,7 +60,7 @@ func TestBasicReplication(t *testing.T) {
 
 // TestReplicationLag レプリケーション遅延テスト
 func TestReplicationLag(t *testing.T) {
-	tm, err := NewTestDatabaseManager()
+	tm, err := replication.OpenFromEnv()
 	if err != nil {
 		t.Fatalf("データベースマネージャー作成エラー: %v", err)
 	}
@@ -356,7 +81,7 @@ func TestReplicationLag(t *testing.T) {
 
 // TestReadWriteSeparation 読み書き分離テスト
 func TestReadWriteSeparation(t *testing.T) {
-	tm, err := NewTestDatabaseManager()
+	tm, err := replication.OpenFromEnv()
 	if err != nil {
 		t.Fatalf("データベースマネージャー作成エラー: %v", err)
 	}
@@ -365,17 +90,16 @@ func TestReadWriteSeparation(t *testing.T) {
 	// 書き込み性能測定
 	writeStart := time.Now()
 	testData := fmt.Sprintf("Performance test at %s", time.Now().Format("2006-01-02T15:04:05"))
-	writeSuccess, _ := tm.WriteToPrimary(testData)
+	_, lsn, err := tm.WriteToPrimary(testData)
 	writeTime := time.Since(writeStart)
 
-	if !writeSuccess {
-		t.Fatal("書き込み処理に失敗")
+	if err != nil {
+		t.Fatalf("書き込み処理に失敗: %v", err)
 	}
 
-	// 読み取り性能測定
-	time.Sleep(500 * time.Millisecond)
+	// 読み取り性能測定（スタンバイが書き込みのLSNを再生するまでの待機を含む）
 	readStart := time.Now()
-	_, err = tm.ReadFromStandby(1)
+	_, err = tm.ReadFromStandby(1, replication.AfterLSN(lsn))
 	readTime := time.Since(readStart)
 
 	if err != nil {
@@ -388,7 +112,7 @@ func TestReadWriteSeparation(t *testing.T) {
 
 // TestDataConsistency データ整合性テスト
 func TestDataConsistency(t *testing.T) {
-	tm, err := NewTestDatabaseManager()
+	tm, err := replication.OpenFromEnv()
 	if err != nil {
 		t.Fatalf("データベースマネージャー作成エラー: %v", err)
 	}
@@ -396,22 +120,19 @@ func TestDataConsistency(t *testing.T) {
 
 	baseTime := time.Now().Format("20060102_150405")
 	successCount := 0
+	var lastLSN replication.LSN
 
 	// 3件の連続書き込み
 	for i := 0; i < 3; i++ {
 		data := fmt.Sprintf("Consistency test %d - %s", i+1, baseTime)
-		success, _ := tm.WriteToPrimary(data)
-		if success {
+		if _, lsn, err := tm.WriteToPrimary(data); err == nil {
 			successCount++
+			lastLSN = lsn
 		}
-		time.Sleep(300 * time.Millisecond)
 	}
 
-	// レプリケーション待機
-	time.Sleep(2 * time.Second)
-
-	// 整合性確認
-	data, err := tm.ReadFromStandby(10)
+	// 最後の書き込みのLSNまでスタンバイが再生してから整合性確認
+	data, err := tm.ReadFromStandby(10, replication.AfterLSN(lastLSN))
 	if err != nil {
 		t.Fatalf("データ読み取りエラー: %v", err)
 	}
@@ -432,7 +153,7 @@ func TestDataConsistency(t *testing.T) {
 
 // TestPerformanceBenchmark パフォーマンスベンチマークテスト
 func TestPerformanceBenchmark(t *testing.T) {
-	tm, err := NewTestDatabaseManager()
+	tm, err := replication.OpenFromEnv()
 	if err != nil {
 		t.Fatalf("データベースマネージャー作成エラー: %v", err)
 	}
@@ -445,18 +166,17 @@ func TestPerformanceBenchmark(t *testing.T) {
 		// 書き込み時間測定
 		writeStart := time.Now()
 		testData := fmt.Sprintf("Benchmark test #%d", i+1)
-		success, _ := tm.WriteToPrimary(testData)
+		_, lsn, err := tm.WriteToPrimary(testData)
 		writeTime := time.Since(writeStart).Seconds()
 
-		if success {
-			writeTimes = append(writeTimes, writeTime)
+		if err != nil {
+			continue
 		}
+		writeTimes = append(writeTimes, writeTime)
 
-		time.Sleep(300 * time.Millisecond)
-
-		// 読み取り時間測定
+		// 読み取り時間測定（スタンバイが書き込みのLSNを再生するまでの待機を含む）
 		readStart := time.Now()
-		_, err := tm.ReadFromStandby(1)
+		_, err = tm.ReadFromStandby(1, replication.AfterLSN(lsn))
 		readTime := time.Since(readStart).Seconds()
 
 		if err == nil {
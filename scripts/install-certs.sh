@@ -0,0 +1,20 @@
+#!/bin/bash
+set -e
+
+# TLS証明書の配置スクリプト
+# cmd/tls_setup で生成した証明書（/certs にマウント）を postgres ユーザーが読めるようにコピーする。
+# PostgreSQLとlibpqは秘密鍵のパーミッションが0600でないと使わないため、マウントしたファイルを直接参照しない
+
+CERT_SRC=/certs
+CERT_DIR=/var/lib/postgresql/certs
+
+if [ ! -f "$CERT_SRC/root.crt" ]; then
+    echo "TLS certificates not found in $CERT_SRC (run cmd/tls_setup to enable TLS)"
+    exit 0
+fi
+
+mkdir -p "$CERT_DIR"
+cp "$CERT_SRC"/*.crt "$CERT_SRC"/*.key "$CERT_DIR"/
+chown -R postgres:postgres "$CERT_DIR"
+chmod 600 "$CERT_DIR"/*.key
+echo "TLS certificates installed in $CERT_DIR"
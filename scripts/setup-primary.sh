@@ -5,6 +5,11 @@ set -e
 
 echo "Setting up PostgreSQL Primary Server for Streaming Replication"
 
+# Docker secretsなどでファイルが指定されていればパスワードをファイルから読む
+if [ -n "$POSTGRES_REPLICATION_PASSWORD_FILE" ]; then
+    POSTGRES_REPLICATION_PASSWORD="$(cat "$POSTGRES_REPLICATION_PASSWORD_FILE")"
+fi
+
 # レプリケーション用ユーザーを作成
 psql -v ON_ERROR_STOP=1 --username "$POSTGRES_USER" --dbname "$POSTGRES_DB" <<-EOSQL
     -- レプリケーション用ユーザー作成
@@ -23,6 +28,19 @@ psql -v ON_ERROR_STOP=1 --username "$POSTGRES_USER" --dbname "$POSTGRES_DB" <<-E
         created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
     );
     
+    -- レプリケーション遅延計測用のハートビートテーブル作成（replication.StartHeartbeatが書き込む）
+    CREATE TABLE IF NOT EXISTS replication_heartbeat (
+        id integer PRIMARY KEY,
+        written_at timestamptz NOT NULL
+    );
+
+    -- 読み取りのカウンタ公開用テーブル作成（replication.StartMetricsPublisherが書き込み、replication_exporterが読み取る）
+    CREATE TABLE IF NOT EXISTS replication_routing_metrics (
+        instance text PRIMARY KEY,
+        metrics jsonb NOT NULL,
+        updated_at timestamptz NOT NULL
+    );
+    
     -- サンプルデータ挿入
     INSERT INTO test_replication (data) VALUES 
         ('Primary server initial data'),